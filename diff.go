@@ -0,0 +1,60 @@
+package main
+
+import (
+	"anubis/pkg"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// diff compares two snapshots of an output directory and prints the URLs which changed. The second revision
+// defaults to HEAD.
+func diff(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	dir := flags.String("dir", ".", "The output directory to compare snapshots of")
+	format := flags.String("format", "text", "The report format: text, json or html")
+
+	if err := flags.Parse(args); err != nil {
+		panic(err)
+	}
+
+	revisions := flags.Args()
+	if len(revisions) == 1 {
+		revisions = append(revisions, "HEAD")
+	}
+	if len(revisions) != 2 {
+		_, _ = fmt.Fprintln(flags.Output(), "diff needs one or two revisions")
+		os.Exit(2)
+	}
+
+	repo, err := anubis.OpenGitRepository(*dir)
+	if err != nil {
+		panic(err)
+	}
+
+	commits := make([]anubis.GitCommit, 2)
+	for i, rev := range revisions {
+		if commits[i], err = repo.ResolveRevision(rev); err != nil {
+			panic(err)
+		}
+	}
+
+	changes, err := anubis.DiffSnapshots(repo, commits[0], commits[1])
+	if err != nil {
+		panic(err)
+	}
+
+	switch *format {
+	case "text":
+		err = changes.WriteText(os.Stdout)
+	case "json":
+		err = changes.WriteJSON(os.Stdout)
+	case "html":
+		err = changes.WriteHTML(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown diff format %q", *format)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -4,39 +4,232 @@ import (
 	"anubis/pkg"
 	"flag"
 	"fmt"
+	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// hostLimits collects every -host-limit flag
+type hostLimits []string
+
+func (limits *hostLimits) String() string { return strings.Join(*limits, " ") }
+
+func (limits *hostLimits) Set(s string) error {
+	*limits = append(*limits, s)
+	return nil
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		serve(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		diff(os.Args[2:])
+		return
+	}
 
+	os.Exit(archive())
+}
+
+// archive runs the archiver with the command line flags, returning the exit status. It returns rather
+// than exiting so that the WARC file is closed on every path.
+func archive() int {
 	output := flag.String("output", ".", "The output directory. Note that if you are preserving only a single page, the full path to the file will be created.")
 	proxy := flag.String("proxy", "", "Specifies the proxy to use during program execution")
 	nWorkers := flag.Int("workers", 4, "Maximum number of concurrent requests")
+	rewrite := flag.Bool("rewrite", true, "Rewrite links in archived pages and stylesheets to point at the local copies")
+	crawl := flag.Bool("crawl", false, "Follow links to other pages on the same host and within the same directory as the start URLs")
+	maxDepth := flag.Int("depth", 0, "Maximum number of links to follow from a start URL when crawling. 0 means no limit")
+	maxPages := flag.Int("max-pages", 0, "Maximum number of pages to archive when crawling. 0 means no limit")
+	userAgent := flag.String("user-agent", "", "The User-Agent header to send with each request, also used to match rules in robots.txt")
+	robots := flag.Bool("robots", false, "Honour each host's robots.txt, skipping disallowed URLs and respecting Crawl-delay")
+	sitemap := flag.String("sitemap", "", "A sitemap or sitemap index listing pages to archive, or 'robots' to read the sitemaps declared in each start URL's robots.txt")
+	sitemapSince := flag.Bool("sitemap-since-last-commit", false, "Only archive sitemap pages modified since the last snapshot of the output directory, as recorded by the committer")
+	rate := flag.String("rate", "", "Maximum request rate for each host, such as 2/s or 30/m")
+	perHost := flag.Int("per-host", 0, "Maximum number of concurrent requests to each host. 0 means no limit")
+	limits := hostLimits{}
+	flag.Var(&limits, "host-limit", "Override the limits for one host as host=rate[,concurrency], such as cdn.example.com=20/s,8. May be repeated")
+	maxAttempts := flag.Int("max-attempts", anubis.DefaultRetryPolicy.MaxAttempts, "Maximum number of attempts for each URL, including the first")
+	retryStatus := flag.String("retry-status", "408,429,500,502,503,504", "Comma-separated response status codes which are retried")
+	status := flag.String("status", "", "Comma-separated rules for responses by status class or code, such as 4xx=skip,5xx=fail,404=errors. Actions are save, keep, skip, errors and fail")
+	format := flag.String("format", "tree", "Comma-separated output formats: tree mirrors each response below the output directory, warc writes a WARC/1.1 file")
+	warcFile := flag.String("warc-file", "", "The WARC file written when the format includes warc. Defaults to anubis-<time>.warc.gz in the current directory")
+	committer := flag.String("committer", "native", "How snapshots are committed: native writes git objects directly, git runs the git binary so its configuration and hooks apply, links copies the output to a dated directory with unchanged files hard linked, and tar writes a dated .tar.gz archive")
+	snapshotDir := flag.String("snapshot-dir", "", "The directory which holds snapshots with the links and tar committers. Defaults to .snapshots within the output directory")
+	authorName := flag.String("author-name", "", "The name used to author each commit. Requires -author-email. Defaults to Anubis, or git's configuration with -committer git")
+	authorEmail := flag.String("author-email", "", "The email address used to author each commit. Requires -author-name. Defaults to anubis@localhost, or git's configuration with -committer git")
+	srcset := flag.String("srcset", "all", "Which candidates of each srcset attribute to archive: all, or only the largest")
+	placeholder := flag.String("placeholder", "", "If set, links to files which were not archived are replaced with this URL when rewriting")
 
 	flag.Parse()
 
-	a := anubis.NewAnubis(anubis.OutputOpt(*output), anubis.ProxyOpt(*proxy), anubis.NWorkerOpt(*nWorkers))
+	options := []anubis.Option{
+		anubis.OutputOpt(*output),
+		anubis.ProxyOpt(*proxy),
+		anubis.NWorkerOpt(*nWorkers),
+		anubis.RewriteOpt(*rewrite),
+		anubis.PlaceholderOpt(*placeholder),
+		anubis.RobotsOpt(*robots),
+	}
+
+	if *userAgent != "" {
+		options = append(options, anubis.HeaderOpt{Key: "User-Agent", Value: *userAgent})
+	}
+
+	var c anubis.Committer = anubis.NativeCommitter{}
+	switch *committer {
+	case "native":
+	case "git":
+		c = anubis.ExecCommitter{}
+	case "links":
+		c = anubis.LinkCommitter{Dir: *snapshotDir}
+	case "tar":
+		c = anubis.TarCommitter{Dir: *snapshotDir}
+	default:
+		panic("Unknown committer " + *committer)
+	}
+	options = append(options, anubis.CommitterOpt{Committer: c})
+
+	if *authorName != "" || *authorEmail != "" {
+		options = append(options, anubis.AuthorOpt{Name: *authorName, Email: *authorEmail})
+	}
+
+	if *sitemap != "" {
+		options = append(options, anubis.SitemapOpt(*sitemap))
+	}
+
+	if *sitemapSince {
+		since, err := anubis.LastSnapshotTime(c, *output)
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, anubis.SitemapSinceOpt(since))
+	}
+
+	if *rate != "" {
+		r, err := anubis.ParseRate(*rate)
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, anubis.RateLimitOpt{Rate: r})
+	}
+
+	if *perHost > 0 {
+		options = append(options, anubis.PerHostOpt(*perHost))
+	}
+
+	for _, s := range limits {
+		host, limit, err := anubis.ParseHostLimit(s)
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, anubis.HostLimitOpt{Host: host, Limit: limit})
+	}
+
+	policy := anubis.DefaultRetryPolicy
+	policy.MaxAttempts = *maxAttempts
+	policy.RetryStatus = nil
+	for _, s := range strings.Split(*retryStatus, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		status, err := strconv.Atoi(s)
+		if err != nil {
+			panic(err)
+		}
+		policy.RetryStatus = append(policy.RetryStatus, status)
+	}
+	options = append(options, anubis.RetryOpt(policy))
+
+	if *status != "" {
+		policy, err := anubis.ParseStatusPolicy(*status)
+		if err != nil {
+			panic(err)
+		}
+		options = append(options, anubis.StatusPolicyOpt(policy))
+	}
+
+	srcsetPolicy, err := anubis.ParseSrcsetPolicy(*srcset)
+	if err != nil {
+		panic(err)
+	}
+	options = append(options, anubis.SrcsetOpt(srcsetPolicy))
+
+	formats, err := anubis.ParseOutputFormat(*format)
+	if err != nil {
+		panic(err)
+	}
+	options = append(options, anubis.FormatOpt(formats))
+
+	if formats&anubis.WARCFormat != 0 {
+		if *warcFile == "" {
+			*warcFile = "anubis-" + time.Now().UTC().Format("20060102150405") + ".warc.gz"
+		}
+
+		writer, err := anubis.CreateWARC(*warcFile)
+		if err != nil {
+			panic(err)
+		}
+		defer writer.Close()
+		options = append(options, anubis.WARCOpt{Writer: writer})
+	}
+
+	if *crawl {
+		options = append(options, anubis.CrawlOpt{MaxDepth: *maxDepth, MaxPages: *maxPages})
+	}
+
+	a := anubis.NewAnubis(options...)
 
 	startURLs := flag.Args()
 
 	// Print error if no start URLs were provided
-	if len(startURLs) == 0 {
-		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "%s [ options... ] [ urls... ]\n", os.Args[0])
+	if len(startURLs) == 0 && *sitemap == "" {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "%s [ options... ] [ urls... ]\n%s serve [ -addr address ] [ -dir output ]\n%s diff [ -dir output ] [ -format text|json|html ] rev-a [ rev-b ]\n", os.Args[0], os.Args[0], os.Args[0])
 		flag.Usage()
-		return
+		return 0
 	}
 
 	for _, url := range flag.Args() {
-		a.AddURL(url)
-
-		// Initialize start urls in the instance's handler
-		a.Handler.(anubis.DefaultResponseHandler).NeededLinks[url] = true
+		a.AddStartURL(url)
 	}
 
 	a.Start()
+
+	// If every URL was rejected there is nothing to wait for
+	if a.Pending() == 0 {
+		a.Cancel()
+	}
+
 	a.Wait()
 
+	for _, failure := range a.Failures() {
+		log.Println("Failed:", failure.URL, failure.Err)
+	}
+
+	// A failed run is not rewritten or committed
+	if err := a.Err(); err != nil {
+		log.Println("Run failed:", err)
+		return 1
+	}
+
+	// Without the tree there is nothing to rewrite or commit
+	if formats&anubis.TreeFormat == 0 {
+		return 0
+	}
+
+	if err := a.Rewrite(); err != nil {
+		panic(err)
+	}
+
+	if err := a.SaveManifest(); err != nil {
+		panic(err)
+	}
+
 	if err := a.Commit(); err != nil {
 		panic(err)
 	}
+	return 0
 }
@@ -3,10 +3,8 @@ package anubis
 import (
 	"context"
 	"log"
-	"net/http"
 	"os"
-	"os/exec"
-	"strings"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -17,19 +15,57 @@ type Anubis struct {
 	// The output file will match the URL, so if Output is './src' and the perserved URL is
 	// 'www.test.com/about.html', then the final output will be ./src/www.test.com/about.html.
 	// This allows for links in the HTML page to be changed to relative paths, so when the page
-	// is visited locally, no network requests will be necessary.
+	// is visited locally, no network requests will be necessary. See Rewrite.
 	Output string
 
+	Format OutputFormat // Format selects whether responses are mirrored to Output, recorded in WARC, or both
+	WARC   *WARCWriter  // WARC receives a record for each response when Format includes WARCFormat
+
+	Manifest     *Manifest // Manifest records every file written to Output during this run
+	RewriteLinks bool      // RewriteLinks enables rewriting references in archived pages to local paths
+	Placeholder  string    // Placeholder replaces references to assets and pages which were not archived, if set
+
+	Srcset SrcsetPolicy // Srcset decides which candidates of each srcset attribute are fetched
+
 	Workers int               // Workers indicates how many worker goroutines to use
 	Headers map[string]string // Headers specifies all headers used during each network request
 
 	Driver  WebDriver       // Driver is a WebDriver instance which will dictate how the network requests are made
 	Handler ResponseHandler // Handler controls how the responses are handled before copied to a file
+	Mapper  PathMapper      // Mapper decides where the default handler writes each response within Output
 	Filter  DuplicateFilter // Filter will be used to ensure URLs are only fetched once
+	Robots  *RobotsPolicy   // Robots, if set, is consulted before a URL is added to the queue
+	Limiter *HostLimiter    // Limiter, if set, limits the rate and concurrency of requests to each host
+	Retry   RetryPolicy     // Retry controls how failed requests are retried by the default RequestProcessor
+
+	// StatusPolicy controls what the DefaultResponseHandler does with a response based on its status code
+	StatusPolicy StatusPolicy
+
+	Sitemaps     []string  // Sitemaps are read when the instance starts, and every page they list is added
+	SitemapSince time.Time // SitemapSince skips sitemap pages which were last modified before it, if set
+
+	Crawl    bool // Crawl enables following links to other pages within the scope of the start URLs
+	MaxDepth int  // MaxDepth limits how many links are followed from a start URL when crawling, if positive
+	MaxPages int  // MaxPages limits the number of pages fetched when crawling, if positive
+
+	Committer Committer // Committer records the output directory as a new commit, see Commit
+
+	// AuthorName and AuthorEmail identify the author and committer of each commit. If either is empty,
+	// the Committer's default identity is used.
+	AuthorName, AuthorEmail string
 
 	wg        *sync.WaitGroup  // wg is used to ensure that all workers finish before the program exits
-	queue     chan string      // queue is used to pass URLs to worker goroutines
+	queue     *frontier        // queue holds URLs until they can be passed to worker goroutines
+	tracker   *workTracker     // tracker counts URLs which are queued or being processed
 	processor RequestProcessor // The request processor to use for each worker. Mainly useful for testing
+	crawl     *crawlState      // crawl tracks the scope and depth of pages when crawling
+	failures  *failureLog      // failures records every URL which could not be archived
+	stats     *runStats        // stats counts the URLs archived for each host, for the commit message
+
+	webManifests *sync.Map       // webManifests holds the URLs pages link to with rel=manifest
+	importMaps   *importMapSet   // importMaps combines the import maps of every page, to resolve scripts' imports
+	stored       *storedManifest // stored is the manifest written to Output by earlier runs
+	pendingStubs *pendingStubs   // pendingStubs holds redirects to targets which have not been recorded yet
 
 	Context context.Context // Context associated with this instance
 	Cancel  func()          // Cancel should be called when the program should finish work
@@ -42,19 +78,39 @@ func NewAnubis(options ...Option) *Anubis {
 		Output:  ".",
 		Workers: 4,
 		Headers: make(map[string]string),
-		Driver:  DefaultWebDriver{client: *http.DefaultClient},
+		Driver:  DefaultWebDriver{client: newHTTPClient(nil)},
 		Filter:  &DefaultDuplicateFilter{&sync.Map{}},
 		Handler: nil,
-		wg:      &sync.WaitGroup{},
-		queue:   make(chan string, 256),
-		Context: context.TODO(),
+		Mapper:  DefaultPathMapper{},
+		Retry:   DefaultRetryPolicy,
+
+		Committer: NativeCommitter{},
+
+		StatusPolicy: DefaultStatusPolicy(),
+
+		Format:       TreeFormat,
+		Manifest:     NewManifest(),
+		RewriteLinks: true,
+
+		wg:       &sync.WaitGroup{},
+		queue:    newFrontier(),
+		tracker:  &workTracker{},
+		crawl:    newCrawlState(),
+		failures: &failureLog{},
+		stats:    newRunStats(),
+
+		webManifests: &sync.Map{},
+		importMaps:   &importMapSet{},
+		stored:       &storedManifest{},
+		pendingStubs: &pendingStubs{},
+		Context:      context.TODO(),
 		Cancel: func() {
 			panic("Anubis has not started, cannot cancel")
 		},
 	}
 
-	a.Handler = DefaultResponseHandler{a, make(map[string]bool)}
-	a.processor = &DefaultRequestProcessor{}
+	a.Handler = DefaultResponseHandler{a}
+	a.processor = &DefaultRequestProcessor{a}
 
 	for _, opt := range options {
 		opt.SetOpt(a)
@@ -67,78 +123,113 @@ func NewAnubis(options ...Option) *Anubis {
 func (a *Anubis) Start() {
 	ctx, cancel := context.WithCancel(a.Context)
 	a.Context = ctx
+	a.stats.start()
 	a.Cancel = func() {
 		cancel()
-		// Close queue so workers will stop processing when buffer is drained
-		close(a.queue)
+		// Close queue so workers will stop processing when the frontier is drained
+		a.queue.close()
 	}
 
+	// Sitemaps are read before any worker starts, so the instance cannot finish before they are added
+	a.addSitemaps()
+
+	go a.queue.dispatch()
+
 	for n := 0; n < a.Workers; n++ {
 		a.wg.Add(1)
-		go a.worker(a.processor, a.queue)
+		go a.worker(a.processor, a.queue.ch)
 	}
 }
 
-// Wait for all work to complete
+// Wait for all work to complete. Wait returns once every URL added to the instance has been processed,
+// including any URLs added while processing, or once Cancel has been called and the queue is drained.
+// If no URLs are ever added, Wait blocks until Cancel is called.
 func (a *Anubis) Wait() {
 	a.wg.Wait()
+	a.stats.finish()
 }
 
-// AddURL will push a new url to the queue if it is not a duplicate. This function may block the caller
-// until the queue's buffer is not full.
-//
-// The function will return true if the link was added to the queue, and false otherwise.
-func (a *Anubis) AddURL(url string) bool {
-	if a.Context.Err() != nil {
-		return false
-	}
+// Failures returns every URL which could not be archived, along with the reason, in the order they failed
+func (a *Anubis) Failures() []Failure {
+	return a.failures.all()
+}
 
-	if !a.Filter.TestURL(url) {
-		a.queue <- url
-		return true
+// Fail stops the instance because of an unrecoverable error. Only the first error is kept, and can be
+// retrieved using Err.
+func (a *Anubis) Fail(err error) {
+	if a.failures.fail(err) {
+		a.Cancel()
 	}
+}
 
-	// URL was already processed
-	return false
+// Err returns the error which stopped the instance, or nil if it has not failed
+func (a *Anubis) Err() error {
+	return a.failures.err()
 }
 
-// Commit will use git to commit the files with the output directory specified by the start options.
-// If Anubis is started as a crawler, then this would commit all files changed up to that point
-func (a Anubis) Commit() error {
-	// Initialize repo if not already exist
-	cmd := exec.Command("git", "-C", a.Output, "init")
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return err
+// writeOutput writes a file to the output directory, creating any parent directories. The path is
+// slash-separated and relative to Output. Nothing is written unless Format includes TreeFormat.
+func (a *Anubis) writeOutput(filename string, body []byte) error {
+	if a.Format&TreeFormat == 0 {
+		return nil
 	}
 
-	// Add all changes
-	cmd = exec.Command("git", "-C", a.Output, "add", "-A")
-	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	p := a.outputPath(filename)
+
+	if err := os.MkdirAll(filepath.Dir(p), 0774); err != nil {
 		return err
 	}
 
-	commitBuilder := strings.Builder{}
-	commitBuilder.WriteRune('"')
-	commitBuilder.WriteString(time.Now().Format(time.RFC3339))
-	commitBuilder.WriteRune('"')
+	return os.WriteFile(p, body, 0644)
+}
+
+// outputPath converts a slash-separated path relative to Output into a file system path
+func (a *Anubis) outputPath(filename string) string {
+	return filepath.Join(a.Output, filepath.FromSlash(filename))
+}
+
+// Pending returns the number of URLs which have been added to the instance but not yet processed
+func (a *Anubis) Pending() int {
+	return a.tracker.count()
+}
 
-	// Add additional information about the system
+// AddURL will push a new url to the queue if it is not a duplicate. The queue is unbounded, so this
+// function only blocks the caller when Robots is set and the URL's host has not been seen before, while
+// its robots.txt is fetched.
+//
+// The function will return true if the link was added to the queue, and false otherwise.
+func (a *Anubis) AddURL(url string) bool {
+	if a.Context.Err() != nil {
+		return false
+	}
 
-	// Commit changes
-	cmd = exec.Command("git", "-C", a.Output, "commit", "-m", commitBuilder.String())
-	cmd.Stderr = os.Stderr
+	// Disallowed URLs are not marked as seen, so the filter only holds URLs which were queued
+	if a.Robots != nil && !a.Robots.Allowed(url) {
+		log.Println("Skipping", url, "disallowed by robots.txt")
+		return false
+	}
 
-	// Check if output contains 'nothing to commit', in which case there was no error
-	b, err := cmd.Output()
-	if err != nil {
-		if !strings.Contains(string(b), "nothing to commit") {
-			return err
-		}
+	// URL was already processed
+	if a.Filter.TestURL(url) {
+		return false
 	}
 
-	return nil
+	// The URL is counted before it is queued, so that the count cannot drop to zero while it is
+	// waiting for a worker
+	a.tracker.add()
+	if !a.queue.push(url) {
+		a.tracker.done()
+		return false
+	}
+
+	return true
+}
+
+// Commit will use git to commit the files with the output directory specified by the start options.
+// If Anubis is started as a crawler, then this would commit all files changed up to that point. The
+// commit message describes the run, see RunStats.CommitMessage.
+func (a Anubis) Commit() error {
+	return a.Committer.Commit(a.Output, a.Stats().CommitMessage(), GitIdentity{Name: a.AuthorName, Email: a.AuthorEmail})
 }
 
 // Each worker will read URLs from the channel until the context is cancelled or the queue is closed.
@@ -146,9 +237,36 @@ func (a Anubis) Commit() error {
 func (a Anubis) worker(processor RequestProcessor, queue chan string) {
 	defer a.wg.Done()
 
+	driver := a.requestDriver()
+
 	for url := range queue {
-		if err := processor.Process(url, a.Headers, a.Driver, a.Handler); err != nil {
-			log.Println(err)
+		// Once the run has failed, the remaining URLs are drained without being fetched
+		if a.Err() == nil {
+			if err := processor.Process(url, a.Headers, driver, a.Handler); err != nil {
+				log.Println(err)
+				a.failures.add(url, err)
+			}
+		}
+
+		// The instance is finished once the last pending URL has been processed
+		if a.tracker.done() {
+			a.Cancel()
 		}
 	}
 }
+
+// requestDriver returns the WebDriver used by workers, which wraps the instance's Driver with any
+// configured politeness policies
+func (a Anubis) requestDriver() WebDriver {
+	driver := a.Driver
+
+	if a.Limiter != nil {
+		driver = limitedDriver{driver, a.Limiter}
+	}
+
+	if a.Robots != nil {
+		driver = crawlDelayDriver{driver, a.Robots}
+	}
+
+	return driver
+}
@@ -221,6 +221,77 @@ func TestAnubis_Start(t *testing.T) {
 	})
 }
 
+// SpawningProcessor adds each URL's children to the instance when processing it, like a response handler
+// finding links in a page
+type SpawningProcessor struct {
+	anubis   *Anubis
+	children map[string][]string
+	StringProcessor
+}
+
+func (processor *SpawningProcessor) Process(url string, h map[string]string, d WebDriver, r ResponseHandler) error {
+	// Simulate a slow page, so that the queue is empty while work is still in flight
+	if len(processor.children[url]) > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, child := range processor.children[url] {
+		processor.anubis.AddURL(child)
+	}
+	return processor.StringProcessor.Process(url, h, d, r)
+}
+
+func TestAnubis_Wait(t *testing.T) {
+	t.Run("Wait returns once all URLs, including those found while processing, are processed", func(t *testing.T) {
+		a := NewTestAnubis()
+		processor := &SpawningProcessor{
+			anubis: a,
+			children: map[string][]string{
+				"a": {"b", "c"},
+				"b": {"d"},
+				"d": {"e", "a"},
+			},
+			StringProcessor: StringProcessor{mu: &sync.Mutex{}},
+		}
+		a.processor = processor
+
+		a.AddURL("a")
+		a.Start()
+
+		done := make(chan struct{})
+		go func() {
+			a.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Wait() did not return")
+		}
+
+		if len(processor.results) != 5 {
+			t.Errorf("processed %v, want 5 URLs", processor.results)
+		}
+	})
+
+	t.Run("Cancel may be called more than once", func(t *testing.T) {
+		a := NewTestAnubis()
+
+		defer func() {
+			if err := recover(); err != nil {
+				t.Errorf("Anubis panicked: %v", err)
+			}
+		}()
+
+		a.AddURL("a")
+		a.Start()
+		a.Wait()
+
+		a.Cancel()
+		a.Cancel()
+	})
+}
+
 func TestAnubis_worker(t *testing.T) {
 	t.Run("Worker should process URLs from queue until it is closed", func(t *testing.T) {
 		a := NewTestAnubis()
@@ -236,6 +307,8 @@ func TestAnubis_worker(t *testing.T) {
 		queue <- "c"
 		close(queue)
 
+		a.Wait()
+
 		for _, u := range []string{"a", "b", "c"} {
 			if !findInSlice(processor.results, u) {
 				t.Errorf("URL not processed: %v", u)
@@ -0,0 +1,106 @@
+package anubis
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Committer records the files in an output directory as a new snapshot, such as a git commit, a dated
+// directory or an archive. If no files changed since the previous snapshot, nothing is recorded and no
+// error is returned.
+type Committer interface {
+	Commit(dir string, message string, author GitIdentity) error
+}
+
+// NativeCommitter writes commits without the git binary, so it works on machines without git and is not
+// affected by the user's git configuration or hooks. The index is rewritten to match each commit, so
+// files which have not changed since the previous commit are not hashed again.
+type NativeCommitter struct {
+	Now func() time.Time // Now returns the time recorded in each commit. It defaults to time.Now
+}
+
+func (committer NativeCommitter) Commit(dir string, message string, author GitIdentity) error {
+	repo, err := InitGitRepository(dir)
+	if err != nil {
+		return err
+	}
+
+	tree, err := repo.WriteWorkTree(dir)
+	if err != nil {
+		return err
+	}
+
+	// An empty directory is still committed as an empty tree
+	if tree == "" {
+		if tree, err = repo.writeTree(nil); err != nil {
+			return err
+		}
+	}
+
+	parents := []string{}
+	if head, err := repo.ResolveRef("HEAD"); err == nil {
+		previous, err := repo.ReadCommit(head)
+		if err != nil {
+			return err
+		}
+		if previous.Tree == tree {
+			return nil
+		}
+		parents = append(parents, head)
+	}
+
+	if author.Name == "" || author.Email == "" {
+		author = DefaultGitIdentity
+	}
+
+	now := time.Now
+	if committer.Now != nil {
+		now = committer.Now
+	}
+
+	hash, err := repo.WriteCommit(tree, parents, author, now(), message)
+	if err != nil {
+		return err
+	}
+	return repo.UpdateHead(hash)
+}
+
+// ExecCommitter commits using the git binary, so the user's git configuration and hooks apply. If the
+// author is empty, git's configured identity is used.
+type ExecCommitter struct{}
+
+func (ExecCommitter) Commit(dir string, message string, author GitIdentity) error {
+	git := func(args ...string) *exec.Cmd {
+		// The identity is passed as configuration, so commits work without a global git config
+		if author.Name != "" && author.Email != "" {
+			args = append([]string{"-c", "user.name=" + author.Name, "-c", "user.email=" + author.Email}, args...)
+		}
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Stderr = os.Stderr
+		return cmd
+	}
+
+	// Initialize repo if not already exist
+	if err := git("init", "-q").Run(); err != nil {
+		return err
+	}
+
+	// Add all changes
+	if err := git("add", "-A").Run(); err != nil {
+		return err
+	}
+
+	// The exit status tells whether anything is staged, without parsing git's localised output
+	if err := git("diff", "--cached", "--quiet").Run(); err == nil {
+		return nil
+	} else if exitErr, ok := err.(*exec.ExitError); !ok || exitErr.ExitCode() != 1 {
+		return err
+	}
+
+	// Commit changes, reading the message from stdin
+	cmd := git("commit", "-q", "-F", "-")
+	cmd.Stdin = strings.NewReader(message)
+	return cmd.Run()
+}
@@ -0,0 +1,128 @@
+package anubis
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gitOutput runs git in dir, failing the test if it fails
+func gitOutput(t *testing.T, dir string, args ...string) string {
+	out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v: %v\n%s", args, err, out)
+	}
+	return string(out)
+}
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0774); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNativeCommitter_Commit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	when := time.Date(2026, 10, 1, 12, 0, 0, 0, time.FixedZone("", 2*60*60))
+	committer := NativeCommitter{Now: func() time.Time { return when }}
+	author := GitIdentity{Name: "Archiver", Email: "archiver@test.com"}
+
+	files := map[string]string{
+		"www.test.com/index.html":        "<p>Hello</p>",
+		"www.test.com/css/a.css":         "body {}",
+		"www.test.com/css-print.css":     "p {}", // sorts before css/ in a tree but after it in the index
+		".anubis/manifest.json":          "[]",
+		"www.test.com/list%3Fpage=2.htm": "page 2",
+	}
+
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for _, dir := range dirs {
+		writeTestFiles(t, dir, files)
+		if err := os.MkdirAll(filepath.Join(dir, "empty"), 0774); err != nil {
+			t.Fatal(err)
+		}
+		if err := committer.Commit(dir, "Snapshot\n", author); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := dirs[0]
+	gitOutput(t, dir, "fsck", "--strict")
+
+	// The index matches the commit, so git sees nothing to commit
+	if status := gitOutput(t, dir, "status", "--porcelain"); status != "" {
+		t.Errorf("git status = %v, want no changes", status)
+	}
+
+	// Commits of the same files at the same time are identical
+	head := strings.TrimSpace(gitOutput(t, dir, "rev-parse", "HEAD"))
+	if other := strings.TrimSpace(gitOutput(t, dirs[1], "rev-parse", "HEAD")); head != other {
+		t.Errorf("HEAD = %v and %v, want the same commit", head, other)
+	}
+
+	if got := gitOutput(t, dir, "log", "-1", "--format=%an <%ae> %cI %s"); got != "Archiver <archiver@test.com> 2026-10-01T12:00:00+02:00 Snapshot\n" {
+		t.Errorf("git log = %q", got)
+	}
+	if got := LastCommitTime(dir); !got.Equal(when) {
+		t.Errorf("LastCommitTime() = %v, want %v", got, when)
+	}
+
+	// Nothing changed, so nothing is committed
+	if err := committer.Commit(dir, "Again\n", author); err != nil {
+		t.Fatal(err)
+	}
+	if again := strings.TrimSpace(gitOutput(t, dir, "rev-parse", "HEAD")); again != head {
+		t.Errorf("HEAD moved to %v without changes", again)
+	}
+
+	// Changed and removed files are committed on top of the previous commit
+	writeTestFiles(t, dir, map[string]string{"www.test.com/index.html": "<p>Goodbye</p>"})
+	if err := os.Remove(filepath.Join(dir, "www.test.com/css/a.css")); err != nil {
+		t.Fatal(err)
+	}
+	if err := committer.Commit(dir, "Changed\n", author); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := gitOutput(t, dir, "diff", "--name-status", "HEAD~1", "HEAD"); got != "D\twww.test.com/css/a.css\nM\twww.test.com/index.html\n" {
+		t.Errorf("git diff = %q", got)
+	}
+	if status := gitOutput(t, dir, "status", "--porcelain"); status != "" {
+		t.Errorf("git status = %v, want no changes", status)
+	}
+}
+
+func TestExecCommitter_Commit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"www.test.com/index.html": "<p>Hello</p>"})
+
+	author := GitIdentity{Name: "Archiver", Email: "archiver@test.com"}
+	for _, message := range []string{"First\n", "Second\n"} {
+		if err := (ExecCommitter{}).Commit(dir, message, author); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The second commit had nothing to commit
+	if got := gitOutput(t, dir, "log", "--format=%an %s"); got != "Archiver First\n" {
+		t.Errorf("git log = %q, want one commit", got)
+	}
+}
@@ -0,0 +1,164 @@
+package anubis
+
+import (
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// crawlState tracks the pages discovered while crawling, so links can be limited to the scope of the start
+// URLs and to the configured depth and page count
+type crawlState struct {
+	mu     sync.Mutex
+	scopes []*url.URL     // scopes holds the host and directory of each start URL
+	depths map[string]int // depths maps each accepted page to the number of links followed to reach it
+	pages  int            // pages counts every page accepted so far, including start URLs
+}
+
+func newCrawlState() *crawlState {
+	return &crawlState{depths: make(map[string]int)}
+}
+
+// AddStartURL adds a URL to the queue in the same way as AddURL, but also marks the URL as the root of a
+// crawl. When crawling is enabled, pages linked from the URL are followed as long as they are on the same
+// host and within the same directory.
+func (a *Anubis) AddStartURL(rawURL string) bool {
+	u, err := url.Parse(stripFragment(rawURL))
+	if err == nil {
+		a.crawl.mu.Lock()
+		a.crawl.addScope(u)
+		a.crawl.depths[u.String()] = 0
+		a.crawl.pages++
+		a.crawl.mu.Unlock()
+	}
+
+	a.stats.addStartURL(rawURL)
+	return a.AddURL(rawURL)
+}
+
+// addScope adds the host and directory of a start URL to the crawl's scope. The caller must hold mu.
+func (state *crawlState) addScope(u *url.URL) {
+	scope := *u
+	if i := strings.LastIndexByte(scope.Path, '/'); i >= 0 {
+		scope.Path = scope.Path[:i+1]
+	} else {
+		scope.Path = "/"
+	}
+	state.scopes = append(state.scopes, &scope)
+}
+
+// startScopes returns the scope of each start URL
+func (state *crawlState) startScopes() []url.URL {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	scopes := make([]url.URL, 0, len(state.scopes))
+	for _, scope := range state.scopes {
+		scopes = append(scopes, *scope)
+	}
+	return scopes
+}
+
+// inherit gives a redirect target the same depth as the page which redirected to it
+func (state *crawlState) inherit(from string, to string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	if depth, ok := state.depths[stripFragment(from)]; ok {
+		if _, seen := state.depths[stripFragment(to)]; !seen {
+			state.depths[stripFragment(to)] = depth
+		}
+	}
+}
+
+// followRedirect reports whether the redirect from one URL to another is followed, in which case the
+// target is given the same depth as the page which redirected to it. Like links, targets disallowed by
+// robots.txt are not followed, and neither are those outside the scope of the start URLs when a crawled
+// page redirects. A start URL's own redirect, such as to https or to another host, instead adds the
+// target's scope, since the target is where the site really starts.
+func (a *Anubis) followRedirect(from string, to string) bool {
+	if a.Robots != nil && !a.Robots.Allowed(to) {
+		log.Println("Skipping redirect to", to, "disallowed by robots.txt")
+		return false
+	}
+
+	u, err := url.Parse(stripFragment(to))
+	if err != nil {
+		return false
+	}
+
+	if a.Crawl {
+		a.crawl.mu.Lock()
+		depth, crawled := a.crawl.depths[stripFragment(from)]
+		if crawled && depth == 0 {
+			a.crawl.addScope(u)
+		}
+		a.crawl.mu.Unlock()
+
+		if crawled && !a.inScope(u) {
+			return false
+		}
+	}
+
+	a.crawl.inherit(from, to)
+	return true
+}
+
+// FollowLink adds a page linked from the parent page to the queue if crawling is enabled, the page is within
+// the scope of a start URL, and neither the maximum depth nor the maximum number of pages has been reached.
+// Fragments are ignored, since they refer to the same document.
+//
+// The function will return true if the link was added to the queue, and false otherwise.
+func (a *Anubis) FollowLink(parent string, link string) bool {
+	if !a.Crawl {
+		return false
+	}
+
+	u, err := url.Parse(stripFragment(link))
+	if err != nil || !a.inScope(u) {
+		return false
+	}
+
+	a.crawl.mu.Lock()
+	depth := a.crawl.depths[stripFragment(parent)] + 1
+	if (a.MaxDepth > 0 && depth > a.MaxDepth) || (a.MaxPages > 0 && a.crawl.pages >= a.MaxPages) {
+		a.crawl.mu.Unlock()
+		return false
+	}
+
+	// Reserve a slot for the page before adding it, so concurrent workers cannot exceed MaxPages
+	a.crawl.pages++
+	if _, seen := a.crawl.depths[u.String()]; !seen {
+		a.crawl.depths[u.String()] = depth
+	}
+	a.crawl.mu.Unlock()
+
+	if !a.AddURL(u.String()) {
+		a.crawl.mu.Lock()
+		a.crawl.pages--
+		a.crawl.mu.Unlock()
+		return false
+	}
+
+	return true
+}
+
+// inScope reports whether the URL is on the same host as a start URL and within its directory
+func (a *Anubis) inScope(u *url.URL) bool {
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+
+	a.crawl.mu.Lock()
+	defer a.crawl.mu.Unlock()
+
+	for _, scope := range a.crawl.scopes {
+		if u.Scheme == scope.Scheme && u.Host == scope.Host && strings.HasPrefix(p, scope.Path) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,75 @@
+package anubis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnubis_FollowLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []Option
+		links   []string
+		want    []bool
+	}{
+		{
+			name:  "Links are not followed unless crawling",
+			links: []string{"https://www.test.com/docs/a.html"},
+			want:  []bool{false},
+		},
+		{
+			name:    "Links within the start URL's directory are followed",
+			options: []Option{CrawlOpt{}},
+			links:   []string{"https://www.test.com/docs/a.html", "https://www.test.com/docs/guide/b.html"},
+			want:    []bool{true, true},
+		},
+		{
+			name:    "Links outside the start URL's host or directory are not followed",
+			options: []Option{CrawlOpt{}},
+			links:   []string{"https://other.com/docs/a.html", "https://www.test.com/blog/", "http://www.test.com/docs/a.html"},
+			want:    []bool{false, false, false},
+		},
+		{
+			name:    "Fragments do not create new pages",
+			options: []Option{CrawlOpt{}},
+			links:   []string{"https://www.test.com/docs/a.html", "https://www.test.com/docs/a.html#top"},
+			want:    []bool{true, false},
+		},
+		{
+			name:    "Links are followed until MaxPages is reached",
+			options: []Option{CrawlOpt{MaxPages: 3}},
+			links:   []string{"https://www.test.com/docs/a.html", "https://www.test.com/docs/b.html", "https://www.test.com/docs/c.html"},
+			want:    []bool{true, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAnubis(tt.options...)
+			a.AddStartURL("https://www.test.com/docs/index.html")
+
+			got := []bool{}
+			for _, link := range tt.links {
+				got = append(got, a.FollowLink("https://www.test.com/docs/index.html", link))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FollowLink() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("Links are followed until MaxDepth is reached", func(t *testing.T) {
+		a := NewAnubis(CrawlOpt{MaxDepth: 2})
+		a.AddStartURL("https://www.test.com/")
+
+		got := []bool{
+			a.FollowLink("https://www.test.com/", "https://www.test.com/1.html"),
+			a.FollowLink("https://www.test.com/1.html", "https://www.test.com/2.html"),
+			a.FollowLink("https://www.test.com/2.html", "https://www.test.com/3.html"),
+		}
+
+		if want := []bool{true, true, false}; !reflect.DeepEqual(got, want) {
+			t.Errorf("FollowLink() = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package anubis
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+// ExtractCSSReferences scans a stylesheet for url() functions and @import rules and returns the URL of each,
+// resolved against the parent URL. As with ExtractReferences, Start and End are the byte offsets of the
+// URL within the stylesheet, excluding quotes, so it can be replaced in place.
+func ExtractCSSReferences(parent string, css []byte) []Reference {
+	refs := []Reference{}
+
+	add := func(attr string, start, end int) {
+		raw := string(bytes.TrimSpace(css[start:end]))
+		if raw == "" {
+			return
+		}
+
+		// Keep the offsets pointing at the trimmed value
+		start += bytes.Index(css[start:end], []byte(raw))
+		ref := Reference{
+			Kind:  AssetReference,
+			Attr:  attr,
+			Raw:   raw,
+			Start: start,
+			End:   start + len(raw),
+		}
+
+		if u, err := getFullURL(parent, raw); err == nil {
+			ref.URL = u
+		}
+
+		refs = append(refs, ref)
+	}
+
+	for i := 0; i < len(css); {
+		rest := css[i:]
+
+		switch {
+		case bytes.HasPrefix(rest, []byte("/*")):
+			end := bytes.Index(rest[2:], []byte("*/"))
+			if end < 0 {
+				return refs
+			}
+			i += end + 4
+
+		case rest[0] == '"' || rest[0] == '\'':
+			i = skipCSSString(css, i)
+
+		case hasPrefixFold(rest, "url(") && (i == 0 || !isCSSNameChar(css[i-1])):
+			i += 4
+			for i < len(css) && isSpace(css[i]) {
+				i++
+			}
+
+			if i < len(css) && (css[i] == '"' || css[i] == '\'') {
+				end := skipCSSString(css, i)
+				add("url", i+1, end-1)
+				i = end
+			} else {
+				start := i
+				for i < len(css) && css[i] != ')' {
+					i++
+				}
+				add("url", start, i)
+			}
+
+		case hasPrefixFold(rest, "@import"):
+			i += len("@import")
+			for i < len(css) && isSpace(css[i]) {
+				i++
+			}
+
+			// An @import followed by url() is picked up on the next iteration
+			if i < len(css) && (css[i] == '"' || css[i] == '\'') {
+				end := skipCSSString(css, i)
+				add("import", i+1, end-1)
+				i = end
+			}
+
+		default:
+			i++
+		}
+	}
+
+	return refs
+}
+
+// embeddedCSSReferences returns the references in CSS embedded in an HTML document, such as a <style> element
+// or a style attribute, with offsets within the document. The references are not resolved, since that
+// depends on the document's base URL. Character references in attribute values are decoded, in which case a
+// quoted URL may only have been recognised as unquoted, so its quotes are dropped from Raw.
+func embeddedCSSReferences(css []byte, offset int, tag string, attr string, decode bool) []Reference {
+	refs := ExtractCSSReferences("", css)
+	for i := range refs {
+		refs[i].Tag = tag
+		refs[i].Start += offset
+		refs[i].End += offset
+		if attr != "" {
+			refs[i].Attr = attr
+		}
+
+		if decode {
+			raw := strings.TrimSpace(html.UnescapeString(refs[i].Raw))
+			if len(raw) >= 2 && (raw[0] == '"' || raw[0] == '\'') && raw[len(raw)-1] == raw[0] {
+				raw = raw[1 : len(raw)-1]
+			}
+			refs[i].Raw = raw
+		}
+	}
+	return refs
+}
+
+// skipCSSString returns the position just after the quoted string which starts at i. If the string is not
+// terminated, the end of the stylesheet is returned.
+func skipCSSString(css []byte, i int) int {
+	quote := css[i]
+	for i++; i < len(css); i++ {
+		switch css[i] {
+		case '\\':
+			i++
+		case quote, '\n':
+			return i + 1
+		}
+	}
+	return len(css) + 1
+}
+
+func hasPrefixFold(b []byte, prefix string) bool {
+	return len(b) >= len(prefix) && bytes.EqualFold(b[:len(prefix)], []byte(prefix))
+}
+
+func isCSSNameChar(c byte) bool {
+	return isASCIILetter(c) || (c >= '0' && c <= '9') || c == '-' || c == '_'
+}
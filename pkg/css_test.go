@@ -0,0 +1,76 @@
+package anubis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractCSSReferences(t *testing.T) {
+	tests := []struct {
+		name string
+		css  string
+		want []string
+	}{
+		{
+			name: "Quoted and unquoted url() values",
+			css:  `body { background: url("/bg.png") } .a { background: URL( img/a.png ) } .b { src: url('f.woff') }`,
+			want: []string{"/bg.png", "img/a.png", "f.woff"},
+		},
+		{
+			name: "@import with a string or url()",
+			css:  `@import "base.css"; @import url(theme.css) screen;`,
+			want: []string{"base.css", "theme.css"},
+		},
+		{
+			name: "Comments and strings are skipped",
+			css:  `/* url(old.png) */ .a::after { content: "url(no.png)" }`,
+			want: []string{},
+		},
+		{
+			name: "Functions ending in url are ignored",
+			css:  `.a { mask: myurl(x.png) }`,
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, ref := range ExtractCSSReferences("https://www.test.com/css/", []byte(tt.css)) {
+				if tt.css[ref.Start:ref.End] != ref.Raw {
+					t.Errorf("offsets of %v cover %q", ref.Raw, tt.css[ref.Start:ref.End])
+				}
+				got = append(got, ref.Raw)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExtractCSSReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultResponseHandler_Handle_css(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"Stylesheets", "text/css", `@import url("b.css"); body { background: url(bg.png) } .x { background: url(data:image/png;base64,AA) }`, 2},
+		{"Style elements and attributes", "text/html", `<style>body { background: url(bg.png) }</style><p style="background: url(p.png)">`, 2},
+		{"Stylesheets served as plain text", "text/plain", `body { background: url(bg.png) }`, 1},
+		{"Other files", "text/markdown", `url(bg.png)`, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAnubis(OutputOpt(t.TempDir()))
+			req, resp := newTestResponse(t, "https://www.test.com/a.css", 200, tt.contentType, tt.body)
+			if err := a.Handler.Handle(req, resp); err != nil {
+				t.Fatal(err)
+			}
+			if got := a.Pending(); got != tt.want {
+				t.Errorf("Handle() queued %v URLs, want %v", got, tt.want)
+			}
+		})
+	}
+}
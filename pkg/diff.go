@@ -0,0 +1,519 @@
+package anubis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"io/fs"
+	"mime"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChangeKind describes how a URL differs between two snapshots
+type ChangeKind string
+
+const (
+	URLAdded   ChangeKind = "added"
+	URLRemoved ChangeKind = "removed"
+	URLChanged ChangeKind = "changed"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 2
+
+// maxDiffCells caps the size of the table used to compare two texts. Larger texts are shown as entirely
+// replaced rather than compared line by line.
+const maxDiffCells = 16 * 1024 * 1024
+
+// DiffLine is a single line of a text diff. Op is "-" for removed lines, "+" for added lines and " " for
+// unchanged context.
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// DiffHunk is a run of changes and their context. Line numbers start at 1.
+type DiffHunk struct {
+	OldStart int        `json:"old_start"`
+	NewStart int        `json:"new_start"`
+	Lines    []DiffLine `json:"lines"`
+}
+
+// URLChange describes a file which differs between two snapshots. Text files have a line diff, with markup
+// stripped from HTML so that only changes to the visible text are shown, while other files are compared by
+// their SHA-256 hash and size.
+type URLChange struct {
+	Kind        ChangeKind `json:"kind"`
+	URL         string     `json:"url,omitempty"` // URL is empty if the file is not in either manifest
+	Path        string     `json:"path"`
+	ContentType string     `json:"content_type,omitempty"`
+	Binary      bool       `json:"binary"`
+	OldHash     string     `json:"old_hash,omitempty"`
+	NewHash     string     `json:"new_hash,omitempty"`
+	OldSize     int64      `json:"old_size"`
+	NewSize     int64      `json:"new_size"`
+	MarkupOnly  bool       `json:"markup_only,omitempty"` // MarkupOnly is true if an HTML page changed without its text changing
+	Hunks       []DiffHunk `json:"hunks,omitempty"`
+}
+
+// Name returns the URL, or the path for files which are not in the manifest
+func (change URLChange) Name() string {
+	if change.URL != "" {
+		return change.URL
+	}
+	return change.Path
+}
+
+// SnapshotDiff lists the URLs which differ between two commits of an archive
+type SnapshotDiff struct {
+	From    GitCommit   `json:"from"`
+	To      GitCommit   `json:"to"`
+	Changes []URLChange `json:"changes"`
+}
+
+// Count returns the number of changes of a kind
+func (diff *SnapshotDiff) Count(kind ChangeKind) int {
+	n := 0
+	for _, change := range diff.Changes {
+		if change.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// snapshotSide is one of the two commits being compared
+type snapshotSide struct {
+	files  fs.FS
+	blobs  map[string]string // blobs maps each archived path to its blob hash
+	byPath map[string]ManifestEntry
+}
+
+func (repo *GitRepository) snapshotSide(commit GitCommit) (*snapshotSide, error) {
+	side := &snapshotSide{
+		files:  gitTreeFS{repo: repo, tree: commit.Tree, modTime: commit.Time},
+		blobs:  make(map[string]string),
+		byPath: make(map[string]ManifestEntry),
+	}
+
+	if err := repo.walkTree(commit.Tree, "", side.blobs); err != nil {
+		return nil, err
+	}
+
+	if data, err := fs.ReadFile(side.files, ManifestFile); err == nil {
+		manifest, err := ParseManifest(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range manifest.Entries() {
+			side.byPath[entry.Path] = entry
+		}
+	}
+	return side, nil
+}
+
+// walkTree records the blob hash of every file below the tree. Only host directories are included at the
+// top level, so the manifest and saved errors are not reported as changes.
+func (repo *GitRepository) walkTree(tree string, prefix string, blobs map[string]string) error {
+	entries, err := repo.readTree(tree)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		p := path.Join(prefix, entry.Name)
+		switch {
+		case prefix == "" && (!entry.isDir() || !isHostDir(entry.Name)):
+			continue
+		case entry.isDir():
+			if err := repo.walkTree(entry.Hash, p, blobs); err != nil {
+				return err
+			}
+		default:
+			blobs[p] = entry.Hash
+		}
+	}
+	return nil
+}
+
+// DiffSnapshots compares two commits of an archive. Unchanged files are found by their blob hash, so only
+// the files which changed are read.
+func DiffSnapshots(repo *GitRepository, from GitCommit, to GitCommit) (*SnapshotDiff, error) {
+	old, err := repo.snapshotSide(from)
+	if err != nil {
+		return nil, err
+	}
+	current, err := repo.snapshotSide(to)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	for p := range old.blobs {
+		paths = append(paths, p)
+	}
+	for p := range current.blobs {
+		if _, ok := old.blobs[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	diff := &SnapshotDiff{From: from, To: to, Changes: []URLChange{}}
+	for _, p := range paths {
+		oldBlob, inOld := old.blobs[p]
+		newBlob, inNew := current.blobs[p]
+		if oldBlob == newBlob {
+			continue
+		}
+
+		change := URLChange{Path: p}
+		entry, ok := current.byPath[p]
+		if !ok {
+			entry = old.byPath[p]
+		}
+		change.URL, change.ContentType = entry.URL, entry.ContentType
+		if change.ContentType == "" {
+			change.ContentType = mime.TypeByExtension(path.Ext(p))
+		}
+
+		var oldData, newData []byte
+		if inOld {
+			if oldData, err = fs.ReadFile(old.files, p); err != nil {
+				return nil, err
+			}
+			change.OldHash, change.OldSize = sha256Hex(oldData), int64(len(oldData))
+		}
+		if inNew {
+			if newData, err = fs.ReadFile(current.files, p); err != nil {
+				return nil, err
+			}
+			change.NewHash, change.NewSize = sha256Hex(newData), int64(len(newData))
+		}
+
+		switch {
+		case !inOld:
+			change.Kind = URLAdded
+		case !inNew:
+			change.Kind = URLRemoved
+		default:
+			change.Kind = URLChanged
+		}
+
+		change.Binary = !isTextType(change.ContentType)
+		if change.Kind == URLChanged && !change.Binary {
+			var a, b []string
+			if isHTMLType(change.ContentType) {
+				a, b = StripMarkup(oldData), StripMarkup(newData)
+			} else {
+				a, b = splitLines(string(oldData)), splitLines(string(newData))
+			}
+
+			change.Hunks = DiffText(a, b)
+			change.MarkupOnly = len(change.Hunks) == 0
+		}
+
+		diff.Changes = append(diff.Changes, change)
+	}
+
+	return diff, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func isHTMLType(contentType string) bool {
+	return MediaKindOf(contentType) == MediaHTML
+}
+
+// isTextType reports whether files of the content type can be compared line by line
+func isTextType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "+json"),
+		mediaType == "application/json",
+		mediaType == "application/xml",
+		mediaType == "application/javascript":
+		return true
+	}
+	return false
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+// blockElements start a new line of text when markup is stripped
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true, "dd": true, "div": true,
+	"dl": true, "dt": true, "figcaption": true, "figure": true, "footer": true, "form": true, "h1": true,
+	"h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+	"td": true, "th": true, "title": true, "tr": true, "ul": true,
+}
+
+// StripMarkup returns the visible text of an HTML document, one line for each block of text, with
+// whitespace collapsed. Scripts, styles and comments are dropped.
+func StripMarkup(doc []byte) []string {
+	lines := []string{}
+	current := []string{}
+
+	flush := func() {
+		if len(current) > 0 {
+			lines = append(lines, strings.Join(current, " "))
+			current = current[:0]
+		}
+	}
+
+	z := NewTokenizer(doc)
+	hidden := ""
+	for {
+		tok, ok := z.Next()
+		if !ok {
+			break
+		}
+
+		switch tok.Type {
+		case StartTagToken, SelfClosingTagToken, EndTagToken:
+			if tok.Type == StartTagToken && (tok.Data == "script" || tok.Data == "style") {
+				hidden = tok.Data
+			} else if tok.Type == EndTagToken && tok.Data == hidden {
+				hidden = ""
+			}
+			if blockElements[tok.Data] {
+				flush()
+			}
+
+		case TextToken:
+			if hidden == "" {
+				current = append(current, strings.Fields(html.UnescapeString(tok.Data))...)
+			}
+		}
+	}
+	flush()
+
+	return lines
+}
+
+// DiffText compares two lists of lines, returning the changes grouped into hunks with context
+func DiffText(a []string, b []string) []DiffHunk {
+	ops := diffOps(a, b)
+
+	var hunks []DiffHunk
+	var hunk *DiffHunk
+	oldLine, newLine := 1, 1
+	lastChange := -1
+
+	for i, op := range ops {
+		if op.Op != " " {
+			// Start a new hunk unless this change is close enough to the last one to share its context
+			if hunk == nil || i-lastChange > 2*diffContext {
+				if hunk != nil {
+					hunk.Lines = append(hunk.Lines, ops[lastChange+1:lastChange+1+diffContext]...)
+					hunks = append(hunks, *hunk)
+				}
+
+				start := i - diffContext
+				if start < 0 {
+					start = 0
+				}
+				hunk = &DiffHunk{OldStart: oldLine, NewStart: newLine}
+				for j := start; j < i; j++ {
+					hunk.OldStart--
+					hunk.NewStart--
+					hunk.Lines = append(hunk.Lines, ops[j])
+				}
+			} else {
+				hunk.Lines = append(hunk.Lines, ops[lastChange+1:i]...)
+			}
+
+			hunk.Lines = append(hunk.Lines, op)
+			lastChange = i
+		}
+
+		if op.Op != "+" {
+			oldLine++
+		}
+		if op.Op != "-" {
+			newLine++
+		}
+	}
+
+	if hunk != nil {
+		end := lastChange + 1 + diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+		hunk.Lines = append(hunk.Lines, ops[lastChange+1:end]...)
+		hunks = append(hunks, *hunk)
+	}
+
+	return hunks
+}
+
+// diffOps returns every line of both texts, marked as removed, added or unchanged, using the longest
+// common subsequence of the lines which differ
+func diffOps(a []string, b []string) []DiffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := []DiffLine{}
+	for _, line := range a[:prefix] {
+		ops = append(ops, DiffLine{" ", line})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(ma)+1)*(len(mb)+1) > maxDiffCells {
+		for _, line := range ma {
+			ops = append(ops, DiffLine{"-", line})
+		}
+		for _, line := range mb {
+			ops = append(ops, DiffLine{"+", line})
+		}
+	} else {
+		// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:]
+		lcs := make([][]int32, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int32, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				ops = append(ops, DiffLine{" ", ma[i]})
+				i++
+				j++
+			case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, DiffLine{"-", ma[i]})
+				i++
+			default:
+				ops = append(ops, DiffLine{"+", mb[j]})
+				j++
+			}
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, DiffLine{" ", line})
+	}
+	return ops
+}
+
+// WriteText writes the diff in a form meant for reading in a terminal
+func (diff *SnapshotDiff) WriteText(w io.Writer) error {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "Comparing %v (%v) to %v (%v)\n", shortHash(diff.From.Hash), diff.From.Time.UTC().Format(time.RFC3339),
+		shortHash(diff.To.Hash), diff.To.Time.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "%v added, %v removed, %v changed\n", diff.Count(URLAdded), diff.Count(URLRemoved), diff.Count(URLChanged))
+
+	for _, change := range diff.Changes {
+		b.WriteString("\n")
+		switch change.Kind {
+		case URLAdded:
+			fmt.Fprintf(&b, "+ %v\n", change.Name())
+		case URLRemoved:
+			fmt.Fprintf(&b, "- %v\n", change.Name())
+		default:
+			fmt.Fprintf(&b, "~ %v", change.Name())
+			switch {
+			case change.Binary:
+				fmt.Fprintf(&b, " %v %v bytes -> %v %v bytes", shortHash(change.OldHash), change.OldSize, shortHash(change.NewHash), change.NewSize)
+			case change.MarkupOnly:
+				b.WriteString(" (markup only)")
+			}
+			b.WriteString("\n")
+
+			for _, hunk := range change.Hunks {
+				fmt.Fprintf(&b, "  @@ -%v +%v @@\n", hunk.OldStart, hunk.NewStart)
+				for _, line := range hunk.Lines {
+					b.WriteString("  " + line.Op + " " + line.Text + "\n")
+				}
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteJSON writes the diff as an indented JSON document
+func (diff *SnapshotDiff) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(diff)
+}
+
+var diffReport = template.Must(template.New("diff").Funcs(template.FuncMap{
+	"short": shortHash,
+	"utc":   func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Changes from {{short .From.Hash}} to {{short .To.Hash}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.added { color: #1a7f37; } .removed { color: #cf222e; }
+pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; }
+pre .added { background: #dafbe1; } pre .removed { background: #ffebe9; }
+</style>
+</head>
+<body>
+<h1>Changes from {{short .From.Hash}} to {{short .To.Hash}}</h1>
+<p>{{utc .From.Time}} to {{utc .To.Time}}: {{.Count "added"}} added, {{.Count "removed"}} removed, {{.Count "changed"}} changed</p>
+{{range .Changes}}
+<h2 class="{{.Kind}}">{{.Kind}}: {{.Name}}</h2>
+{{if and (eq .Kind "changed") .Binary}}<p>{{short .OldHash}} ({{.OldSize}} bytes) to {{short .NewHash}} ({{.NewSize}} bytes)</p>{{end}}
+{{if .MarkupOnly}}<p>Only the markup changed.</p>{{end}}
+{{range .Hunks}}<pre>{{range .Lines}}<span class="{{if eq .Op "+"}}added{{else if eq .Op "-"}}removed{{end}}">{{.Op}} {{.Text}}</span>
+{{end}}</pre>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes the diff as a standalone HTML report
+func (diff *SnapshotDiff) WriteHTML(w io.Writer) error {
+	return diffReport.Execute(w, diff)
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
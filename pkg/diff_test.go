@@ -0,0 +1,163 @@
+package anubis
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	manifest := `[
+  {"url": "https://www.test.com/", "path": "www.test.com/index.html", "content_type": "text/html"},
+  {"url": "https://www.test.com/about", "path": "www.test.com/about.html", "content_type": "text/html"},
+  {"url": "https://www.test.com/logo", "path": "www.test.com/logo.bin", "content_type": "image/png"}
+]`
+	dir := gitTestRepo(t, []map[string]string{
+		{
+			"www.test.com/index.html": "<html><body><h1>Home</h1><p>Hello <b>world</b></p><script>var a;</script></body></html>",
+			"www.test.com/about.html": "<p>About</p>",
+			"www.test.com/old.css":    "body {}",
+			"www.test.com/logo.bin":   "\x89PNG1",
+			".anubis/manifest.json":   manifest,
+		},
+		{
+			"www.test.com/index.html": "<html><body><h1>Home</h1><p>Goodbye <b>world</b></p><script>var b;</script></body></html>",
+			"www.test.com/about.html": "<div class=\"x\"><p>About</p></div>",
+			"www.test.com/old.css":    "",
+			"www.test.com/new.css":    "a {}",
+			"www.test.com/logo.bin":   "\x89PNG22",
+			".anubis/manifest.json":   manifest + "\n",
+		},
+	}, []string{"2026-09-01T00:00:00Z", "2026-10-01T00:00:00Z"})
+
+	repo, err := OpenGitRepository(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := repo.ResolveRevision("HEAD~1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := repo.ResolveRevision("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := DiffSnapshots(repo, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kind       ChangeKind
+		name       string
+		binary     bool
+		markupOnly bool
+	}{
+		{URLChanged, "https://www.test.com/about", false, true},
+		{URLChanged, "https://www.test.com/", false, false},
+		{URLChanged, "https://www.test.com/logo", true, false},
+		{URLAdded, "www.test.com/new.css", false, false},
+		{URLRemoved, "www.test.com/old.css", false, false},
+	}
+	if len(diff.Changes) != len(want) {
+		t.Fatalf("DiffSnapshots() found %v changes, want %v: %+v", len(diff.Changes), len(want), diff.Changes)
+	}
+	for i, w := range want {
+		c := diff.Changes[i]
+		if c.Kind != w.kind || c.Name() != w.name || c.Binary != w.binary || c.MarkupOnly != w.markupOnly {
+			t.Errorf("change %v = %v %v binary %v markup only %v, want %v %v binary %v markup only %v", i,
+				c.Kind, c.Name(), c.Binary, c.MarkupOnly, w.kind, w.name, w.binary, w.markupOnly)
+		}
+	}
+
+	home := diff.Changes[1]
+	wantHunks := []DiffHunk{{OldStart: 1, NewStart: 1, Lines: []DiffLine{{" ", "Home"}, {"-", "Hello world"}, {"+", "Goodbye world"}}}}
+	if !reflect.DeepEqual(home.Hunks, wantHunks) {
+		t.Errorf("hunks = %+v, want %+v", home.Hunks, wantHunks)
+	}
+
+	logo := diff.Changes[2]
+	if logo.OldSize != 5 || logo.NewSize != 6 || logo.OldHash == logo.NewHash || len(logo.Hunks) != 0 {
+		t.Errorf("binary change = %+v", logo)
+	}
+
+	var text, report bytes.Buffer
+	if err := diff.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"1 added, 1 removed, 3 changed", "~ https://www.test.com/about (markup only)", "  - Hello world", "  + Goodbye world", "+ www.test.com/new.css", "- www.test.com/old.css"} {
+		if !strings.Contains(text.String(), line) {
+			t.Errorf("text report does not contain %q:\n%v", line, text.String())
+		}
+	}
+
+	if err := diff.WriteHTML(&report); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(report.String(), `<span class="added">&#43; Goodbye world</span>`) {
+		t.Errorf("HTML report does not show the added line:\n%v", report.String())
+	}
+
+	var buf bytes.Buffer
+	if err := diff.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded := SnapshotDiff{}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.From.Hash != from.Hash || !reflect.DeepEqual(decoded.Changes, diff.Changes) {
+		t.Errorf("JSON report = %+v, want %+v", decoded, diff)
+	}
+}
+
+func TestStripMarkup(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{"Blocks start lines", "<h1>Title</h1><p>One</p><p>Two</p>", []string{"Title", "One", "Two"}},
+		{"Inline elements do not", "<p>Hello <a href=\"/\">there</a> <em>you</em></p>", []string{"Hello there you"}},
+		{"Whitespace is collapsed", "<p>  a\n\n   b  </p>", []string{"a b"}},
+		{"Scripts and styles are dropped", "<style>p {}</style><p>text</p><script>x()</script>", []string{"text"}},
+		{"Entities are decoded", "<p>a &amp; b&nbsp;c</p>", []string{"a & b c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StripMarkup([]byte(tt.doc)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StripMarkup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffText(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want []DiffHunk
+	}{
+		{"Identical", "a b c", "a b c", nil},
+		{"Changed line", "a b c", "a x c", []DiffHunk{{1, 1, []DiffLine{{" ", "a"}, {"-", "b"}, {"+", "x"}, {" ", "c"}}}}},
+		{"Distant changes are split", "x 1 2 3 4 5 6 y", "X 1 2 3 4 5 6 Y", []DiffHunk{
+			{1, 1, []DiffLine{{"-", "x"}, {"+", "X"}, {" ", "1"}, {" ", "2"}}},
+			{6, 6, []DiffLine{{" ", "5"}, {" ", "6"}, {"-", "y"}, {"+", "Y"}}},
+		}},
+		{"Nearby changes share context", "x 1 2 3 y", "X 1 2 3 Y", []DiffHunk{
+			{1, 1, []DiffLine{{"-", "x"}, {"+", "X"}, {" ", "1"}, {" ", "2"}, {" ", "3"}, {"-", "y"}, {"+", "Y"}}},
+		}},
+		{"Insertion", "a b", "a n b", []DiffHunk{{1, 1, []DiffLine{{" ", "a"}, {"+", "n"}, {" ", "b"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffText(strings.Fields(tt.a), strings.Fields(tt.b)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffText() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,593 @@
+package anubis
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GitRepository reads objects directly from a git repository, such as the one Commit creates in the
+// output directory. Loose objects and packfiles, including deltified objects, are supported. It is safe
+// for concurrent use.
+type GitRepository struct {
+	dir string // dir is the .git directory
+
+	mu    sync.Mutex
+	packs []*gitPack // packs is loaded on first use
+}
+
+// GitCommit is the part of a commit needed to find snapshots
+type GitCommit struct {
+	Hash    string    `json:"hash"`
+	Tree    string    `json:"tree"`
+	Parents []string  `json:"parents"`
+	Time    time.Time `json:"time"` // Time is the committer time
+	Message string    `json:"message"`
+}
+
+// gitTreeEntry is a single entry of a tree object
+type gitTreeEntry struct {
+	Mode string
+	Name string
+	Hash string
+}
+
+func (entry gitTreeEntry) isDir() bool { return entry.Mode == "40000" }
+
+// OpenGitRepository opens the repository in dir, which may be a working tree or a bare repository
+func OpenGitRepository(dir string) (*GitRepository, error) {
+	for _, candidate := range []string{filepath.Join(dir, ".git"), dir} {
+		if info, err := os.Stat(filepath.Join(candidate, "objects")); err == nil && info.IsDir() {
+			return &GitRepository{dir: candidate}, nil
+		}
+	}
+	return nil, errors.New("No git repository found in " + dir)
+}
+
+// ResolveRef returns the hash a ref such as HEAD or refs/heads/master points to, following symbolic refs
+func (repo *GitRepository) ResolveRef(ref string) (string, error) {
+	for depth := 0; depth < 10; depth++ {
+		data, err := os.ReadFile(filepath.Join(repo.dir, filepath.FromSlash(ref)))
+		if os.IsNotExist(err) {
+			return repo.packedRef(ref)
+		} else if err != nil {
+			return "", err
+		}
+
+		value := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(value, "ref: ") {
+			return value, nil
+		}
+		ref = strings.TrimPrefix(value, "ref: ")
+	}
+	return "", errors.New("Too many symbolic refs from " + ref)
+}
+
+func (repo *GitRepository) packedRef(ref string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(repo.dir, "packed-refs"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	return "", errors.New("Could not resolve " + ref)
+}
+
+// ReadObject returns the type and contents of an object
+func (repo *GitRepository) ReadObject(hash string) (string, []byte, error) {
+	raw, err := hex.DecodeString(hash)
+	if err != nil || len(raw) != 20 {
+		return "", nil, errors.New("Invalid object hash " + hash)
+	}
+
+	f, err := os.Open(filepath.Join(repo.dir, "objects", hash[:2], hash[2:]))
+	if err == nil {
+		defer f.Close()
+		return readLooseObject(f)
+	} else if !os.IsNotExist(err) {
+		return "", nil, err
+	}
+
+	// Packs are read again if the object is missing, in case the repository was repacked since
+	for _, reload := range []bool{false, true} {
+		packs, err := repo.loadPacks(reload)
+		if err != nil {
+			return "", nil, err
+		}
+
+		for _, pack := range packs {
+			if offset, ok := pack.find(raw); ok {
+				return pack.readObject(repo, offset)
+			}
+		}
+	}
+	return "", nil, errors.New("Object " + hash + " not found")
+}
+
+func readLooseObject(r io.Reader) (string, []byte, error) {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The header is "<type> <size>\x00"
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return "", nil, errors.New("Invalid loose object")
+	}
+	header := strings.SplitN(string(data[:i]), " ", 2)
+	return header[0], data[i+1:], nil
+}
+
+// ReadCommit reads and parses a commit object
+func (repo *GitRepository) ReadCommit(hash string) (GitCommit, error) {
+	objType, data, err := repo.ReadObject(hash)
+	if err != nil {
+		return GitCommit{}, err
+	}
+	if objType != "commit" {
+		return GitCommit{}, errors.New(hash + " is a " + objType + ", not a commit")
+	}
+
+	commit := GitCommit{Hash: hash}
+	header, message := string(data), ""
+	if i := strings.Index(header, "\n\n"); i >= 0 {
+		header, message = header[:i], header[i+2:]
+	}
+	commit.Message = message
+
+	for _, line := range strings.Split(header, "\n") {
+		key, value := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+
+		switch key {
+		case "tree":
+			commit.Tree = value
+		case "parent":
+			commit.Parents = append(commit.Parents, value)
+		case "committer":
+			commit.Time = parseGitSignatureTime(value)
+		}
+	}
+
+	return commit, nil
+}
+
+// parseGitSignatureTime reads the "<seconds> <zone>" which ends a signature such as
+// "Name <email> 1700000000 +0100"
+func parseGitSignatureTime(signature string) time.Time {
+	fields := strings.Fields(signature[strings.LastIndexByte(signature, '>')+1:])
+	if len(fields) != 2 {
+		return time.Time{}
+	}
+
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+
+	t := time.Unix(seconds, 0)
+	if zone, err := time.Parse("-0700", fields[1]); err == nil {
+		t = t.In(zone.Location())
+	}
+	return t
+}
+
+// readTree reads and parses a tree object
+func (repo *GitRepository) readTree(hash string) ([]gitTreeEntry, error) {
+	objType, data, err := repo.ReadObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	if objType != "tree" {
+		return nil, errors.New(hash + " is a " + objType + ", not a tree")
+	}
+
+	// Each entry is "<mode> <name>\x00" followed by the 20 byte hash
+	entries := []gitTreeEntry{}
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		null := bytes.IndexByte(data, 0)
+		if space < 0 || null < space || len(data) < null+21 {
+			return nil, errors.New("Invalid tree " + hash)
+		}
+
+		entries = append(entries, gitTreeEntry{
+			Mode: string(data[:space]),
+			Name: string(data[space+1 : null]),
+			Hash: hex.EncodeToString(data[null+1 : null+21]),
+		})
+		data = data[null+21:]
+	}
+	return entries, nil
+}
+
+// History returns the commits reachable from HEAD by following first parents, newest first. A repository
+// without commits has no history.
+func (repo *GitRepository) History() ([]GitCommit, error) {
+	hash, err := repo.ResolveRef("HEAD")
+	if err != nil {
+		return nil, nil
+	}
+
+	commits := []GitCommit{}
+	for hash != "" {
+		commit, err := repo.ReadCommit(hash)
+		if err != nil {
+			return commits, err
+		}
+		commits = append(commits, commit)
+
+		hash = ""
+		if len(commit.Parents) > 0 {
+			hash = commit.Parents[0]
+		}
+	}
+	return commits, nil
+}
+
+// gitPack is a packfile and its version 2 index
+type gitPack struct {
+	path    string
+	fanout  [256]uint32
+	hashes  []byte // hashes holds the sorted 20 byte object hashes
+	offsets []byte // offsets holds a 4 byte offset for each object
+	large   []byte // large holds the 8 byte offsets referenced by offsets with the high bit set
+}
+
+func (repo *GitRepository) loadPacks(reload bool) ([]*gitPack, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.packs != nil && !reload {
+		return repo.packs, nil
+	}
+
+	indexes, err := filepath.Glob(filepath.Join(repo.dir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(indexes)
+
+	packs := []*gitPack{}
+	for _, index := range indexes {
+		pack, err := loadGitPack(index)
+		if err != nil {
+			return nil, err
+		}
+		packs = append(packs, pack)
+	}
+
+	repo.packs = packs
+	return packs, nil
+}
+
+func loadGitPack(index string) (*gitPack, error) {
+	data, err := os.ReadFile(index)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 8+256*4 || !bytes.Equal(data[:4], []byte{0xff, 't', 'O', 'c'}) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return nil, errors.New("Unsupported pack index " + index)
+	}
+
+	pack := &gitPack{path: strings.TrimSuffix(index, ".idx") + ".pack"}
+	for i := range pack.fanout {
+		pack.fanout[i] = binary.BigEndian.Uint32(data[8+i*4:])
+	}
+
+	n := int(pack.fanout[255])
+	start := 8 + 256*4
+	if len(data) < start+n*28 {
+		return nil, errors.New("Truncated pack index " + index)
+	}
+
+	// The index holds the hashes, then a CRC and an offset for each object, then the large offsets
+	pack.hashes = data[start : start+n*20]
+	pack.offsets = data[start+n*24 : start+n*28]
+	pack.large = data[start+n*28:]
+	return pack, nil
+}
+
+// find returns the offset of the object within the packfile
+func (pack *gitPack) find(hash []byte) (int64, bool) {
+	lo := 0
+	if hash[0] > 0 {
+		lo = int(pack.fanout[hash[0]-1])
+	}
+	hi := int(pack.fanout[hash[0]])
+
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(pack.hashes[(lo+i)*20:(lo+i+1)*20], hash) >= 0
+	})
+	if i >= hi || !bytes.Equal(pack.hashes[i*20:(i+1)*20], hash) {
+		return 0, false
+	}
+
+	offset := binary.BigEndian.Uint32(pack.offsets[i*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true
+	}
+
+	j := int(offset&0x7fffffff) * 8
+	if j+8 > len(pack.large) {
+		return 0, false
+	}
+	return int64(binary.BigEndian.Uint64(pack.large[j:])), true
+}
+
+// The object types stored in packfiles
+const (
+	gitPackCommit   = 1
+	gitPackTree     = 2
+	gitPackBlob     = 3
+	gitPackTag      = 4
+	gitPackOfsDelta = 6
+	gitPackRefDelta = 7
+)
+
+var gitPackTypeNames = map[int]string{
+	gitPackCommit: "commit",
+	gitPackTree:   "tree",
+	gitPackBlob:   "blob",
+	gitPackTag:    "tag",
+}
+
+// readObject reads the object at the offset, resolving deltas against their base objects
+func (pack *gitPack) readObject(repo *GitRepository, offset int64) (string, []byte, error) {
+	f, err := os.Open(pack.path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+
+	return pack.readObjectAt(repo, f, offset, 0)
+}
+
+func (pack *gitPack) readObjectAt(repo *GitRepository, f *os.File, offset int64, depth int) (string, []byte, error) {
+	if depth > 64 {
+		return "", nil, errors.New("Delta chain too long in " + pack.path)
+	}
+
+	r := bufio.NewReader(io.NewSectionReader(f, offset, 1<<62))
+
+	// The header holds the type in bits 4-6 of the first byte, and the size as a little-endian varint
+	c, err := r.ReadByte()
+	if err != nil {
+		return "", nil, err
+	}
+	objType := int(c>>4) & 7
+	for c&0x80 != 0 {
+		if c, err = r.ReadByte(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	var base func() (string, []byte, error)
+	switch objType {
+	case gitPackOfsDelta:
+		// The base's offset is relative to this object, in a varint where each continuation adds one
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		rel := int64(c & 0x7f)
+		for c&0x80 != 0 {
+			if c, err = r.ReadByte(); err != nil {
+				return "", nil, err
+			}
+			rel = (rel+1)<<7 | int64(c&0x7f)
+		}
+		base = func() (string, []byte, error) { return pack.readObjectAt(repo, f, offset-rel, depth+1) }
+
+	case gitPackRefDelta:
+		hash := make([]byte, 20)
+		if _, err := io.ReadFull(r, hash); err != nil {
+			return "", nil, err
+		}
+		base = func() (string, []byte, error) { return repo.ReadObject(hex.EncodeToString(hash)) }
+	}
+
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return "", nil, err
+	}
+	defer zr.Close()
+
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if base == nil {
+		name, ok := gitPackTypeNames[objType]
+		if !ok {
+			return "", nil, errors.New("Unknown object type " + strconv.Itoa(objType) + " in " + pack.path)
+		}
+		return name, data, nil
+	}
+
+	baseType, baseData, err := base()
+	if err != nil {
+		return "", nil, err
+	}
+
+	result, err := applyGitDelta(baseData, data)
+	return baseType, result, err
+}
+
+// applyGitDelta rebuilds an object from its base and a delta, which is a list of instructions to copy
+// ranges of the base or insert new data
+func applyGitDelta(base []byte, delta []byte) ([]byte, error) {
+	errInvalid := errors.New("Invalid delta")
+
+	varint := func() (int, bool) {
+		n, shift := 0, uint(0)
+		for len(delta) > 0 {
+			c := delta[0]
+			delta = delta[1:]
+			n |= int(c&0x7f) << shift
+			shift += 7
+			if c&0x80 == 0 {
+				return n, true
+			}
+		}
+		return 0, false
+	}
+
+	baseSize, ok := varint()
+	if !ok || baseSize != len(base) {
+		return nil, errInvalid
+	}
+	size, ok := varint()
+	if !ok {
+		return nil, errInvalid
+	}
+
+	out := make([]byte, 0, size)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		if op&0x80 == 0 {
+			// Insert the next op bytes
+			n := int(op)
+			if n == 0 || n > len(delta) {
+				return nil, errInvalid
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+			continue
+		}
+
+		// Copy from the base. Bits 0-3 say which offset bytes follow and bits 4-6 which size bytes do.
+		var offset, n int
+		for i := uint(0); i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errInvalid
+			}
+			if i < 4 {
+				offset |= int(delta[0]) << (8 * i)
+			} else {
+				n |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if n == 0 {
+			n = 0x10000
+		}
+
+		if offset+n > len(base) {
+			return nil, errInvalid
+		}
+		out = append(out, base[offset:offset+n]...)
+	}
+
+	if len(out) != size {
+		return nil, errInvalid
+	}
+	return out, nil
+}
+
+// ResolveRevision finds the commit for a revision, which may be a full or abbreviated hash, a ref such as
+// HEAD or master, a ref followed by ~n or ^ to select an ancestor, or a timestamp, which selects the last
+// commit made at or before it
+func (repo *GitRepository) ResolveRevision(rev string) (GitCommit, error) {
+	// Strip ancestor selectors from the end, counting how many first parents to follow
+	base, back := rev, 0
+	for {
+		if strings.HasSuffix(base, "^") {
+			base, back = base[:len(base)-1], back+1
+			continue
+		}
+
+		i := strings.LastIndexByte(base, '~')
+		if i < 0 {
+			break
+		}
+
+		n := 1
+		if digits := base[i+1:]; digits != "" {
+			var err error
+			if n, err = strconv.Atoi(digits); err != nil || n < 0 {
+				break
+			}
+		}
+		base, back = base[:i], back+n
+	}
+
+	commit, err := repo.resolveBase(base)
+	if err != nil {
+		return GitCommit{}, err
+	}
+
+	for ; back > 0; back-- {
+		if len(commit.Parents) == 0 {
+			return GitCommit{}, errors.New("Revision " + rev + " goes past the first commit")
+		}
+		if commit, err = repo.ReadCommit(commit.Parents[0]); err != nil {
+			return GitCommit{}, err
+		}
+	}
+	return commit, nil
+}
+
+func (repo *GitRepository) resolveBase(rev string) (GitCommit, error) {
+	for _, ref := range []string{rev, "refs/heads/" + rev, "refs/tags/" + rev} {
+		if hash, err := repo.ResolveRef(ref); err == nil && len(hash) == 40 {
+			return repo.ReadCommit(hash)
+		}
+	}
+
+	history, err := repo.History()
+	if err != nil {
+		return GitCommit{}, err
+	}
+
+	if t, ok := parseSnapshotTime(rev); ok && len(history) > 0 {
+		for _, commit := range history {
+			if !commit.Time.After(t) {
+				return commit, nil
+			}
+		}
+		return history[len(history)-1], nil
+	}
+
+	if len(rev) >= 4 && isHexString(rev) {
+		for _, commit := range history {
+			if strings.HasPrefix(commit.Hash, strings.ToLower(rev)) {
+				return commit, nil
+			}
+		}
+	}
+
+	return GitCommit{}, errors.New("Unknown revision " + rev)
+}
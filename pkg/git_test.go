@@ -0,0 +1,125 @@
+package anubis
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitTestRepo creates a repository with a commit for each set of files, made at the given dates. Files with
+// empty content are removed.
+func gitTestRepo(t *testing.T, commits []map[string]string, dates []string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	run := func(env []string, args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), env...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	run(nil, "init", "-q")
+	for i, files := range commits {
+		for name, content := range files {
+			p := filepath.Join(dir, filepath.FromSlash(name))
+			if content == "" {
+				if err := os.Remove(p); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(p), 0774); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		env := []string{
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@test.com", "GIT_AUTHOR_DATE=" + dates[i],
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@test.com", "GIT_COMMITTER_DATE=" + dates[i],
+		}
+		run(nil, "add", "-A")
+		run(env, "commit", "-q", "-m", "Snapshot "+dates[i])
+	}
+
+	return dir
+}
+
+func gitTestPack(t *testing.T, dir string) {
+	cmd := exec.Command("git", "-C", dir, "gc", "-q", "--aggressive")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git gc: %v\n%s", err, out)
+	}
+}
+
+func TestGitRepository_History(t *testing.T) {
+	// Similar large files make git store deltas once packed
+	page := strings.Repeat("<p>Lorem ipsum dolor sit amet</p>\n", 200)
+	dir := gitTestRepo(t, []map[string]string{
+		{"www.test.com/index.html": page + "one"},
+		{"www.test.com/index.html": page + "two", "www.test.com/new.html": "new"},
+	}, []string{"2026-09-01T00:00:00Z", "2026-10-01T00:00:00+02:00"})
+
+	for _, packed := range []bool{false, true} {
+		if packed {
+			gitTestPack(t, dir)
+		}
+
+		repo, err := OpenGitRepository(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		history, err := repo.History()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 2 {
+			t.Fatalf("History() = %v commits, want 2", len(history))
+		}
+
+		if got := history[0].Time.UTC().Format("2006-01-02T15:04:05Z"); got != "2026-09-30T22:00:00Z" {
+			t.Errorf("History()[0].Time = %v, want 2026-09-30T22:00:00Z", got)
+		}
+		if !strings.HasPrefix(history[1].Message, "Snapshot 2026-09-01") {
+			t.Errorf("History()[1].Message = %q", history[1].Message)
+		}
+
+		for i, want := range []string{page + "two", page + "one"} {
+			fsys := gitTreeFS{repo: repo, tree: history[i].Tree}
+			f, err := fsys.Open("www.test.com/index.html")
+			if err != nil {
+				t.Fatalf("packed %v: %v", packed, err)
+			}
+			var b bytes.Buffer
+			b.ReadFrom(f.(*gitFile))
+			if b.String() != want {
+				t.Errorf("packed %v: snapshot %v index.html ends %q", packed, i, b.String()[len(b.String())-3:])
+			}
+		}
+	}
+}
+
+func Test_applyGitDelta(t *testing.T) {
+	base := []byte("hello world")
+
+	// Copy "hello " from offset 0, insert "there", then copy "world" from offset 6
+	delta := []byte{11, 16, 0x90, 6, 5, 't', 'h', 'e', 'r', 'e', 0x91, 6, 5}
+	got, err := applyGitDelta(base, delta)
+	if err != nil || string(got) != "hello thereworld" {
+		t.Errorf("applyGitDelta() = %q, %v, want hello thereworld", got, err)
+	}
+
+	if _, err := applyGitDelta(base, []byte{11, 5, 0x91, 10, 5}); err == nil {
+		t.Errorf("applyGitDelta() error = nil for a copy past the end of the base")
+	}
+}
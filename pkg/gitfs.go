@@ -0,0 +1,122 @@
+package anubis
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// gitTreeFS is a read-only file system over a tree of a GitRepository. Every file has the time of the
+// commit the tree belongs to.
+type gitTreeFS struct {
+	repo    *GitRepository
+	tree    string
+	modTime time.Time
+}
+
+func (fsys gitTreeFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry := gitTreeEntry{Mode: "40000", Name: ".", Hash: fsys.tree}
+	if name != "." {
+		for _, part := range strings.Split(name, "/") {
+			if !entry.isDir() {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+
+			entries, err := fsys.repo.readTree(entry.Hash)
+			if err != nil {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+			}
+
+			found := false
+			for _, e := range entries {
+				if e.Name == part {
+					entry, found = e, true
+					break
+				}
+			}
+			if !found {
+				return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+			}
+		}
+	}
+
+	if entry.isDir() {
+		entries, err := fsys.repo.readTree(entry.Hash)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &gitDir{info: gitFileInfo{entry: entry, modTime: fsys.modTime}, entries: entries, modTime: fsys.modTime}, nil
+	}
+
+	_, data, err := fsys.repo.ReadObject(entry.Hash)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &gitFile{Reader: bytes.NewReader(data), info: gitFileInfo{entry: entry, size: int64(len(data)), modTime: fsys.modTime}}, nil
+}
+
+type gitFileInfo struct {
+	entry   gitTreeEntry
+	size    int64
+	modTime time.Time
+}
+
+func (info gitFileInfo) Name() string       { return info.entry.Name }
+func (info gitFileInfo) Size() int64        { return info.size }
+func (info gitFileInfo) ModTime() time.Time { return info.modTime }
+func (info gitFileInfo) IsDir() bool        { return info.entry.isDir() }
+func (info gitFileInfo) Sys() interface{}   { return nil }
+
+func (info gitFileInfo) Mode() fs.FileMode {
+	if info.entry.isDir() {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// gitFile is a blob. It can be seeked, so it can be served with http.ServeContent.
+type gitFile struct {
+	*bytes.Reader
+	info gitFileInfo
+}
+
+func (f *gitFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *gitFile) Close() error               { return nil }
+
+type gitDir struct {
+	info    gitFileInfo
+	entries []gitTreeEntry
+	modTime time.Time
+	read    int // read is the number of entries returned by ReadDir so far
+}
+
+func (d *gitDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *gitDir) Close() error               { return nil }
+
+func (d *gitDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *gitDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.read:]
+	if n > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(remaining) {
+		remaining = remaining[:n]
+	}
+	d.read += len(remaining)
+
+	// Sizes are not known without reading each blob, so they are left as zero
+	entries := make([]fs.DirEntry, 0, len(remaining))
+	for _, entry := range remaining {
+		entries = append(entries, fs.FileInfoToDirEntry(gitFileInfo{entry: entry, modTime: d.modTime}))
+	}
+	return entries, nil
+}
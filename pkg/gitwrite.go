@@ -0,0 +1,367 @@
+package anubis
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitIdentity is the name and email address recorded as the author and committer of a commit
+type GitIdentity struct {
+	Name, Email string
+}
+
+// DefaultGitIdentity is used by the NativeCommitter when no identity is configured
+var DefaultGitIdentity = GitIdentity{Name: "Anubis", Email: "anubis@localhost"}
+
+// InitGitRepository opens the repository in the working tree dir, creating an empty repository with a
+// master branch if there is none
+func InitGitRepository(dir string) (*GitRepository, error) {
+	if repo, err := OpenGitRepository(dir); err == nil {
+		return repo, nil
+	}
+
+	gitDir := filepath.Join(dir, ".git")
+	for _, sub := range []string{"objects", "refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, filepath.FromSlash(sub)), 0775); err != nil {
+			return nil, err
+		}
+	}
+
+	files := map[string]string{
+		"HEAD":   "ref: refs/heads/master\n",
+		"config": "[core]\n\trepositoryformatversion = 0\n\tfilemode = true\n\tbare = false\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(gitDir, name), []byte(content), 0664); err != nil {
+			return nil, err
+		}
+	}
+
+	return &GitRepository{dir: gitDir}, nil
+}
+
+// WriteObject stores an object as a loose object, returning its hash. Objects which are already stored
+// loose are not written again.
+func (repo *GitRepository) WriteObject(objType string, data []byte) (string, error) {
+	header := objType + " " + strconv.Itoa(len(data)) + "\x00"
+
+	h := sha1.New()
+	h.Write([]byte(header))
+	h.Write(data)
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	p := filepath.Join(repo.dir, "objects", hash[:2], hash[2:])
+	if _, err := os.Stat(p); err == nil {
+		return hash, nil
+	}
+
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write([]byte(header))
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0775); err != nil {
+		return "", err
+	}
+	return hash, writeFileAtomic(p, buf.Bytes(), 0444)
+}
+
+// writeFileAtomic writes a file through a lock file next to it, so readers never see a partial file and
+// two writers cannot write it at once
+func writeFileAtomic(p string, data []byte, perm os.FileMode) error {
+	lock := p + ".lock"
+	f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(lock)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(lock)
+		return err
+	}
+	return os.Rename(lock, p)
+}
+
+// gitIndexEntry is a file recorded in the index, along with the file information used to tell whether
+// the file changed since its blob was written
+type gitIndexEntry struct {
+	Path    string
+	Mode    uint32
+	Hash    string
+	Size    int64
+	ModTime time.Time
+}
+
+// WriteWorkTree stores every file in the working tree as blobs and trees, returning the hash of the root
+// tree. The .git directory is skipped, as are empty directories, which git cannot record. Files whose
+// size and modification time match the index are not read again.
+func (repo *GitRepository) WriteWorkTree(dir string) (string, error) {
+	cached := repo.readIndex()
+
+	entries := []gitIndexEntry{}
+	hash, err := repo.writeDirTree(dir, "", cached, &entries)
+	if err != nil {
+		return "", err
+	}
+
+	return hash, repo.writeIndex(entries)
+}
+
+func (repo *GitRepository) writeDirTree(root string, rel string, cached map[string]gitIndexEntry, index *[]gitIndexEntry) (string, error) {
+	files, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+
+	tree := []gitTreeEntry{}
+	for _, file := range files {
+		name := file.Name()
+		if rel == "" && name == ".git" {
+			continue
+		}
+		p := path.Join(rel, name)
+
+		info, err := file.Info()
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case info.IsDir():
+			hash, err := repo.writeDirTree(root, p, cached, index)
+			if err != nil {
+				return "", err
+			}
+			if hash != "" {
+				tree = append(tree, gitTreeEntry{Mode: "40000", Name: name, Hash: hash})
+			}
+
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(filepath.Join(root, filepath.FromSlash(p)))
+			if err != nil {
+				return "", err
+			}
+			hash, err := repo.WriteObject("blob", []byte(filepath.ToSlash(target)))
+			if err != nil {
+				return "", err
+			}
+			tree = append(tree, gitTreeEntry{Mode: "120000", Name: name, Hash: hash})
+			*index = append(*index, gitIndexEntry{Path: p, Mode: 0120000, Hash: hash, Size: info.Size(), ModTime: info.ModTime()})
+
+		case info.Mode().IsRegular():
+			mode := uint32(0100644)
+			if info.Mode()&0111 != 0 {
+				mode = 0100755
+			}
+
+			entry, ok := cached[p]
+			if !ok || entry.Mode != mode || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+				data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(p)))
+				if err != nil {
+					return "", err
+				}
+				hash, err := repo.WriteObject("blob", data)
+				if err != nil {
+					return "", err
+				}
+				entry = gitIndexEntry{Path: p, Mode: mode, Hash: hash, Size: info.Size(), ModTime: info.ModTime()}
+			}
+
+			tree = append(tree, gitTreeEntry{Mode: strconv.FormatUint(uint64(mode), 8), Name: name, Hash: entry.Hash})
+			*index = append(*index, entry)
+		}
+	}
+
+	if len(tree) == 0 {
+		return "", nil
+	}
+	return repo.writeTree(tree)
+}
+
+// writeTree stores a tree object. Entries are sorted as git sorts them, comparing directory names as if
+// they ended with a slash.
+func (repo *GitRepository) writeTree(entries []gitTreeEntry) (string, error) {
+	sortName := func(entry gitTreeEntry) string {
+		if entry.isDir() {
+			return entry.Name + "/"
+		}
+		return entry.Name
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortName(entries[i]) < sortName(entries[j]) })
+
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		raw, err := hex.DecodeString(entry.Hash)
+		if err != nil {
+			return "", err
+		}
+		buf.WriteString(entry.Mode + " " + entry.Name + "\x00")
+		buf.Write(raw)
+	}
+	return repo.WriteObject("tree", buf.Bytes())
+}
+
+// WriteCommit stores a commit object, returning its hash
+func (repo *GitRepository) WriteCommit(tree string, parents []string, author GitIdentity, when time.Time, message string) (string, error) {
+	signature := fmt.Sprintf("%v <%v> %v %v", author.Name, author.Email, when.Unix(), when.Format("-0700"))
+
+	b := strings.Builder{}
+	b.WriteString("tree " + tree + "\n")
+	for _, parent := range parents {
+		b.WriteString("parent " + parent + "\n")
+	}
+	b.WriteString("author " + signature + "\n")
+	b.WriteString("committer " + signature + "\n")
+	b.WriteString("\n" + message)
+	if !strings.HasSuffix(message, "\n") {
+		b.WriteString("\n")
+	}
+
+	return repo.WriteObject("commit", []byte(b.String()))
+}
+
+// UpdateHead points the branch checked out at HEAD to the commit. A detached HEAD is updated itself.
+func (repo *GitRepository) UpdateHead(hash string) error {
+	ref := "HEAD"
+	for depth := 0; depth < 10; depth++ {
+		data, err := os.ReadFile(filepath.Join(repo.dir, filepath.FromSlash(ref)))
+		if err != nil || !strings.HasPrefix(string(data), "ref: ") {
+			break
+		}
+		ref = strings.TrimSpace(strings.TrimPrefix(string(data), "ref: "))
+	}
+
+	p := filepath.Join(repo.dir, filepath.FromSlash(ref))
+	if err := os.MkdirAll(filepath.Dir(p), 0775); err != nil {
+		return err
+	}
+	return writeFileAtomic(p, []byte(hash+"\n"), 0664)
+}
+
+// readIndex reads the paths, modes, hashes and file information of a version 2 or 3 index. Any other
+// index, or one which cannot be read, is ignored, so every file is hashed again.
+func (repo *GitRepository) readIndex() map[string]gitIndexEntry {
+	entries := make(map[string]gitIndexEntry)
+
+	p := filepath.Join(repo.dir, "index")
+	data, err := os.ReadFile(p)
+	if err != nil || len(data) < 12 || string(data[:4]) != "DIRC" {
+		return entries
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return entries
+	}
+
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version != 2 && version != 3 {
+		return entries
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+
+	offset := 12
+	for i := uint32(0); i < count; i++ {
+		if len(data) < offset+62 {
+			return map[string]gitIndexEntry{}
+		}
+		e := data[offset:]
+		flags := binary.BigEndian.Uint16(e[60:62])
+		fixed := 62
+		if flags&0x4000 != 0 {
+			fixed += 2 // version 3 extended flags
+		}
+
+		end := bytes.IndexByte(e[fixed:], 0)
+		if end < 0 {
+			return map[string]gitIndexEntry{}
+		}
+		name := string(e[fixed : fixed+end])
+		offset += (fixed + end + 8) &^ 7
+
+		entry := gitIndexEntry{
+			Path:    name,
+			Mode:    binary.BigEndian.Uint32(e[24:28]),
+			Hash:    hex.EncodeToString(e[40:60]),
+			Size:    int64(binary.BigEndian.Uint32(e[36:40])),
+			ModTime: time.Unix(int64(binary.BigEndian.Uint32(e[8:12])), int64(binary.BigEndian.Uint32(e[12:16]))),
+		}
+
+		// A file modified in the same second the index was written may have changed again since, so its
+		// hash is not trusted
+		if entry.ModTime.Before(info.ModTime().Truncate(time.Second)) {
+			entries[name] = entry
+		}
+	}
+	return entries
+}
+
+// writeIndex replaces the index with a version 2 index of the entries, so that git sees the working tree
+// as committed. Only the modification time and size are recorded, so git compares the contents of each
+// file the first time it checks it.
+func (repo *GitRepository) writeIndex(entries []gitIndexEntry) error {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+
+	var buf bytes.Buffer
+	buf.WriteString("DIRC")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, uint32(len(entries)))
+
+	for _, entry := range entries {
+		raw, err := hex.DecodeString(entry.Hash)
+		if err != nil {
+			return err
+		}
+
+		start := buf.Len()
+		mtime := uint32(entry.ModTime.Unix())
+		nsec := uint32(entry.ModTime.Nanosecond())
+		for _, field := range []uint32{mtime, nsec, mtime, nsec, 0, 0, entry.Mode, 0, 0, uint32(entry.Size)} {
+			binary.Write(&buf, binary.BigEndian, field)
+		}
+		buf.Write(raw)
+
+		flags := len(entry.Path)
+		if flags > 0xfff {
+			flags = 0xfff
+		}
+		binary.Write(&buf, binary.BigEndian, uint16(flags))
+		buf.WriteString(entry.Path)
+
+		// Entries are padded with one to eight null bytes to a multiple of eight bytes
+		padding := 8 - (buf.Len()-start)%8
+		buf.Write(make([]byte, padding))
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	p := filepath.Join(repo.dir, "index")
+	if err := writeFileAtomic(p, buf.Bytes(), 0664); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return errors.New("The index is locked by another git process: " + p + ".lock")
+		}
+		return err
+	}
+	return nil
+}
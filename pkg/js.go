@@ -0,0 +1,658 @@
+package anubis
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// isJavaScriptType reports whether a content type or <script> type attribute names JavaScript
+func isJavaScriptType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "javascript") || strings.Contains(contentType, "ecmascript")
+}
+
+// isScriptType reports whether a <script> element with the given type attribute runs as JavaScript,
+// either as a classic script or as a module
+func isScriptType(typ string) bool {
+	typ = strings.ToLower(strings.TrimSpace(typ))
+	return typ == "" || typ == "module" || isJavaScriptType(typ)
+}
+
+// ImportMap maps module specifiers to URLs, as declared by a <script type="importmap">. Specifiers which
+// look like URLs, the addresses they map to and the scope prefixes are all stored as absolute URLs.
+type ImportMap struct {
+	Imports map[string]string
+	Scopes  map[string]map[string]string
+}
+
+// ParseImportMap parses the JSON contents of an import map, resolving its URLs against the base URL of
+// the page declaring it. Entries whose address cannot be resolved are dropped.
+func ParseImportMap(base string, data []byte) (*ImportMap, error) {
+	var raw struct {
+		Imports map[string]*string
+		Scopes  map[string]map[string]*string
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	m := &ImportMap{Imports: parseSpecifierMap(base, raw.Imports), Scopes: make(map[string]map[string]string)}
+	for scope, imports := range raw.Scopes {
+		if u, err := getFullURL(base, scope); err == nil {
+			m.Scopes[u] = parseSpecifierMap(base, imports)
+		}
+	}
+	return m, nil
+}
+
+// parseSpecifierMap resolves the specifiers and addresses of the "imports" of an import map or one of
+// its scopes. An address mapping a prefix ending in a slash must also end in a slash.
+func parseSpecifierMap(base string, raw map[string]*string) map[string]string {
+	imports := make(map[string]string)
+	for specifier, address := range raw {
+		if address == nil || specifier == "" {
+			continue
+		}
+
+		u, err := getFullURL(base, *address)
+		if err != nil || (strings.HasSuffix(specifier, "/") && !strings.HasSuffix(u, "/")) {
+			continue
+		}
+
+		if key, ok := urlLikeSpecifier(base, specifier); ok {
+			specifier = key
+		}
+		imports[specifier] = u
+	}
+	return imports
+}
+
+// urlLikeSpecifier resolves a module specifier which is a URL, or a path starting with "/", "./" or
+// "../". Any other specifier is bare, and can only be resolved through an import map.
+func urlLikeSpecifier(referrer string, specifier string) (string, bool) {
+	if strings.HasPrefix(specifier, "/") || strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") {
+		u, err := getFullURL(referrer, specifier)
+		return u, err == nil
+	}
+
+	if u, err := url.Parse(specifier); err == nil && u.Scheme != "" {
+		return specifier, true
+	}
+	return "", false
+}
+
+// Resolve returns the URL a module specifier imported by the script at referrer refers to. The scopes
+// containing the referrer are consulted from the most specific, then the top-level imports. Specifiers
+// which are not mapped resolve as URLs, except bare specifiers, which are an error. A nil ImportMap maps
+// nothing.
+func (m *ImportMap) Resolve(specifier string, referrer string) (string, error) {
+	key, isURL := urlLikeSpecifier(referrer, specifier)
+	if !isURL {
+		key = specifier
+	}
+
+	if m != nil {
+		scopes := []string{}
+		for scope := range m.Scopes {
+			if referrer == scope || (strings.HasSuffix(scope, "/") && strings.HasPrefix(referrer, scope)) {
+				scopes = append(scopes, scope)
+			}
+		}
+		sort.Slice(scopes, func(i, j int) bool { return len(scopes[i]) > len(scopes[j]) })
+
+		for _, imports := range append(mapsOf(m.Scopes, scopes), m.Imports) {
+			if u, ok := matchSpecifierMap(imports, key); ok {
+				return u, nil
+			}
+		}
+	}
+
+	if !isURL {
+		return "", errors.New("Bare specifier " + specifier + " is not in the import map")
+	}
+	return key, nil
+}
+
+// mapsOf returns the specifier maps of the given scopes, in order
+func mapsOf(scopes map[string]map[string]string, keys []string) []map[string]string {
+	maps := []map[string]string{}
+	for _, key := range keys {
+		maps = append(maps, scopes[key])
+	}
+	return maps
+}
+
+// matchSpecifierMap looks a specifier up in a specifier map, preferring an exact match to the longest
+// prefix ending in a slash
+func matchSpecifierMap(imports map[string]string, specifier string) (string, bool) {
+	if u, ok := imports[specifier]; ok {
+		return u, true
+	}
+
+	best := ""
+	for prefix := range imports {
+		if strings.HasSuffix(prefix, "/") && strings.HasPrefix(specifier, prefix) && len(prefix) > len(best) {
+			best = prefix
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+
+	u, err := getFullURL(imports[best], specifier[len(best):])
+	return u, err == nil
+}
+
+// merge adds the entries of another import map which this one does not already define. As in browsers,
+// the first map to define a specifier wins.
+func (m *ImportMap) merge(other *ImportMap) {
+	if m.Imports == nil {
+		m.Imports = make(map[string]string)
+	}
+	if m.Scopes == nil {
+		m.Scopes = make(map[string]map[string]string)
+	}
+
+	mergeSpecifierMap(m.Imports, other.Imports)
+	for scope, imports := range other.Scopes {
+		if m.Scopes[scope] == nil {
+			m.Scopes[scope] = make(map[string]string)
+		}
+		mergeSpecifierMap(m.Scopes[scope], imports)
+	}
+}
+
+func mergeSpecifierMap(dst map[string]string, src map[string]string) {
+	for specifier, u := range src {
+		if _, exists := dst[specifier]; !exists {
+			dst[specifier] = u
+		}
+	}
+}
+
+// importMapSet combines the import maps declared by every page archived, since a script does not know
+// which page loaded it
+type importMapSet struct {
+	mu sync.Mutex
+	m  ImportMap
+}
+
+// addImportMap records the import map declared by a page
+func (a *Anubis) addImportMap(m *ImportMap) {
+	if a.importMaps == nil || m == nil {
+		return
+	}
+
+	a.importMaps.mu.Lock()
+	defer a.importMaps.mu.Unlock()
+	a.importMaps.m.merge(m)
+}
+
+// importMap returns a copy of the import maps declared by the pages archived so far
+func (a *Anubis) importMap() *ImportMap {
+	m := &ImportMap{}
+	if a.importMaps == nil {
+		return m
+	}
+
+	a.importMaps.mu.Lock()
+	defer a.importMaps.mu.Unlock()
+	m.merge(&a.importMaps.m)
+	return m
+}
+
+// ExtractJSReferences scans a script for the modules, workers and source maps it loads: static import and
+// export ... from declarations, import() calls with a string literal, new Worker, new SharedWorker,
+// navigator.serviceWorker.register and importScripts calls, new URL(..., import.meta.url) expressions and
+// sourceMappingURL comments. Start and End are the byte offsets of each string within the script,
+// excluding quotes, and Raw is the decoded string.
+//
+// Module specifiers are resolved against the script's URL through the import map, and bare specifiers it
+// does not map are returned with an empty URL. Other URLs are resolved against the script's URL, although
+// browsers resolve worker URLs against the page running the script.
+func ExtractJSReferences(parent string, js []byte, imports *ImportMap) []Reference {
+	refs := scriptReferences(js, 0)
+	for i := range refs {
+		resolveScriptReference(&refs[i], parent, imports)
+	}
+	return refs
+}
+
+// resolveScriptReference sets the URL of a reference found in a script
+func resolveScriptReference(ref *Reference, base string, imports *ImportMap) {
+	var u string
+	var err error
+	if ref.Attr == "import" {
+		u, err = imports.Resolve(ref.Raw, base)
+	} else {
+		u, err = getFullURL(base, ref.Raw)
+	}
+
+	if err == nil {
+		ref.URL = u
+	}
+	ref.resolved = true
+}
+
+// jsKeywordsBeforeExpression are the keywords after which a slash starts a regular expression rather than
+// a division
+var jsKeywordsBeforeExpression = map[string]bool{
+	"await": true, "case": true, "delete": true, "do": true, "else": true, "in": true, "instanceof": true,
+	"new": true, "of": true, "return": true, "throw": true, "typeof": true, "void": true, "yield": true,
+}
+
+// scriptReferences returns the references in a script without resolving them. Offset is added to each
+// position, for scripts embedded in a page.
+func scriptReferences(js []byte, offset int) []Reference {
+	tokens, comments := scanJS(js)
+	refs := []Reference{}
+
+	add := func(attr string, tok jsToken) {
+		if tok.text == "" {
+			return
+		}
+		refs = append(refs, Reference{
+			Kind:  AssetReference,
+			Tag:   "script",
+			Attr:  attr,
+			Raw:   tok.text,
+			Start: offset + tok.start,
+			End:   offset + tok.end,
+		})
+	}
+
+	// at returns the token at index i, or an empty token past either end
+	at := func(i int) jsToken {
+		if i < 0 || i >= len(tokens) {
+			return jsToken{kind: jsEOF}
+		}
+		return tokens[i]
+	}
+	is := func(i int, kind jsTokenKind, text string) bool {
+		tok := at(i)
+		return tok.kind == kind && tok.text == text
+	}
+
+	// expectFrom is set within an import or export declaration, until its module specifier is found
+	expectFrom := false
+
+	for i, tok := range tokens {
+		if tok.kind == jsPunct && tok.text == ";" {
+			expectFrom = false
+			continue
+		}
+		// Only serviceWorker is expected as a property, of navigator
+		if tok.kind != jsIdent || (is(i-1, jsPunct, ".") && tok.text != "serviceWorker") {
+			continue
+		}
+
+		switch tok.text {
+		case "import":
+			switch next := at(i + 1); {
+			case next.kind == jsString:
+				add("import", next)
+			case is(i+1, jsPunct, "(") && at(i+2).kind == jsString && (is(i+3, jsPunct, ")") || is(i+3, jsPunct, ",")):
+				add("import", at(i+2))
+			case is(i+1, jsPunct, "(") || is(i+1, jsPunct, "."):
+			default:
+				expectFrom = true
+			}
+
+		case "export":
+			expectFrom = true
+
+		case "from":
+			if expectFrom && at(i+1).kind == jsString {
+				add("import", at(i+1))
+				expectFrom = false
+			}
+
+		case "Worker", "SharedWorker":
+			if is(i-1, jsIdent, "new") && is(i+1, jsPunct, "(") && at(i+2).kind == jsString {
+				add("worker", at(i+2))
+			}
+
+		case "serviceWorker":
+			if is(i+1, jsPunct, ".") && is(i+2, jsIdent, "register") && is(i+3, jsPunct, "(") && at(i+4).kind == jsString {
+				add("worker", at(i+4))
+			}
+
+		case "importScripts":
+			if !is(i+1, jsPunct, "(") {
+				continue
+			}
+			for j := i + 2; at(j).kind == jsString; j += 2 {
+				add("worker", at(j))
+				if !is(j+1, jsPunct, ",") {
+					break
+				}
+			}
+
+		case "URL":
+			// Only URLs relative to the script itself are known to be resolved against it
+			if is(i-1, jsIdent, "new") && is(i+1, jsPunct, "(") && at(i+2).kind == jsString && is(i+3, jsPunct, ",") &&
+				is(i+4, jsIdent, "import") && is(i+5, jsPunct, ".") && is(i+6, jsIdent, "meta") &&
+				is(i+7, jsPunct, ".") && is(i+8, jsIdent, "url") {
+				add("url", at(i+2))
+			}
+		}
+	}
+
+	for _, comment := range comments {
+		if tok, ok := sourceMappingURL(js, comment); ok {
+			add("sourcemap", tok)
+		}
+	}
+
+	return refs
+}
+
+// sourceMappingURL returns the URL of a "# sourceMappingURL=" comment, or the older "@" form
+func sourceMappingURL(js []byte, comment jsToken) (jsToken, bool) {
+	body := comment.text
+	if len(body) < 2 || (body[0] != '#' && body[0] != '@') {
+		return jsToken{}, false
+	}
+
+	rest := strings.TrimLeft(body[1:], " \t")
+	if !strings.HasPrefix(rest, "sourceMappingURL=") {
+		return jsToken{}, false
+	}
+
+	start := comment.start + len(body) - len(rest) + len("sourceMappingURL=")
+	end := start
+	for end < comment.end && !isJSSpace(js[end]) {
+		end++
+	}
+	return jsToken{kind: jsString, text: string(js[start:end]), start: start, end: end}, true
+}
+
+type jsTokenKind int
+
+const (
+	jsEOF jsTokenKind = iota
+	jsIdent
+	jsNumber
+	jsString
+	jsTemplate // jsTemplate is the text of a template literal up to its end or its next substitution
+	jsRegExp
+	jsPunct
+	jsComment
+)
+
+// jsToken is a token of a script. The text of an identifier or punctuator is as written, that of a
+// string is its decoded value and that of a comment is the text between its delimiters. The offsets of
+// strings and comments exclude their delimiters.
+type jsToken struct {
+	kind       jsTokenKind
+	text       string
+	start, end int
+}
+
+func isJSSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+func isJSIdentByte(c byte) bool {
+	return c == '$' || c == '_' || c == '\\' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// scanJS splits a script into tokens, returning its comments separately. It only distinguishes what is
+// needed to find references reliably: strings, template literals, regular expressions and comments are
+// skipped whole, so that their contents are never mistaken for code, and every punctuator is a single
+// character. Malformed scripts are scanned as far as possible.
+func scanJS(js []byte) ([]jsToken, []jsToken) {
+	tokens, comments := []jsToken{}, []jsToken{}
+
+	// braces records, for each open brace, whether it began a substitution within a template literal
+	braces := []bool{}
+
+	for i := 0; i < len(js); {
+		c := js[i]
+		switch {
+		case isJSSpace(c):
+			i++
+
+		case c == '/' && i+1 < len(js) && js[i+1] == '/':
+			end := i + 2
+			for end < len(js) && js[end] != '\n' && js[end] != '\r' {
+				end++
+			}
+			comments = append(comments, jsToken{kind: jsComment, text: string(js[i+2 : end]), start: i + 2, end: end})
+			i = end
+
+		case c == '/' && i+1 < len(js) && js[i+1] == '*':
+			end := strings.Index(string(js[i+2:]), "*/")
+			if end < 0 {
+				end = len(js) - i - 2
+			}
+			comments = append(comments, jsToken{kind: jsComment, text: string(js[i+2 : i+2+end]), start: i + 2, end: i + 2 + end})
+			i += end + 4
+
+		case c == '"' || c == '\'':
+			value, end := scanJSString(js, i)
+			tokens = append(tokens, jsToken{kind: jsString, text: value, start: i + 1, end: end})
+			i = end + 1
+
+		case c == '`':
+			i = scanJSTemplate(js, i+1, &tokens, &braces)
+
+		case c == '}' && len(braces) > 0 && braces[len(braces)-1]:
+			braces = braces[:len(braces)-1]
+			i = scanJSTemplate(js, i+1, &tokens, &braces)
+
+		case c >= '0' && c <= '9' || (c == '.' && i+1 < len(js) && js[i+1] >= '0' && js[i+1] <= '9'):
+			end := i + 1
+			for end < len(js) && (isJSIdentByte(js[end]) || js[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, jsToken{kind: jsNumber, text: string(js[i:end]), start: i, end: end})
+			i = end
+
+		case isJSIdentByte(c):
+			end := i + 1
+			for end < len(js) && isJSIdentByte(js[end]) {
+				end++
+			}
+			tokens = append(tokens, jsToken{kind: jsIdent, text: string(js[i:end]), start: i, end: end})
+			i = end
+
+		case c == '/' && regExpAllowed(tokens):
+			end, ok := scanJSRegExp(js, i)
+			if !ok {
+				tokens = append(tokens, jsToken{kind: jsPunct, text: "/", start: i, end: i + 1})
+				i++
+				continue
+			}
+			tokens = append(tokens, jsToken{kind: jsRegExp, text: string(js[i:end]), start: i, end: end})
+			i = end
+
+		default:
+			switch c {
+			case '{':
+				braces = append(braces, false)
+			case '}':
+				if len(braces) > 0 {
+					braces = braces[:len(braces)-1]
+				}
+			}
+			tokens = append(tokens, jsToken{kind: jsPunct, text: string(c), start: i, end: i + 1})
+			i++
+		}
+	}
+
+	return tokens, comments
+}
+
+// regExpAllowed reports whether a slash following the tokens starts a regular expression, which is the
+// case wherever an expression may start
+func regExpAllowed(tokens []jsToken) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+
+	switch last := tokens[len(tokens)-1]; last.kind {
+	case jsPunct:
+		return last.text != ")" && last.text != "]"
+	case jsIdent:
+		return jsKeywordsBeforeExpression[last.text]
+	}
+	return false
+}
+
+// scanJSRegExp returns the end of a regular expression literal starting at i, including its flags. A
+// regular expression cannot span lines, so a slash without a closing slash on its line is a division.
+func scanJSRegExp(js []byte, i int) (int, bool) {
+	inClass := false
+	for j := i + 1; j < len(js); j++ {
+		switch js[j] {
+		case '\\':
+			j++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '\n', '\r':
+			return 0, false
+		case '/':
+			if inClass {
+				continue
+			}
+			j++
+			for j < len(js) && isJSIdentByte(js[j]) {
+				j++
+			}
+			return j, true
+		}
+	}
+	return 0, false
+}
+
+// scanJSTemplate scans the text of a template literal from i, up to its closing backtick or its next
+// substitution, returning the offset to continue from. A substitution is recorded as an open brace, so
+// that the template continues after the matching close brace.
+func scanJSTemplate(js []byte, i int, tokens *[]jsToken, braces *[]bool) int {
+	for j := i; j < len(js); j++ {
+		switch {
+		case js[j] == '\\':
+			j++
+		case js[j] == '`':
+			*tokens = append(*tokens, jsToken{kind: jsTemplate, text: string(js[i:j]), start: i, end: j})
+			return j + 1
+		case js[j] == '$' && j+1 < len(js) && js[j+1] == '{':
+			*tokens = append(*tokens, jsToken{kind: jsTemplate, text: string(js[i:j]), start: i, end: j})
+			*braces = append(*braces, true)
+			return j + 2
+		}
+	}
+
+	*tokens = append(*tokens, jsToken{kind: jsTemplate, text: string(js[i:]), start: i, end: len(js)})
+	return len(js)
+}
+
+// scanJSString decodes the string literal whose opening quote is at i, returning its value and the offset
+// of its closing quote. An unterminated string ends at the end of its line.
+func scanJSString(js []byte, i int) (string, int) {
+	quote := js[i]
+	b := strings.Builder{}
+
+	j := i + 1
+	for ; j < len(js) && js[j] != quote && js[j] != '\n' && js[j] != '\r'; j++ {
+		if js[j] != '\\' || j+1 == len(js) {
+			b.WriteByte(js[j])
+			continue
+		}
+
+		j++
+		switch c := js[j]; c {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'v':
+			b.WriteByte('\v')
+		case '0':
+			b.WriteByte(0)
+		case '\r':
+			// A backslash before a line break continues the string on the next line
+			if j+1 < len(js) && js[j+1] == '\n' {
+				j++
+			}
+		case '\n':
+		case 'x', 'u':
+			r, n := decodeJSEscape(js[j:])
+			if n == 0 {
+				b.WriteByte(c)
+				continue
+			}
+			b.WriteRune(r)
+			j += n - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return b.String(), j
+}
+
+// decodeJSEscape decodes a \x, \u or \u{} escape, given the bytes after the backslash. It returns the
+// number of bytes the escape uses, or zero if it is malformed.
+func decodeJSEscape(s []byte) (rune, int) {
+	digits, n := "", 0
+	switch {
+	case s[0] == 'x' && len(s) >= 3:
+		digits, n = string(s[1:3]), 3
+	case s[0] == 'u' && len(s) >= 2 && s[1] == '{':
+		end := strings.IndexByte(string(s), '}')
+		if end < 0 {
+			return 0, 0
+		}
+		digits, n = string(s[2:end]), end+1
+	case s[0] == 'u' && len(s) >= 5:
+		digits, n = string(s[1:5]), 5
+	default:
+		return 0, 0
+	}
+
+	r, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || r > utf8.MaxRune {
+		return 0, 0
+	}
+	return rune(r), n
+}
+
+// moduleSpecifier makes a relative link usable as a module specifier, which must start with "/", "./" or
+// "../" so that it is not mistaken for a bare specifier
+func moduleSpecifier(link string) string {
+	if _, ok := urlLikeSpecifier("http://localhost/", link); ok {
+		return link
+	}
+	return "./" + link
+}
+
+// jsEscape escapes a string for use within a quoted JavaScript string
+func jsEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `'`, `\'`, "\n", `\n`, "\r", `\r`).Replace(s)
+}
+
+// jsReferenceEscaper returns how a replacement for a reference in a script is escaped. Source map URLs
+// end at the first space, so spaces are percent-encoded instead.
+func jsReferenceEscaper(ref Reference) func(string) string {
+	if ref.Attr == "sourcemap" {
+		return func(s string) string { return strings.ReplaceAll(s, " ", "%20") }
+	}
+	return func(s string) string { return jsEscape(moduleSpecifier(s)) }
+}
@@ -0,0 +1,253 @@
+package anubis
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExtractJSReferences(t *testing.T) {
+	script := `import React from "react";
+import {a, b as from} from './lib/a.js'
+import * as c from '../c.js';
+import "./side-effect.js";
+export {d} from "/d.js";
+export * from "https://cdn.test.com/e.js";
+const f = await import("./f.js");
+const g = import(name);
+const h = import.meta.url;
+const i = "import x from './not-an-import.js'";
+// import "./commented.js"
+/* new Worker("./commented-worker.js") */
+const j = ` + "`import(\"./template.js\") ${import(\"./substitution.js\")}`" + `;
+const k = /import("\/regexp.js")/.test(i) ? 1 / 2 : "/";
+new Worker("worker.js", {type: "module"});
+new SharedWorker('shared.js');
+navigator.serviceWorker.register("/sw.js");
+importScripts("one.js", "two.js");
+const l = new URL("./data.json", import.meta.url);
+const m = new URL("/api", location);
+const n = "\x2e/escaped.js";
+import(n === "." ? "./dynamic.js" : other);
+//# sourceMappingURL=app.js.map
+`
+
+	imports := &ImportMap{Imports: map[string]string{"react": "https://cdn.test.com/react.js"}}
+	got := ExtractJSReferences("https://www.test.com/js/app.js", []byte(script), imports)
+
+	want := []struct {
+		attr string
+		raw  string
+		url  string
+	}{
+		{"import", "react", "https://cdn.test.com/react.js"},
+		{"import", "./lib/a.js", "https://www.test.com/js/lib/a.js"},
+		{"import", "../c.js", "https://www.test.com/c.js"},
+		{"import", "./side-effect.js", "https://www.test.com/js/side-effect.js"},
+		{"import", "/d.js", "https://www.test.com/d.js"},
+		{"import", "https://cdn.test.com/e.js", "https://cdn.test.com/e.js"},
+		{"import", "./f.js", "https://www.test.com/js/f.js"},
+		{"import", "./substitution.js", "https://www.test.com/js/substitution.js"},
+		{"worker", "worker.js", "https://www.test.com/js/worker.js"},
+		{"worker", "shared.js", "https://www.test.com/js/shared.js"},
+		{"worker", "/sw.js", "https://www.test.com/sw.js"},
+		{"worker", "one.js", "https://www.test.com/js/one.js"},
+		{"worker", "two.js", "https://www.test.com/js/two.js"},
+		{"url", "./data.json", "https://www.test.com/js/data.json"},
+		{"sourcemap", "app.js.map", "https://www.test.com/js/app.js.map"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ExtractJSReferences() = %+v, want %v references", got, len(want))
+	}
+
+	for i, w := range want {
+		ref := got[i]
+		if ref.Kind != AssetReference || ref.Tag != "script" || ref.Attr != w.attr || ref.Raw != w.raw || ref.URL != w.url {
+			t.Errorf("reference %v = %+v, want %v %q %v", i, ref, w.attr, w.raw, w.url)
+		}
+		if script[ref.Start:ref.End] != w.raw {
+			t.Errorf("offsets of %v cover %q", ref.Raw, script[ref.Start:ref.End])
+		}
+	}
+
+	// Bare specifiers cannot be resolved without an import map
+	got = ExtractJSReferences("https://www.test.com/app.js", []byte(`import {h} from "preact"`), nil)
+	if len(got) != 1 || got[0].Raw != "preact" || got[0].URL != "" {
+		t.Errorf("ExtractJSReferences() of a bare specifier = %+v", got)
+	}
+}
+
+func TestExtractJSReferences_escapes(t *testing.T) {
+	got := ExtractJSReferences("https://www.test.com/", []byte(`import "./\x61.js"; import './b\'.js'; import "\u{63}.js"`), nil)
+
+	want := []string{"./a.js", "./b'.js", "c.js"}
+	if len(got) != len(want) {
+		t.Fatalf("ExtractJSReferences() = %+v, want %q", got, want)
+	}
+	for i, w := range want {
+		if got[i].Raw != w {
+			t.Errorf("reference %v = %q, want %q", i, got[i].Raw, w)
+		}
+	}
+}
+
+func TestImportMap_Resolve(t *testing.T) {
+	m, err := ParseImportMap("https://www.test.com/app/", []byte(`{
+  "imports": {
+    "lodash": "/vendor/lodash.js",
+    "lodash/": "/vendor/lodash/",
+    "./old.js": "./new.js",
+    "broken/": "/not-a-prefix.js",
+    "removed": null
+  },
+  "scopes": {
+    "/legacy/": {"lodash": "https://cdn.test.com/lodash@3.js"}
+  }
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		specifier string
+		referrer  string
+		want      string
+		wantErr   bool
+	}{
+		{"lodash", "https://www.test.com/app/main.js", "https://www.test.com/vendor/lodash.js", false},
+		{"lodash/fp/map.js", "https://www.test.com/app/main.js", "https://www.test.com/vendor/lodash/fp/map.js", false},
+		{"lodash", "https://www.test.com/legacy/main.js", "https://cdn.test.com/lodash@3.js", false},
+		{"lodash/fp.js", "https://www.test.com/legacy/main.js", "https://www.test.com/vendor/lodash/fp.js", false},
+		{"./old.js", "https://www.test.com/app/main.js", "https://www.test.com/app/new.js", false},
+		{"./old.js", "https://www.test.com/other/main.js", "https://www.test.com/other/old.js", false},
+		{"https://cdn.test.com/x.js", "https://www.test.com/app/main.js", "https://cdn.test.com/x.js", false},
+		{"broken/x.js", "https://www.test.com/app/main.js", "", true},
+		{"removed", "https://www.test.com/app/main.js", "", true},
+		{"unmapped", "https://www.test.com/app/main.js", "", true},
+	}
+	for _, tt := range tests {
+		got, err := m.Resolve(tt.specifier, tt.referrer)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("Resolve(%q, %q) = %q, %v, want %q", tt.specifier, tt.referrer, got, err, tt.want)
+		}
+	}
+
+	if _, err := ParseImportMap("https://www.test.com/", []byte("not json")); err == nil {
+		t.Errorf("ParseImportMap() of invalid JSON succeeded")
+	}
+}
+
+func TestExtractReferences_scripts(t *testing.T) {
+	page := `<script type="importmap">{"imports": {"app": "/js/app.js", "lib/": "/js/lib/"}}</script>` +
+		`<script src="/js/classic.js"></script>` +
+		`<script type="module">import "app"; import "lib/a.js"; import "missing";</script>` +
+		`<script>new Worker("/worker.js")</script>` +
+		`<script type="text/template"><img src="{{src}}"></script>` +
+		`<script type="application/ld+json">{"url": "/data.json"}</script>`
+
+	got := ExtractReferences("https://www.test.com/page.html", []byte(page))
+
+	want := []struct {
+		kind ReferenceKind
+		attr string
+		raw  string
+		url  string
+	}{
+		{AssetReference, "importmap", "/js/app.js", "https://www.test.com/js/app.js"},
+		{MetadataReference, "importmap", "/js/lib/", "https://www.test.com/js/lib/"},
+		{AssetReference, "src", "/js/classic.js", "https://www.test.com/js/classic.js"},
+		{AssetReference, "import", "app", "https://www.test.com/js/app.js"},
+		{AssetReference, "import", "lib/a.js", "https://www.test.com/js/lib/a.js"},
+		{AssetReference, "import", "missing", ""},
+		{AssetReference, "worker", "/worker.js", "https://www.test.com/worker.js"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ExtractReferences() = %+v, want %v references", got, len(want))
+	}
+
+	for i, w := range want {
+		ref := got[i]
+		if ref.Kind != w.kind || ref.Tag != "script" || ref.Attr != w.attr || ref.Raw != w.raw || ref.URL != w.url {
+			t.Errorf("reference %v = %+v, want %v %v %q %v", i, ref, w.kind, w.attr, w.raw, w.url)
+		}
+		if page[ref.Start:ref.End] != w.raw {
+			t.Errorf("offsets of %v cover %q", ref.Raw, page[ref.Start:ref.End])
+		}
+	}
+
+	if got := GetScriptURLs("https://www.test.com/page.html", page); len(got) != 1 {
+		t.Errorf("GetScriptURLs() = %v, want only the src attribute", got)
+	}
+}
+
+func TestDefaultResponseHandler_Handle_javascript(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+
+	page := `<script type="importmap">{"imports": {"ui": "https://cdn.test.com/ui.js"}}</script>` +
+		`<script type="module" src="/js/app.js"></script>`
+	req, resp := newTestResponse(t, "https://www.test.com/", 200, "text/html", page)
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// The page's import map resolves the bare specifier in the script it loads
+	req, resp = newTestResponse(t, "https://www.test.com/js/app.js", 200, "text/javascript; charset=utf-8",
+		`import "ui"; import("./lazy.js#chunk"); //# sourceMappingURL=app.js.map`)
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, u := range []string{
+		"https://cdn.test.com/ui.js",
+		"https://www.test.com/js/app.js",
+		"https://www.test.com/js/lazy.js",
+		"https://www.test.com/js/app.js.map",
+	} {
+		if !a.Filter.TestURL(u) {
+			t.Errorf("%v was not queued", u)
+		}
+	}
+	if got := a.Pending(); got != 4 {
+		t.Errorf("Handle() queued %v URLs, want 4", got)
+	}
+}
+
+func TestAnubis_Rewrite_javascript(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+	a.addImportMap(&ImportMap{Imports: map[string]string{"ui": "https://www.test.com/js/ui.js"}})
+
+	for _, entry := range []ManifestEntry{
+		{URL: "https://www.test.com/", Path: "www.test.com/index.html", ContentType: "text/html"},
+		{URL: "https://www.test.com/js/app.js", Path: "www.test.com/js/app.js", ContentType: "application/javascript"},
+		{URL: "https://www.test.com/js/ui.js", Path: "www.test.com/js/ui.js", ContentType: "application/javascript"},
+		{URL: "https://www.test.com/js/app.js.map", Path: "www.test.com/js/app.js.map", ContentType: "application/json"},
+	} {
+		a.Manifest.Record(entry)
+	}
+
+	files := map[string]string{
+		"www.test.com/index.html":    `<script type="importmap">{"imports": {"ui": "/js/ui.js"}}</script><script type="module">import "/js/app.js"</script>`,
+		"www.test.com/js/app.js":     `import "ui"; import "/js/ui.js"; import "/js/missing.js"; //# sourceMappingURL=/js/app.js.map`,
+		"www.test.com/js/ui.js":      `export const ui = 1;`,
+		"www.test.com/js/app.js.map": `{}`,
+	}
+	for name, content := range files {
+		if err := a.writeOutput(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := a.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"www.test.com/index.html": `<script type="importmap">{"imports": {"ui": "./js/ui.js"}}</script><script type="module">import "./js/app.js"</script>`,
+		"www.test.com/js/app.js":  `import "./ui.js"; import "./ui.js"; import "/js/missing.js"; //# sourceMappingURL=app.js.map`,
+	}
+	for name, w := range want {
+		got, err := os.ReadFile(a.outputPath(name))
+		if err != nil || string(got) != w {
+			t.Errorf("Rewrite() of %v = %s, %v, want %v", name, got, err, w)
+		}
+	}
+}
@@ -4,85 +4,374 @@ import (
 	"errors"
 	"log"
 	"net/url"
-	"path"
-	"regexp"
+	"strings"
 )
 
-var (
-	URLRE    = regexp.MustCompile("https?://[-a-zA-Z0-9@:%._+~#=]{1,256}\\.[a-zA-Z0-9()]{1,6}\\b[-a-zA-Z0-9()@:%_+.~#?&/=]*")
-	LinkRE   = regexp.MustCompile("<(link|LINK)(\\s+[a-zA-Z0-9]+(=\"[-a-zA-Z0-9 .]+\")?)*?\\s+href=\"([-a-zA-Z0-9()@:%_+.~#?&/=]+)\"(\\s+[a-zA-Z0-9]+(=\"[-a-zA-Z0-9 .]+\")?)*?\\s*/>")
-	ScriptRE = regexp.MustCompile("<(script|SCIRPT)(\\s+[a-zA-Z0-9]+(=\"[-a-zA-Z0-9 .]+\")?)*?\\s+src=\"([-a-zA-Z0-9()@:%_+.~#?&/=]+)\"(\\s+[a-zA-Z0-9]+(=\"[-a-zA-Z0-9 .]+\")?)*?\\s*(/>|>)")
-	ImageRE  = regexp.MustCompile("<img(\\s+[a-zA-Z0-9]+(=\"[-a-zA-Z0-9 .]+\")?)*?\\s+src=\"([-a-zA-Z0-9()@:%_+.~#?&/=]+)\"(\\s+[a-zA-Z0-9]+(=\"[-a-zA-Z0-9 .]+\")?)*?\\s*/?>")
+// ignoredSchemes are schemes which can appear in links but never refer to a document that can be archived
+var ignoredSchemes = map[string]bool{
+	"javascript": true,
+	"mailto":     true,
+	"data":       true,
+	"tel":        true,
+}
+
+type ReferenceKind int
+
+const (
+	// AssetReference is a resource needed to render the page, such as a stylesheet, script or image
+	AssetReference ReferenceKind = iota
+	// PageReference is a link to another document, such as an anchor or a frame
+	PageReference
+	// BaseReference is the base URL of the document, which is used to resolve all other references
+	BaseReference
+	// MetadataReference is a URL recorded about the document which is not fetched, such as its
+	// canonical URL or an origin to preconnect to
+	MetadataReference
 )
 
+// The classifications of references given by Reference.Rel
+const (
+	RelStylesheet = "stylesheet"
+	RelIcon       = "icon"
+	RelManifest   = "manifest"
+	RelPreload    = "preload"
+	RelAlternate  = "alternate"
+	RelCanonical  = "canonical"
+	RelImage      = "image"
+)
+
+// linkRel is the classification given to a <link> by one of its rel keywords
+type linkRel struct {
+	kind     ReferenceKind
+	rel      string
+	priority int // priority decides which keyword wins when a link has several, such as "alternate stylesheet"
+}
+
+// linkRels lists the rel keywords which decide how a <link> is handled. Links without any of these
+// keywords are treated as assets.
+var linkRels = map[string]linkRel{
+	"stylesheet":                   {AssetReference, RelStylesheet, 6},
+	"icon":                         {AssetReference, RelIcon, 5},
+	"apple-touch-icon":             {AssetReference, RelIcon, 5},
+	"apple-touch-icon-precomposed": {AssetReference, RelIcon, 5},
+	"mask-icon":                    {AssetReference, RelIcon, 5},
+	"manifest":                     {AssetReference, RelManifest, 4},
+	"preload":                      {AssetReference, RelPreload, 3},
+	"modulepreload":                {AssetReference, RelPreload, 3},
+	"prefetch":                     {AssetReference, RelPreload, 3},
+	"canonical":                    {MetadataReference, RelCanonical, 2},
+	"alternate":                    {PageReference, RelAlternate, 1},
+	"next":                         {PageReference, "", 1},
+	"prev":                         {PageReference, "", 1},
+	"dns-prefetch":                 {MetadataReference, "", 0},
+	"preconnect":                   {MetadataReference, "", 0},
+}
+
+// classifyLink returns the kind and classification of a <link> from its rel attribute
+func classifyLink(tok Token) (ReferenceKind, string) {
+	value, _ := tok.AttrVal("rel")
+
+	best, found := linkRel{kind: AssetReference}, false
+	for _, keyword := range strings.Fields(strings.ToLower(value)) {
+		if rel, ok := linkRels[keyword]; ok && (!found || rel.priority > best.priority) {
+			best, found = rel, true
+		}
+	}
+	return best.kind, best.rel
+}
+
+// metaImages lists the <meta> names and properties whose content is the URL of an image representing the
+// page, and how each is classified
+var metaImages = map[string]string{
+	"og:image":                RelImage,
+	"og:image:url":            RelImage,
+	"og:image:secure_url":     RelImage,
+	"twitter:image":           RelImage,
+	"twitter:image:src":       RelImage,
+	"msapplication-tileimage": RelIcon,
+}
+
+// classifyMeta returns the classification of a <meta> whose content is an image URL
+func classifyMeta(tok Token) (string, bool) {
+	for _, key := range []string{"property", "name"} {
+		if value, ok := tok.AttrVal(key); ok {
+			if rel, ok := metaImages[strings.ToLower(strings.TrimSpace(value))]; ok {
+				return rel, true
+			}
+		}
+	}
+	return "", false
+}
+
+// urlAttributes lists, for each element, the attributes which contain a URL and what kind of reference
+// the URL is
+var urlAttributes = map[string]map[string]ReferenceKind{
+	"a":      {"href": PageReference},
+	"area":   {"href": PageReference},
+	"audio":  {"src": AssetReference},
+	"base":   {"href": BaseReference},
+	"embed":  {"src": AssetReference},
+	"frame":  {"src": PageReference},
+	"iframe": {"src": PageReference},
+	"img":    {"src": AssetReference, "srcset": AssetReference},
+	"input":  {"src": AssetReference}, // only for type=image
+	"link":   {"href": AssetReference},
+	"object": {"data": AssetReference},
+	"script": {"src": AssetReference},
+	"source": {"src": AssetReference, "srcset": AssetReference},
+	"track":  {"src": AssetReference},
+	"video":  {"src": AssetReference, "poster": AssetReference},
+}
+
+// Reference is a single URL found in a document
+type Reference struct {
+	Kind ReferenceKind
+	Tag  string // Tag is the lower-cased name of the element containing the reference
+	Attr string // Attr is the lower-cased name of the attribute containing the reference
+	Rel  string // Rel classifies references from <link> and <meta> elements and manifests, such as RelIcon
+	Raw  string // Raw is the attribute value as written in the document, with character references decoded
+	URL  string // URL is the absolute URL of the reference, or empty if it could not be resolved
+
+	// Descriptor is the width or pixel density descriptor of a srcset candidate, such as "640w" or "2x"
+	Descriptor string
+
+	// Start and End are the byte offsets of the attribute value in the document, so that the
+	// reference can be replaced without re-serializing the page. For srcset candidates they cover
+	// only the candidate's URL.
+	Start int
+	End   int
+
+	set      int  // set numbers the srcset attribute a candidate belongs to, starting from one
+	resolved bool // resolved is set for references in scripts, which are resolved as they are found
+}
+
+// embedded reports whether the reference was found in the contents of a <style> or <script> element or in
+// a style attribute, rather than being the value of a URL attribute
+func (ref Reference) embedded() bool {
+	return ref.Attr == "style" || ref.Tag == "style" || (ref.Tag == "script" && ref.Attr != "src")
+}
+
+// getFullURL resolves a link against the URL of the document it was found in, following RFC 3986. The
+// result keeps the link's query and fragment. Links using a scheme other than http or https are rejected.
 func getFullURL(parent string, link string) (string, error) {
-	if !URLRE.MatchString(parent) {
+	base, err := url.Parse(parent)
+	if err != nil {
+		return "", err
+	}
+
+	if (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
 		return "", errors.New("Could not parse " + parent + " as URL")
 	}
 
-	u, err := url.Parse(parent)
+	ref, err := url.Parse(cleanLink(link))
 	if err != nil {
 		return "", err
 	}
 
-	if !URLRE.MatchString(link) {
-		link = u.Scheme + "://" + path.Join(u.Hostname(), link)
+	if ignoredSchemes[ref.Scheme] {
+		return "", errors.New("Ignoring " + ref.Scheme + " link " + link)
 	}
 
-	if !URLRE.MatchString(link) {
+	u := base.ResolveReference(ref)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
 		return "", errors.New("Could not parse " + link + " as URL")
 	}
 
-	return link, nil
+	return u.String(), nil
 }
 
-func GetLinkURLs(parent string, html string) []string {
-	urls := []string{}
+// cleanLink removes the leading and trailing whitespace, as well as any tabs and newlines, which browsers
+// ignore in URL attributes
+func cleanLink(link string) string {
+	return strings.NewReplacer("\t", "", "\n", "", "\r", "").Replace(strings.TrimSpace(link))
+}
+
+// ExtractReferences tokenizes an HTML document and returns every URL-bearing attribute it contains, in
+// document order. Each candidate of a srcset attribute is a separate reference. Links are classified by
+// their rel attribute, and the images named by Open Graph, Twitter and tile image <meta> elements are
+// included as assets. The url() functions and @import rules of <style> elements and style attributes are
+// included as asset references; those in <style> elements have the Tag "style" and those in attributes
+// have the Attr "style". The modules, workers and source maps loaded by inline scripts are included with
+// the Tag "script", as found by ExtractJSReferences, and so are the addresses of import maps, with the Attr
+// "importmap". Addresses mapping a prefix are metadata, since they are directories. Each reference is
+// resolved against the document's base URL, which is the parent URL unless the document contains a
+// <base href>, and module specifiers are resolved through the document's import maps. References which
+// cannot be resolved are still returned, but with an empty URL.
+func ExtractReferences(parent string, html []byte) []Reference {
+	refs, _ := extractReferences(parent, html)
+	return refs
+}
+
+// extractReferences implements ExtractReferences, also returning the import maps the document declares
+func extractReferences(parent string, html []byte) ([]Reference, *ImportMap) {
+	refs := []Reference{}
+	base := parent
+	hasBase := false
+	inStyle := false
+	script := "" // script is "importmap" or "script" within a <script> element which is scanned
+	imports := &ImportMap{}
+	sets := 0
 
-	matches := LinkRE.FindAllStringSubmatch(html, -1)
-	for _, match := range matches {
-		if len(match) == 7 {
-			if parsedUrl, err := getFullURL(parent, match[4]); err == nil {
-				urls = append(urls, parsedUrl)
-			} else {
-				log.Println(err)
+	z := NewTokenizer(html)
+	for {
+		tok, ok := z.Next()
+		if !ok {
+			break
+		}
+
+		// The contents of a <style> element are a single text token
+		if tok.Type == TextToken && inStyle {
+			refs = append(refs, embeddedCSSReferences(html[tok.Start:tok.End], tok.Start, "style", "", false)...)
+		}
+		inStyle = false
+
+		// So are those of a <script>, which are only scanned for inline scripts and import maps
+		if tok.Type == TextToken && script == "importmap" {
+			refs = append(refs, importMapReferences(html[tok.Start:tok.End], tok.Start)...)
+			if m, err := ParseImportMap(base, html[tok.Start:tok.End]); err == nil {
+				imports.merge(m)
+			}
+		} else if tok.Type == TextToken && script == "script" {
+			for _, ref := range scriptReferences(html[tok.Start:tok.End], tok.Start) {
+				resolveScriptReference(&ref, base, imports)
+				refs = append(refs, ref)
 			}
 		}
-	}
+		script = ""
 
-	return urls
-}
+		if tok.Type != StartTagToken && tok.Type != SelfClosingTagToken {
+			continue
+		}
+		inStyle = tok.Type == StartTagToken && tok.Data == "style"
 
-func GetScriptURLs(parent string, html string) []string {
-	urls := []string{}
+		if _, hasSrc := tok.AttrVal("src"); tok.Type == StartTagToken && tok.Data == "script" && !hasSrc {
+			typ, _ := tok.AttrVal("type")
+			if strings.EqualFold(strings.TrimSpace(typ), "importmap") {
+				script = "importmap"
+			} else if isScriptType(typ) {
+				script = "script"
+			}
+		}
 
-	matches := ScriptRE.FindAllStringSubmatch(html, -1)
-	for _, match := range matches {
-		if len(match) == 7 {
-			if parsedUrl, err := getFullURL(parent, match[4]); err == nil {
-				urls = append(urls, parsedUrl)
-			} else {
-				log.Println(err)
+		attrs, rel := urlAttributes[tok.Data], ""
+		switch tok.Data {
+		case "input":
+			if typ, _ := tok.AttrVal("type"); !strings.EqualFold(strings.TrimSpace(typ), "image") {
+				attrs = nil
+			}
+		case "link":
+			var kind ReferenceKind
+			kind, rel = classifyLink(tok)
+			attrs = map[string]ReferenceKind{"href": kind}
+		case "meta":
+			if r, ok := classifyMeta(tok); ok {
+				attrs, rel = map[string]ReferenceKind{"content": AssetReference}, r
+			}
+		}
+
+		for _, attr := range tok.Attr {
+			if attr.Key == "style" {
+				refs = append(refs, embeddedCSSReferences(html[attr.ValStart:attr.ValEnd], attr.ValStart, tok.Data, "style", true)...)
+				continue
+			}
+
+			kind, ok := attrs[attr.Key]
+			if !ok || attr.Val == "" {
+				continue
+			}
+
+			if attr.Key == "srcset" {
+				sets++
+				refs = append(refs, srcsetReferences(html[attr.ValStart:attr.ValEnd], attr.ValStart, tok.Data, sets)...)
+				continue
+			}
+
+			refs = append(refs, Reference{
+				Kind:  kind,
+				Tag:   tok.Data,
+				Attr:  attr.Key,
+				Rel:   rel,
+				Raw:   attr.Val,
+				Start: attr.ValStart,
+				End:   attr.ValEnd,
+			})
+
+			// Only the first <base> in a document is used, and it applies to the whole document
+			if kind == BaseReference && !hasBase {
+				if u, err := getFullURL(parent, attr.Val); err == nil {
+					base = u
+				}
+				hasBase = true
 			}
 		}
 	}
 
-	return urls
+	for i := range refs {
+		if refs[i].resolved {
+			continue
+		}
+
+		resolveAgainst := base
+		if refs[i].Kind == BaseReference {
+			resolveAgainst = parent
+		}
+
+		if u, err := getFullURL(resolveAgainst, refs[i].Raw); err == nil {
+			refs[i].URL = u
+		}
+	}
+
+	return refs, imports
 }
 
-func GetImageURLs(parent string, html string) []string {
+// importMapReferences returns the addresses of an import map as written, without resolving them
+func importMapReferences(data []byte, offset int) []Reference {
+	refs := []Reference{}
+
+	walkJSONStrings(data, func(keys []string, value string, start, end int) {
+		isImports := len(keys) == 2 && keys[0] == "imports"
+		isScoped := len(keys) == 3 && keys[0] == "scopes"
+		if (!isImports && !isScoped) || value == "" {
+			return
+		}
+
+		kind := AssetReference
+		if strings.HasSuffix(keys[len(keys)-1], "/") {
+			kind = MetadataReference
+		}
+		refs = append(refs, Reference{Kind: kind, Tag: "script", Attr: "importmap", Raw: value, Start: offset + start, End: offset + end})
+	})
+
+	return refs
+}
+
+// getTagURLs returns the resolved URLs of all references found in the given element
+func getTagURLs(parent string, html string, tag string) []string {
 	urls := []string{}
 
-	matches := ImageRE.FindAllStringSubmatch(html, -1)
-	for _, match := range matches {
-		if len(match) == 6 {
-			if parsedUrl, err := getFullURL(parent, match[3]); err == nil {
-				urls = append(urls, parsedUrl)
-			} else {
-				log.Println(err)
-			}
+	for _, ref := range ExtractReferences(parent, []byte(html)) {
+		if ref.Tag != tag || ref.embedded() {
+			continue
+		}
+
+		if ref.URL != "" {
+			urls = append(urls, ref.URL)
+		} else {
+			log.Println("Could not parse " + ref.Raw + " as URL")
 		}
 	}
 
 	return urls
 }
+
+func GetLinkURLs(parent string, html string) []string {
+	return getTagURLs(parent, html, "link")
+}
+
+func GetScriptURLs(parent string, html string) []string {
+	return getTagURLs(parent, html, "script")
+}
+
+func GetImageURLs(parent string, html string) []string {
+	return getTagURLs(parent, html, "img")
+}
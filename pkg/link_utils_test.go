@@ -15,7 +15,16 @@ func TestGetImageURLs(t *testing.T) {
 		args args
 		want []string
 	}{
-		// TODO: Add test cases.
+		{
+			name: "Finds images regardless of case and quoting",
+			args: args{"https://www.test.com/", `<IMG SRC='/a.png'><img alt="x" src=b.png>`},
+			want: []string{"https://www.test.com/a.png", "https://www.test.com/b.png"},
+		},
+		{
+			name: "Returns no URLs for a page without images",
+			args: args{"https://www.test.com/", `<p>text</p>`},
+			want: []string{},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -36,7 +45,16 @@ func TestGetLinkURLs(t *testing.T) {
 		args args
 		want []string
 	}{
-		// TODO: Add test cases.
+		{
+			name: "Finds link tags which are not self-closing",
+			args: args{"https://www.test.com/", `<link rel="stylesheet" href="/style.css"><link href="https://cdn.test.com/x.css"/>`},
+			want: []string{"https://www.test.com/style.css", "https://cdn.test.com/x.css"},
+		},
+		{
+			name: "Ignores links inside comments",
+			args: args{"https://www.test.com/", `<!-- <link href="/old.css"> -->`},
+			want: []string{},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -57,7 +75,11 @@ func TestGetScriptURLs(t *testing.T) {
 		args args
 		want []string
 	}{
-		// TODO: Add test cases.
+		{
+			name: "Finds scripts with attributes in any order",
+			args: args{"https://www.test.com/", `<SCRIPT defer src="/app.js" type="module"></SCRIPT><script>var s = '<script src="/no.js">'</script>`},
+			want: []string{"https://www.test.com/app.js"},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -79,7 +101,21 @@ func Test_getFullURL(t *testing.T) {
 		want    string
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{"Absolute URL", args{"https://a.com/x/y.html", "https://b.com/z.js"}, "https://b.com/z.js", false},
+		{"Root-relative path", args{"https://a.com/x/y.html", "/img.png"}, "https://a.com/img.png", false},
+		{"Document-relative path", args{"https://a.com/x/y.html", "img.png"}, "https://a.com/x/img.png", false},
+		{"Dot segments", args{"https://a.com/x/y/z.html", "../img.png"}, "https://a.com/x/img.png", false},
+		{"Port is kept", args{"http://a.com:8080/x/", "img.png"}, "http://a.com:8080/x/img.png", false},
+		{"Query-only reference", args{"https://a.com/list?page=1", "?page=2"}, "https://a.com/list?page=2", false},
+		{"Fragment is kept", args{"https://a.com/x/", "y.html#top"}, "https://a.com/x/y.html#top", false},
+		{"Scheme-relative reference", args{"https://a.com/", "//cdn.b.com/x.js"}, "https://cdn.b.com/x.js", false},
+		{"Surrounding whitespace and newlines", args{"https://a.com/", " /a\n/b.png "}, "https://a.com/a/b.png", false},
+		{"JavaScript links are rejected", args{"https://a.com/", "JavaScript:void(0)"}, "", true},
+		{"Mailto links are rejected", args{"https://a.com/", "mailto:me@a.com"}, "", true},
+		{"Data URLs are rejected", args{"https://a.com/", "data:image/png;base64,AAAA"}, "", true},
+		{"Tel links are rejected", args{"https://a.com/", "tel:+15555555555"}, "", true},
+		{"Other schemes are rejected", args{"https://a.com/", "ftp://a.com/file"}, "", true},
+		{"Parent must be an absolute URL", args{"/relative", "img.png"}, "", true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -94,3 +130,145 @@ func Test_getFullURL(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractReferences(t *testing.T) {
+	html := `<a href="/about">About</a><img src="/logo.png"><script src=app.js></script>`
+
+	got := ExtractReferences("https://www.test.com/", []byte(html))
+	want := []Reference{
+		{Kind: PageReference, Tag: "a", Attr: "href", Raw: "/about", URL: "https://www.test.com/about", Start: 9, End: 15},
+		{Kind: AssetReference, Tag: "img", Attr: "src", Raw: "/logo.png", URL: "https://www.test.com/logo.png", Start: 36, End: 45},
+		{Kind: AssetReference, Tag: "script", Attr: "src", Raw: "app.js", URL: "https://www.test.com/app.js", Start: 59, End: 65},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractReferences() = %+v, want %+v", got, want)
+	}
+
+	for _, ref := range got {
+		if html[ref.Start:ref.End] != ref.Raw {
+			t.Errorf("offsets of %v cover %q", ref.Raw, html[ref.Start:ref.End])
+		}
+	}
+}
+
+func TestExtractReferences_base(t *testing.T) {
+	html := `<head><base href="/static/"></head><img src="logo.png"><a href="https://b.com/">`
+
+	got := []string{}
+	for _, ref := range ExtractReferences("https://www.test.com/blog/post.html", []byte(html)) {
+		got = append(got, ref.URL)
+	}
+
+	want := []string{"https://www.test.com/static/", "https://www.test.com/static/logo.png", "https://b.com/"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractReferences() = %v, want %v", got, want)
+	}
+}
+
+func TestExtractReferences_styles(t *testing.T) {
+	html := `<style>@import "a.css"; body { background: url(bg.png) }</style>` +
+		`<div style="background: url(&quot;/img/x.png&quot;)"></div><p style="background: url('y.png')">`
+
+	got := ExtractReferences("https://www.test.com/blog/", []byte(html))
+	want := []struct {
+		tag, attr, raw, url string
+	}{
+		{"style", "import", "a.css", "https://www.test.com/blog/a.css"},
+		{"style", "url", "bg.png", "https://www.test.com/blog/bg.png"},
+		{"div", "style", "/img/x.png", "https://www.test.com/img/x.png"},
+		{"p", "style", "y.png", "https://www.test.com/blog/y.png"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ExtractReferences() = %+v, want %v references", got, len(want))
+	}
+
+	for i, w := range want {
+		ref := got[i]
+		if ref.Kind != AssetReference || ref.Tag != w.tag || ref.Attr != w.attr || ref.Raw != w.raw || ref.URL != w.url {
+			t.Errorf("reference %v = %+v, want %v %v %v %v", i, ref, w.tag, w.attr, w.raw, w.url)
+		}
+	}
+
+	// Offsets cover the value as written, which includes the character references in the div's style
+	for i, covered := range []string{"a.css", "bg.png", "&quot;/img/x.png&quot;", "y.png"} {
+		if html[got[i].Start:got[i].End] != covered {
+			t.Errorf("offsets of %v cover %q, want %q", got[i].Raw, html[got[i].Start:got[i].End], covered)
+		}
+	}
+
+	// References in styles are not mistaken for the element's own
+	if urls := GetImageURLs("https://www.test.com/", `<img src="a.png" style="background: url(b.png)">`); !reflect.DeepEqual(urls, []string{"https://www.test.com/a.png"}) {
+		t.Errorf("GetImageURLs() = %v", urls)
+	}
+}
+
+func TestExtractReferences_media(t *testing.T) {
+	html := `<picture><source srcset="a.webp 1x, a@2x.webp 2x" type="image/webp"><img src="a.jpg"></picture>` +
+		`<video src="v.mp4" poster="poster.jpg"><source src="v.webm"><track src="subs.vtt"></video>` +
+		`<audio src="a.mp3"></audio><object data="movie.swf"></object><embed src="e.swf">` +
+		`<input type="IMAGE" src="button.png"><input type="text" src="ignored.png">`
+
+	got := ExtractReferences("https://www.test.com/", []byte(html))
+
+	want := []string{
+		"source srcset a.webp", "source srcset a@2x.webp", "img src a.jpg", "video src v.mp4", "video poster poster.jpg",
+		"source src v.webm", "track src subs.vtt", "audio src a.mp3", "object data movie.swf", "embed src e.swf",
+		"input src button.png",
+	}
+	found := []string{}
+	for _, ref := range got {
+		if ref.Kind != AssetReference || ref.URL != "https://www.test.com/"+ref.Raw {
+			t.Errorf("reference %+v is not a resolved asset", ref)
+		}
+		if html[ref.Start:ref.End] != ref.Raw {
+			t.Errorf("offsets of %v cover %q", ref.Raw, html[ref.Start:ref.End])
+		}
+		found = append(found, ref.Tag+" "+ref.Attr+" "+ref.Raw)
+	}
+
+	if !reflect.DeepEqual(found, want) {
+		t.Errorf("ExtractReferences() = %q, want %q", found, want)
+	}
+}
+
+func TestExtractReferences_rels(t *testing.T) {
+	tests := []struct {
+		name     string
+		html     string
+		wantKind ReferenceKind
+		wantRel  string
+	}{
+		{"Stylesheet", `<link rel="stylesheet" href="a">`, AssetReference, RelStylesheet},
+		{"Alternate stylesheet", `<link rel="alternate stylesheet" href="a">`, AssetReference, RelStylesheet},
+		{"Shortcut icon", `<link rel="Shortcut Icon" href="a">`, AssetReference, RelIcon},
+		{"Apple touch icon", `<link rel="apple-touch-icon" href="a">`, AssetReference, RelIcon},
+		{"Manifest", `<link rel="manifest" href="a">`, AssetReference, RelManifest},
+		{"Preload", `<link rel="preload" as="font" href="a">`, AssetReference, RelPreload},
+		{"Module preload", `<link rel="modulepreload" href="a">`, AssetReference, RelPreload},
+		{"Prefetch", `<link rel="prefetch" href="a">`, AssetReference, RelPreload},
+		{"Alternate", `<link rel="alternate" type="application/rss+xml" href="a">`, PageReference, RelAlternate},
+		{"Canonical", `<link rel="canonical" href="a">`, MetadataReference, RelCanonical},
+		{"Preconnect", `<link rel="preconnect" href="a">`, MetadataReference, ""},
+		{"Unknown rel", `<link rel="author" href="a">`, AssetReference, ""},
+		{"Open Graph image", `<meta property="og:image" content="a">`, AssetReference, RelImage},
+		{"Twitter image", `<meta name="twitter:image" content="a">`, AssetReference, RelImage},
+		{"Tile image", `<meta name="msapplication-TileImage" content="a">`, AssetReference, RelIcon},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs := ExtractReferences("https://www.test.com/", []byte(tt.html))
+			if len(refs) != 1 {
+				t.Fatalf("ExtractReferences() = %+v, want one reference", refs)
+			}
+			if refs[0].Kind != tt.wantKind || refs[0].Rel != tt.wantRel || refs[0].URL != "https://www.test.com/a" {
+				t.Errorf("ExtractReferences() = %+v, want kind %v rel %q", refs[0], tt.wantKind, tt.wantRel)
+			}
+		})
+	}
+
+	// Other <meta> elements have no references
+	if refs := ExtractReferences("https://www.test.com/", []byte(`<meta name="description" content="a">`)); len(refs) != 0 {
+		t.Errorf("ExtractReferences() = %+v, want none", refs)
+	}
+}
@@ -0,0 +1,139 @@
+package anubis
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ManifestFile is where the manifest is stored within the output directory, so that the archive can be
+// replayed with the original content types and headers
+const ManifestFile = ".anubis/manifest.json"
+
+// replayHeaders are the response headers stored in the manifest. Headers which only make sense for the
+// live site, such as cookies and caching directives, are dropped.
+var replayHeaders = []string{"Content-Disposition", "Content-Language", "ETag", "Last-Modified"}
+
+// storedHeader returns the subset of the response headers which are kept in the manifest
+func storedHeader(header http.Header) http.Header {
+	stored := http.Header{}
+	for _, k := range replayHeaders {
+		if v := header.Values(k); len(v) > 0 {
+			stored[k] = v
+		}
+	}
+
+	if len(stored) == 0 {
+		return nil
+	}
+	return stored
+}
+
+// ParseManifest parses a stored manifest
+func ParseManifest(data []byte) (*Manifest, error) {
+	entries := []ManifestEntry{}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+
+	m := NewManifest()
+	for _, entry := range entries {
+		m.Record(entry)
+	}
+	return m, nil
+}
+
+// LoadManifest reads the manifest stored in an output directory. A directory without a manifest has an
+// empty one.
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(ManifestFile)))
+	if os.IsNotExist(err) {
+		return NewManifest(), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return ParseManifest(data)
+}
+
+// storedManifest is the manifest left in Output by earlier runs, loaded the first time it is needed
+type storedManifest struct {
+	once     sync.Once
+	manifest *Manifest
+}
+
+// previousEntry returns the entry recorded for the URL during this run, or else by an earlier run in the
+// manifest stored in Output. Redirects are not followed.
+func (a *Anubis) previousEntry(u string) (ManifestEntry, bool) {
+	if entry, ok := a.Manifest.Get(u); ok {
+		return entry, true
+	}
+	if stored := a.storedManifest(); stored != nil {
+		return stored.Get(u)
+	}
+	return ManifestEntry{}, false
+}
+
+// lookupEntry is like previousEntry, but follows redirects and canonical aliases as Manifest.Lookup does
+func (a *Anubis) lookupEntry(u string) (ManifestEntry, bool) {
+	if entry, ok := a.Manifest.Lookup(u); ok {
+		return entry, true
+	}
+	if stored := a.storedManifest(); stored != nil {
+		return stored.Lookup(u)
+	}
+	return ManifestEntry{}, false
+}
+
+// storedManifest returns the manifest stored in Output, or nil if the instance does not track one
+func (a *Anubis) storedManifest() *Manifest {
+	if a.stored == nil {
+		return nil
+	}
+
+	a.stored.once.Do(func() {
+		m, err := LoadManifest(a.Output)
+		if err != nil {
+			m = NewManifest()
+		}
+		a.stored.manifest = m
+	})
+	return a.stored.manifest
+}
+
+// forgetEntry removes the URL from this run's manifest and from the stored one, once its file is gone
+func (a *Anubis) forgetEntry(u string) {
+	a.Manifest.Remove(u)
+	if a.stored != nil && a.stored.manifest != nil {
+		a.stored.manifest.Remove(u)
+	}
+}
+
+// SaveManifest merges the entries recorded during this run into the manifest stored in Output. Entries
+// from earlier runs are kept as long as their file still exists, since the files are left in place.
+func (a *Anubis) SaveManifest() error {
+	stored, err := LoadManifest(a.Output)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range a.Manifest.Entries() {
+		stored.Record(entry)
+	}
+
+	entries := []ManifestEntry{}
+	for _, entry := range stored.Entries() {
+		if _, err := os.Stat(a.outputPath(entry.Path)); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return a.writeOutput(ManifestFile, append(data, '\n'))
+}
@@ -0,0 +1,160 @@
+package anubis
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// MediaKind classifies a file by how it is processed
+type MediaKind int
+
+const (
+	// MediaOther is any file which is stored without being parsed, or whose type is not known
+	MediaOther MediaKind = iota
+	// MediaHTML is an HTML or XHTML page, whose references are extracted and rewritten
+	MediaHTML
+	// MediaCSS is a stylesheet, whose url() functions and @import rules are extracted and rewritten
+	MediaCSS
+	// MediaJavaScript is a script, whose imports, workers and source maps are extracted and rewritten
+	MediaJavaScript
+	// MediaWebManifest is a web app manifest served as such. Manifests served as plain JSON are recognised
+	// by the pages linking to them.
+	MediaWebManifest
+	// MediaJSON is JSON data such as a source map, stored as is unless a page links to it as a manifest
+	MediaJSON
+	// MediaXML is an XML document other than XHTML, such as a feed or a sitemap, stored as is
+	MediaXML
+	// MediaText is plain text or another text format which is not parsed, such as Markdown
+	MediaText
+	// MediaImage is an image, including SVG, stored as is
+	MediaImage
+	// MediaAudio is an audio file, stored as is
+	MediaAudio
+	// MediaVideo is a video file, stored as is
+	MediaVideo
+	// MediaFont is a web font, stored as is
+	MediaFont
+)
+
+// MediaKindOf classifies a content type, such as the value of a Content-Type header
+func MediaKindOf(contentType string) MediaKind {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return MediaOther
+	}
+
+	switch {
+	case mediaType == "text/html", mediaType == "application/xhtml+xml":
+		return MediaHTML
+	case mediaType == "text/css":
+		return MediaCSS
+	case isJavaScriptType(mediaType):
+		return MediaJavaScript
+	case strings.HasSuffix(mediaType, "manifest+json"):
+		return MediaWebManifest
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return MediaJSON
+	case strings.HasPrefix(mediaType, "image/"):
+		return MediaImage
+	case strings.HasPrefix(mediaType, "audio/"):
+		return MediaAudio
+	case strings.HasPrefix(mediaType, "video/"):
+		return MediaVideo
+	case strings.HasPrefix(mediaType, "font/"), strings.HasPrefix(mediaType, "application/font-"),
+		strings.HasPrefix(mediaType, "application/x-font-"):
+		return MediaFont
+	case mediaType == "application/xml", mediaType == "text/xml", strings.HasSuffix(mediaType, "+xml"):
+		return MediaXML
+	case strings.HasPrefix(mediaType, "text/"):
+		return MediaText
+	}
+	return MediaOther
+}
+
+// Media is the classification of a response
+type Media struct {
+	Kind MediaKind
+
+	// ContentType is the response's Content-Type header if it is specific, or otherwise the type found
+	// by sniffing the body or from the URL's extension. It is empty if none of them tell.
+	ContentType string
+}
+
+// vagueMediaTypes are the content types servers send when they do not know better, which are checked
+// against the body and the URL
+var vagueMediaTypes = map[string]bool{
+	"text/plain":                         true,
+	"application/octet-stream":           true,
+	"binary/octet-stream":                true,
+	"application/unknown":                true,
+	"unknown/unknown":                    true,
+	"application/x-unknown-content-type": true,
+}
+
+// extensionTypes is preferred over the mime package for the types which decide how a file is parsed,
+// since the mime package's choice depends on the system's mime.types
+var extensionTypes = map[string]string{
+	".html":        "text/html",
+	".htm":         "text/html",
+	".xhtml":       "application/xhtml+xml",
+	".css":         "text/css",
+	".js":          "text/javascript",
+	".mjs":         "text/javascript",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".xml":         "application/xml",
+	".svg":         "image/svg+xml",
+	".txt":         "text/plain",
+}
+
+// ClassifyMedia decides the type of a response from its Content-Type header, its body and its URL. A
+// specific header is trusted, but a missing or vague one such as text/plain or application/octet-stream
+// is replaced by the type the body is sniffed as, following http.DetectContentType, or else by the type
+// of the URL's extension. Sniffing cannot tell stylesheets and scripts from plain text, which is why the
+// extension is consulted. A charset given by the header is kept.
+func ClassifyMedia(header string, u *url.URL, body []byte) Media {
+	mediaType, params, err := mime.ParseMediaType(header)
+	if err != nil {
+		mediaType = ""
+	}
+	if mediaType != "" && !vagueMediaTypes[mediaType] {
+		return Media{Kind: MediaKindOf(header), ContentType: header}
+	}
+
+	extType := ""
+	if u != nil {
+		ext := strings.ToLower(path.Ext(u.Path))
+		if extType = extensionTypes[ext]; extType == "" && isExtension(ext) {
+			extType, _, _ = mime.ParseMediaType(mime.TypeByExtension(ext))
+		}
+	}
+
+	sniffed := ""
+	if len(body) > 0 {
+		sniffed, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+	}
+
+	effective := ""
+	switch {
+	// XML is sniffed from the declaration alone, so the extension tells which kind of XML it is
+	case sniffed == "text/xml" && strings.HasSuffix(extType, "xml"):
+		effective = extType
+	case sniffed != "" && sniffed != "text/plain" && sniffed != "application/octet-stream":
+		effective = sniffed
+	case extType != "":
+		effective = extType
+	case mediaType != "":
+		effective = mediaType
+	default:
+		effective = sniffed
+	}
+
+	if charset := params["charset"]; charset != "" && effective != "" {
+		effective = mime.FormatMediaType(effective, map[string]string{"charset": charset})
+	}
+	return Media{Kind: MediaKindOf(effective), ContentType: effective}
+}
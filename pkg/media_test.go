@@ -0,0 +1,91 @@
+package anubis
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestMediaKindOf(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        MediaKind
+	}{
+		{"text/html; charset=utf-8", MediaHTML},
+		{"Application/XHTML+XML", MediaHTML},
+		{"text/css", MediaCSS},
+		{"application/x-javascript", MediaJavaScript},
+		{"text/ecmascript", MediaJavaScript},
+		{"application/manifest+json", MediaWebManifest},
+		{"application/ld+json", MediaJSON},
+		{"application/rss+xml", MediaXML},
+		{"image/svg+xml", MediaImage},
+		{"audio/ogg", MediaAudio},
+		{"video/webm", MediaVideo},
+		{"font/woff2", MediaFont},
+		{"application/x-font-ttf", MediaFont},
+		{"text/plain", MediaText},
+		{"application/pdf", MediaOther},
+		{"", MediaOther},
+		{"not a type", MediaOther},
+	}
+	for _, tt := range tests {
+		if got := MediaKindOf(tt.contentType); got != tt.want {
+			t.Errorf("MediaKindOf(%q) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyMedia(t *testing.T) {
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+	tests := []struct {
+		name   string
+		header string
+		url    string
+		body   string
+		want   Media
+	}{
+		{"Specific header", "text/html; charset=iso-8859-1", "https://www.test.com/data.json", `{"a": 1}`, Media{MediaHTML, "text/html; charset=iso-8859-1"}},
+		{"HTML as plain text", "text/plain; charset=utf-8", "https://www.test.com/page", "<!DOCTYPE html><p>Hi", Media{MediaHTML, "text/html; charset=utf-8"}},
+		{"Missing header", "", "https://www.test.com/page", "<html><body>", Media{MediaHTML, "text/html"}},
+		{"Image as octet stream", "application/octet-stream", "https://www.test.com/logo", png, Media{MediaImage, "image/png"}},
+		{"Stylesheet as plain text", "text/plain", "https://www.test.com/a.CSS", "body { color: red }", Media{MediaCSS, "text/css"}},
+		{"Module as octet stream", "application/octet-stream", "https://www.test.com/app.mjs", `import "./a.js"`, Media{MediaJavaScript, "text/javascript"}},
+		{"XHTML by extension", "", "https://www.test.com/page.xhtml", `<?xml version="1.0"?><html xmlns="http://www.w3.org/1999/xhtml">`, Media{MediaHTML, "application/xhtml+xml"}},
+		{"XML without extension", "", "https://www.test.com/feed", `<?xml version="1.0"?><rss>`, Media{MediaXML, "text/xml"}},
+		{"Plain text", "text/plain", "https://www.test.com/notes", "Just text", Media{MediaText, "text/plain"}},
+		{"Plain text by sniffing", "", "https://www.test.com/notes", "Just text", Media{MediaText, "text/plain"}},
+		{"Empty response", "", "https://www.test.com/empty", "", Media{MediaOther, ""}},
+		{"Version number", "", "https://www.test.com/v1.2", "", Media{MediaOther, ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := ClassifyMedia(tt.header, u, []byte(tt.body)); got != tt.want {
+				t.Errorf("ClassifyMedia() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultResponseHandler_Handle_sniffed(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+
+	req, resp := newTestResponse(t, "https://www.test.com/about", 200, "text/plain", `<html><img src="/logo.png"></html>`)
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := a.Pending(); got != 1 || !a.Filter.TestURL("https://www.test.com/logo.png") {
+		t.Errorf("Handle() queued %v URLs, want the page's image", got)
+	}
+
+	// The page is stored and replayed as HTML
+	entry, ok := a.Manifest.Get("https://www.test.com/about")
+	if !ok || entry.Path != "www.test.com/about.html" || entry.ContentType != "text/html" {
+		t.Errorf("Manifest entry = %+v, %v", entry, ok)
+	}
+}
@@ -48,20 +48,18 @@ func (opt ProxyOpt) SetOpt(anubis *Anubis) {
 	proxy = http.ProxyURL(u)
 
 	driver := DefaultWebDriver{
-		client: http.Client{
-			Transport: &http.Transport{
-				Proxy: proxy,
-				DialContext: (&net.Dialer{
-					Timeout:   30 * time.Second,
-					KeepAlive: 30 * time.Second,
-				}).DialContext,
-				ForceAttemptHTTP2:     true,
-				MaxIdleConns:          100,
-				IdleConnTimeout:       90 * time.Second,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ExpectContinueTimeout: 1 * time.Second,
-			},
-		},
+		client: newHTTPClient(&http.Transport{
+			Proxy: proxy,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}),
 	}
 
 	anubis.Driver = driver
@@ -78,3 +76,143 @@ type ResponseHandlerOpt struct {
 }
 
 func (opt ResponseHandlerOpt) SetOpt(anubis *Anubis) { anubis.Handler = opt.Handler }
+
+// FormatOpt selects the output formats written for each response
+type FormatOpt OutputFormat
+
+func (opt FormatOpt) SetOpt(anubis *Anubis) { anubis.Format = OutputFormat(opt) }
+
+// WARCOpt sets the writer which receives a record for each response, and adds WARCFormat to the output
+// formats
+type WARCOpt struct {
+	Writer *WARCWriter
+}
+
+func (opt WARCOpt) SetOpt(anubis *Anubis) {
+	anubis.WARC = opt.Writer
+	anubis.Format |= WARCFormat
+}
+
+// PathMapperOpt sets how responses are mapped to files within the output directory
+type PathMapperOpt struct {
+	Mapper PathMapper
+}
+
+func (opt PathMapperOpt) SetOpt(anubis *Anubis) { anubis.Mapper = opt.Mapper }
+
+// RewriteOpt controls whether references in archived pages are rewritten to point at the local copies
+// once the instance has finished. Rewriting is enabled by default.
+type RewriteOpt bool
+
+func (opt RewriteOpt) SetOpt(anubis *Anubis) { anubis.RewriteLinks = bool(opt) }
+
+// PlaceholderOpt sets the URL which replaces references to files that were not archived when links are
+// rewritten. If it is empty, those references are left untouched.
+type PlaceholderOpt string
+
+func (opt PlaceholderOpt) SetOpt(anubis *Anubis) { anubis.Placeholder = string(opt) }
+
+// SrcsetOpt sets which candidates of each srcset attribute are fetched. Every candidate is fetched by
+// default.
+type SrcsetOpt SrcsetPolicy
+
+func (opt SrcsetOpt) SetOpt(anubis *Anubis) { anubis.Srcset = SrcsetPolicy(opt) }
+
+// CrawlOpt enables crawling, so that pages linked from the start URLs are archived as well as the assets
+// they need. A MaxDepth or MaxPages of zero means there is no limit.
+type CrawlOpt struct {
+	MaxDepth, MaxPages int
+}
+
+func (opt CrawlOpt) SetOpt(anubis *Anubis) {
+	anubis.Crawl = true
+	anubis.MaxDepth = opt.MaxDepth
+	anubis.MaxPages = opt.MaxPages
+}
+
+// RobotsOpt enables or disables honouring each host's robots.txt. When enabled, URLs disallowed for the
+// User-Agent in the instance's Headers are skipped, and each host's Crawl-delay is respected.
+type RobotsOpt bool
+
+func (opt RobotsOpt) SetOpt(anubis *Anubis) {
+	if opt {
+		anubis.Robots = NewRobotsPolicy(anubis)
+	} else {
+		anubis.Robots = nil
+	}
+}
+
+// SitemapOpt adds a sitemap or sitemap index to read when the instance starts. Use DiscoverSitemaps to
+// read the sitemaps declared in the robots.txt of each start URL's host instead.
+type SitemapOpt string
+
+func (opt SitemapOpt) SetOpt(anubis *Anubis) { anubis.Sitemaps = append(anubis.Sitemaps, string(opt)) }
+
+// SitemapSinceOpt skips pages in sitemaps whose lastmod is before the given time. Combined with
+// LastCommitTime, this only fetches pages which changed since the output was last committed.
+type SitemapSinceOpt time.Time
+
+func (opt SitemapSinceOpt) SetOpt(anubis *Anubis) { anubis.SitemapSince = time.Time(opt) }
+
+// hostLimiter returns the instance's HostLimiter, creating it if needed
+func hostLimiter(anubis *Anubis) *HostLimiter {
+	if anubis.Limiter == nil {
+		anubis.Limiter = NewHostLimiter()
+	}
+	return anubis.Limiter
+}
+
+// RateLimitOpt limits the number of requests per second made to each host. Burst requests may be made at
+// once before the limit applies; a Burst below one is treated as one.
+type RateLimitOpt struct {
+	Rate  float64
+	Burst int
+}
+
+func (opt RateLimitOpt) SetOpt(anubis *Anubis) {
+	limiter := hostLimiter(anubis)
+	limiter.Default.Rate = opt.Rate
+	limiter.Default.Burst = opt.Burst
+}
+
+// PerHostOpt limits the number of requests in flight to each host, regardless of the number of workers
+type PerHostOpt int
+
+func (opt PerHostOpt) SetOpt(anubis *Anubis) { hostLimiter(anubis).Default.Concurrency = int(opt) }
+
+// HostLimitOpt overrides the default limits for a single host, such as a CDN which can handle far more
+// requests than the origin server
+type HostLimitOpt struct {
+	Host  string
+	Limit HostLimit
+}
+
+func (opt HostLimitOpt) SetOpt(anubis *Anubis) { hostLimiter(anubis).Hosts[opt.Host] = opt.Limit }
+
+// AuthorOpt sets the name and email address used to author and commit each snapshot
+type AuthorOpt struct {
+	Name, Email string
+}
+
+func (opt AuthorOpt) SetOpt(anubis *Anubis) {
+	anubis.AuthorName = opt.Name
+	anubis.AuthorEmail = opt.Email
+}
+
+// CommitterOpt sets how Commit records the output directory, such as the LinkCommitter or TarCommitter
+// instead of git. The NativeCommitter is used by default.
+type CommitterOpt struct {
+	Committer Committer
+}
+
+func (opt CommitterOpt) SetOpt(anubis *Anubis) { anubis.Committer = opt.Committer }
+
+// RetryOpt sets the policy used to retry failed requests. Use a MaxAttempts of one to disable retries.
+type RetryOpt RetryPolicy
+
+func (opt RetryOpt) SetOpt(anubis *Anubis) { anubis.Retry = RetryPolicy(opt) }
+
+// StatusPolicyOpt sets what the DefaultResponseHandler does with responses based on their status code
+type StatusPolicyOpt StatusPolicy
+
+func (opt StatusPolicyOpt) SetOpt(anubis *Anubis) { anubis.StatusPolicy = StatusPolicy(opt) }
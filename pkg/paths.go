@@ -0,0 +1,229 @@
+package anubis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// PathMapper decides where the response for a URL is written, as a slash-separated path relative to the
+// output directory. The content type is the response's type as classified by ClassifyMedia, which may be
+// empty if it is not known.
+type PathMapper interface {
+	MapPath(u *url.URL, contentType string) string
+}
+
+// maxComponentLength leaves room below the 255 byte limit on file names of most file systems
+const maxComponentLength = 200
+
+// DefaultPathMapper maps each URL to a file below a directory named after its host:
+//
+//   - Paths ending in a slash are stored as index.html within the directory, and paths without an
+//     extension are given one based on the content type, so /about and /about/ do not collide
+//   - Query strings are sorted by key and appended to the file name, so each query has its own file
+//   - Dot segments are resolved, and characters which are not safe in file names are percent-encoded,
+//     so the result never leaves the output directory
+//   - Components longer than MaxComponentLength are truncated and made unique with a hash
+type DefaultPathMapper struct {
+	MaxComponentLength int // MaxComponentLength caps each component of the path. Zero uses a default of 200.
+}
+
+func (mapper DefaultPathMapper) MapPath(u *url.URL, contentType string) string {
+	limit := mapper.MaxComponentLength
+	if limit <= 0 {
+		limit = maxComponentLength
+	}
+
+	components := []string{capComponent(sanitizeComponent(strings.ToLower(u.Host)), "", limit)}
+
+	// Cleaning the escaped path resolves literal dot segments without touching encoded slashes
+	escaped := u.EscapedPath()
+	segments := strings.Split(strings.TrimPrefix(path.Clean("/"+escaped), "/"), "/")
+
+	name := "index"
+	if escaped != "" && !strings.HasSuffix(escaped, "/") {
+		name, segments = unescapeSegment(segments[len(segments)-1]), segments[:len(segments)-1]
+	}
+
+	for _, segment := range segments {
+		if segment != "" {
+			components = append(components, capComponent(sanitizeComponent(unescapeSegment(segment)), "", limit))
+		}
+	}
+
+	base, ext := fileExtension(name, contentType)
+	base = sanitizeComponent(base)
+	if u.RawQuery != "" {
+		base += "%3F" + sanitizeComponent(canonicalQuery(u.RawQuery))
+	}
+
+	return path.Join(append(components, capComponent(base, ext, limit))...)
+}
+
+func unescapeSegment(segment string) string {
+	if s, err := url.PathUnescape(segment); err == nil {
+		return s
+	}
+	return segment
+}
+
+// canonicalQuery sorts the query by key, keeping the order of repeated keys, so that equivalent query
+// strings map to the same file
+func canonicalQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	return values.Encode()
+}
+
+// commonExtensions is preferred over the mime package, whose choice depends on the system's mime.types
+var commonExtensions = map[string]string{
+	"text/html":                ".html",
+	"application/xhtml+xml":    ".html",
+	"text/css":                 ".css",
+	"text/javascript":          ".js",
+	"application/javascript":   ".js",
+	"application/json":         ".json",
+	"application/xml":          ".xml",
+	"text/xml":                 ".xml",
+	"text/plain":               ".txt",
+	"image/png":                ".png",
+	"image/jpeg":               ".jpg",
+	"image/gif":                ".gif",
+	"image/svg+xml":            ".svg",
+	"image/webp":               ".webp",
+	"image/x-icon":             ".ico",
+	"image/vnd.microsoft.icon": ".ico",
+	"application/pdf":          ".pdf",
+	"font/woff":                ".woff",
+	"font/woff2":               ".woff2",
+	"video/mp4":                ".mp4",
+	"audio/mpeg":               ".mp3",
+}
+
+// fileExtension splits the file name into a base and an extension, adding an extension for the content
+// type if the name does not have one. HTML is always given an HTML extension, so that pages served from
+// names such as index.php open in a browser. Names without an extension and without a content type are
+// assumed to be pages.
+func fileExtension(name string, contentType string) (string, string) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = ""
+	}
+
+	want := commonExtensions[mediaType]
+	if want == "" && mediaType != "" {
+		if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+			want = exts[0]
+		} else {
+			want = ".bin"
+		}
+	}
+
+	ext := path.Ext(name)
+	if !isExtension(ext) {
+		ext = ""
+	}
+
+	switch {
+	case ext == "" && want == "":
+		return name, ".html"
+	case ext == "":
+		return name, want
+	case want == ".html" && ext != ".html" && ext != ".htm":
+		return name, want
+	}
+
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// isExtension reports whether the suffix looks like a file extension rather than part of a version
+// number such as v1.2
+func isExtension(ext string) bool {
+	if len(ext) < 2 || len(ext) > 6 {
+		return false
+	}
+
+	letters := false
+	for i := 1; i < len(ext); i++ {
+		c := ext[i]
+		switch {
+		case isASCIILetter(c):
+			letters = true
+		case c >= '0' && c <= '9':
+		default:
+			return false
+		}
+	}
+	return letters
+}
+
+// sanitizeComponent percent-encodes every character which is unsafe in a file name on common file
+// systems, including '%' itself so that the encoding cannot collide with the original name. Valid
+// non-ASCII characters are kept.
+func sanitizeComponent(s string) string {
+	if s == "." || s == ".." {
+		return strings.Repeat("%2E", len(s))
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size <= 1:
+			b.WriteString(percentEncode(s[i]))
+		case r >= utf8.RuneSelf || isSafeFileChar(byte(r)):
+			b.WriteString(s[i : i+size])
+		default:
+			b.WriteString(percentEncode(s[i]))
+		}
+		i += size
+	}
+
+	// Windows drops trailing dots and spaces from file names
+	out := b.String()
+	if strings.HasSuffix(out, ".") {
+		out = out[:len(out)-1] + "%2E"
+	}
+	return out
+}
+
+func isSafeFileChar(c byte) bool {
+	return isASCIILetter(c) || (c >= '0' && c <= '9') || strings.IndexByte("-._~!$&'()+,;=@[]", c) >= 0
+}
+
+func percentEncode(c byte) string {
+	const hexDigits = "0123456789ABCDEF"
+	return string([]byte{'%', hexDigits[c>>4], hexDigits[c&15]})
+}
+
+// capComponent joins the base and extension, truncating the base if the result is longer than limit. A
+// hash of the full name is appended to truncated names so that names sharing a prefix stay distinct.
+func capComponent(base string, ext string, limit int) string {
+	if len(base)+len(ext) <= limit {
+		return base + ext
+	}
+
+	sum := sha256.Sum256([]byte(base + ext))
+	suffix := "-" + hex.EncodeToString(sum[:8]) + ext
+
+	keep := limit - len(suffix)
+	if keep < 0 {
+		keep = 0
+	}
+
+	// Avoid splitting a UTF-8 sequence or a percent-encoded byte
+	for keep > 0 && !utf8.RuneStart(base[keep]) {
+		keep--
+	}
+	if i := strings.LastIndexByte(base[:keep], '%'); i >= 0 && i >= keep-2 {
+		keep = i
+	}
+
+	return base[:keep] + suffix
+}
@@ -0,0 +1,67 @@
+package anubis
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDefaultPathMapper_MapPath(t *testing.T) {
+	long := strings.Repeat("a", 300)
+
+	tests := []struct {
+		name        string
+		url         string
+		contentType string
+		want        string
+	}{
+		{"Root path", "https://www.test.com", "", "www.test.com/index.html"},
+		{"Directory path", "https://www.test.com/blog/", "text/html", "www.test.com/blog/index.html"},
+		{"File path", "https://www.test.com/css/style.css", "text/css", "www.test.com/css/style.css"},
+		{"Pages without a trailing slash do not collide with directories", "https://www.test.com/about", "text/html; charset=utf-8", "www.test.com/about.html"},
+		{"Extensions come from the content type", "https://www.test.com/api/data", "application/json", "www.test.com/api/data.json"},
+		{"Unknown content types are binary", "https://www.test.com/download", "application/x-unknown-type", "www.test.com/download.bin"},
+		{"HTML is always given an HTML extension", "https://www.test.com/page.php", "text/html", "www.test.com/page.php.html"},
+		{"Version numbers are not extensions", "https://www.test.com/v1.2", "text/plain", "www.test.com/v1.2.txt"},
+		{"Query strings are sorted", "https://www.test.com/list?page=2&sort=asc", "text/html", "www.test.com/list%3Fpage=2&sort=asc.html"},
+		{"Equivalent query strings share a file", "https://www.test.com/list?sort=asc&page=2", "text/html", "www.test.com/list%3Fpage=2&sort=asc.html"},
+		{"Queries keep the existing extension last", "https://www.test.com/style.css?v=2", "text/css", "www.test.com/style%3Fv=2.css"},
+		{"Dot segments are resolved", "https://www.test.com/a/../../b/./c.png", "image/png", "www.test.com/b/c.png"},
+		{"Encoded dot segments cannot escape", "https://www.test.com/%2e%2e/%2E%2E/c.png", "image/png", "www.test.com/%2E%2E/%2E%2E/c.png"},
+		{"Encoded slashes stay within a component", "https://www.test.com/a%2Fb.png", "image/png", "www.test.com/a%2Fb.png"},
+		{"Unsafe characters are encoded", "https://www.test.com/a:b%3Cc%3E%25.txt", "text/plain", "www.test.com/a%3Ab%3Cc%3E%25.txt"},
+		{"Non-ASCII characters are kept", "https://www.test.com/caf%C3%A9.html", "text/html", "www.test.com/café.html"},
+		{"Ports are kept", "http://www.test.com:8080/", "text/html", "www.test.com%3A8080/index.html"},
+		{"Long components are capped", "https://www.test.com/" + long + "/" + long + ".png", "image/png",
+			"www.test.com/" + strings.Repeat("a", 183) + "-9835fa6bf4e20a9b/" + strings.Repeat("a", 179) + "-7d6974ffb8cc1c63.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := (DefaultPathMapper{}).MapPath(u, tt.contentType); got != tt.want {
+				t.Errorf("MapPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_capComponent(t *testing.T) {
+	a := capComponent(strings.Repeat("x", 50)+"a", ".png", 39)
+	b := capComponent(strings.Repeat("x", 50)+"b", ".png", 39)
+
+	if len(a) != 39 || !strings.HasSuffix(a, ".png") {
+		t.Errorf("capComponent() = %v, want 39 bytes ending in .png", a)
+	}
+	if a == b {
+		t.Errorf("capComponent() = %v for different names", a)
+	}
+
+	// A percent-encoded byte is not split
+	if got := capComponent("xx"+strings.Repeat("%3A", 20), "", 39); !strings.HasPrefix(got, "xx"+strings.Repeat("%3A", 6)+"-") {
+		t.Errorf("capComponent() = %v, split an encoded byte", got)
+	}
+}
@@ -0,0 +1,224 @@
+package anubis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HostLimit controls how hard a single host may be hit
+type HostLimit struct {
+	Rate        float64 // Rate is the maximum number of requests per second, or zero for no limit
+	Burst       int     // Burst is the number of requests which may be made at once before Rate applies
+	Concurrency int     // Concurrency is the maximum number of requests in flight, or zero for no limit
+}
+
+// hostBucket is the state of a single host's token bucket and concurrency limit
+type hostBucket struct {
+	limit  HostLimit
+	tokens float64
+	last   time.Time
+	slots  chan struct{}
+}
+
+// HostLimiter enforces a rate limit and a concurrency limit per host. Each host has its own token bucket
+// which refills at the limit's Rate, and its own pool of Concurrency request slots.
+type HostLimiter struct {
+	Default HostLimit            // Default applies to every host without an entry in Hosts
+	Hosts   map[string]HostLimit // Hosts overrides the limit for specific hosts, including any port
+
+	mu      sync.Mutex
+	buckets map[string]*hostBucket
+}
+
+func NewHostLimiter() *HostLimiter {
+	return &HostLimiter{Hosts: make(map[string]HostLimit), buckets: make(map[string]*hostBucket)}
+}
+
+func (limiter *HostLimiter) bucket(host string) *hostBucket {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	b, ok := limiter.buckets[host]
+	if !ok {
+		limit, ok := limiter.Hosts[host]
+		if !ok {
+			limit = limiter.Default
+		}
+		if limit.Burst < 1 {
+			limit.Burst = 1
+		}
+
+		b = &hostBucket{limit: limit, tokens: float64(limit.Burst), last: time.Now()}
+		if limit.Concurrency > 0 {
+			b.slots = make(chan struct{}, limit.Concurrency)
+		}
+		limiter.buckets[host] = b
+	}
+
+	return b
+}
+
+// reserve takes a token from the host's bucket and returns how long the caller must wait before using it
+func (limiter *HostLimiter) reserve(b *hostBucket) time.Duration {
+	if b.limit.Rate <= 0 {
+		return 0
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+
+	// The token is taken even if it is not available yet, so later callers queue up behind this one
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.limit.Rate * float64(time.Second))
+}
+
+// Acquire blocks until a request to the host is allowed by both its concurrency and rate limits. The
+// returned function must be called once the request is finished to free its slot.
+func (limiter *HostLimiter) Acquire(ctx context.Context, host string) (release func(), err error) {
+	b := limiter.bucket(host)
+
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	release = func() {
+		if b.slots != nil {
+			<-b.slots
+		}
+	}
+
+	if err := sleepContext(ctx, limiter.reserve(b)); err != nil {
+		release()
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// sleepContext waits for the duration or until the context is done, whichever is first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limitedDriver passes requests to the wrapped driver once the host's limits allow it. The concurrency
+// slot is held until the response body is closed, since the body is still being downloaded while the
+// response handler reads it.
+type limitedDriver struct {
+	driver  WebDriver
+	limiter *HostLimiter
+}
+
+func (driver limitedDriver) DoRequest(req *http.Request) (*http.Response, error) {
+	release, err := driver.limiter.Acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := driver.driver.DoRequest(req)
+	if err != nil || resp == nil || resp.Body == nil {
+		release()
+		return resp, err
+	}
+
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	return resp, nil
+}
+
+// releasingBody calls release the first time the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (body *releasingBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.once.Do(body.release)
+	return err
+}
+
+// ParseRate parses a rate such as "2/s", "30/m" or "100/h" into requests per second. A number without a
+// unit is taken to be per second.
+func ParseRate(rate string) (float64, error) {
+	n, unit := rate, "s"
+	if i := strings.IndexByte(rate, '/'); i >= 0 {
+		n, unit = rate[:i], rate[i+1:]
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+	if err != nil || value < 0 {
+		return 0, errors.New("Could not parse " + rate + " as a rate")
+	}
+
+	switch strings.TrimSpace(unit) {
+	case "s":
+		return value, nil
+	case "m":
+		return value / 60, nil
+	case "h":
+		return value / 3600, nil
+	}
+
+	return 0, errors.New("Could not parse " + rate + " as a rate, the unit must be s, m or h")
+}
+
+// ParseHostLimit parses a per-host limit of the form "host=rate" or "host=rate,concurrency", such as
+// "cdn.example.com=20/s,8". A rate of 0 means the host has no rate limit.
+func ParseHostLimit(s string) (string, HostLimit, error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 {
+		return "", HostLimit{}, errors.New("Could not parse " + s + " as a host limit, expected host=rate[,concurrency]")
+	}
+
+	host, value := s[:i], s[i+1:]
+	limit := HostLimit{}
+
+	if j := strings.IndexByte(value, ','); j >= 0 {
+		concurrency, err := strconv.Atoi(strings.TrimSpace(value[j+1:]))
+		if err != nil || concurrency < 0 {
+			return "", HostLimit{}, errors.New("Could not parse " + s + " as a host limit, the concurrency must be a number")
+		}
+		limit.Concurrency = concurrency
+		value = value[:j]
+	}
+
+	rate, err := ParseRate(value)
+	if err != nil {
+		return "", HostLimit{}, err
+	}
+	limit.Rate = rate
+
+	return host, limit, nil
+}
@@ -0,0 +1,151 @@
+package anubis
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		rate    string
+		want    float64
+		wantErr bool
+	}{
+		{"2/s", 2, false},
+		{"30/m", 0.5, false},
+		{"360/h", 0.1, false},
+		{"5", 5, false},
+		{"0.5/s", 0.5, false},
+		{"2/d", 0, true},
+		{"fast", 0, true},
+		{"-1/s", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rate, func(t *testing.T) {
+			got, err := ParseRate(tt.rate)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseRate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseHostLimit(t *testing.T) {
+	tests := []struct {
+		s        string
+		wantHost string
+		want     HostLimit
+		wantErr  bool
+	}{
+		{"cdn.test.com=20/s", "cdn.test.com", HostLimit{Rate: 20}, false},
+		{"www.test.com:8080=1/s,2", "www.test.com:8080", HostLimit{Rate: 1, Concurrency: 2}, false},
+		{"cdn.test.com=0,8", "cdn.test.com", HostLimit{Concurrency: 8}, false},
+		{"cdn.test.com", "", HostLimit{}, true},
+		{"cdn.test.com=1/s,many", "", HostLimit{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			host, got, err := ParseHostLimit(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseHostLimit() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if host != tt.wantHost || got != tt.want {
+				t.Errorf("ParseHostLimit() = %v, %+v, want %v, %+v", host, got, tt.wantHost, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostLimiter_Acquire(t *testing.T) {
+	t.Run("Concurrency is limited per host", func(t *testing.T) {
+		limiter := NewHostLimiter()
+		limiter.Default.Concurrency = 2
+		limiter.Hosts["cdn.test.com"] = HostLimit{Concurrency: 4}
+
+		var inFlight, maxInFlight int32
+		measure := func(host string, counter *int32, max *int32) {
+			release, err := limiter.Acquire(context.Background(), host)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer release()
+
+			n := atomic.AddInt32(counter, 1)
+			for {
+				m := atomic.LoadInt32(max)
+				if n <= m || atomic.CompareAndSwapInt32(max, m, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(counter, -1)
+		}
+
+		var cdnInFlight, cdnMax int32
+		wg := sync.WaitGroup{}
+		for i := 0; i < 8; i++ {
+			wg.Add(2)
+			go func() { defer wg.Done(); measure("www.test.com", &inFlight, &maxInFlight) }()
+			go func() { defer wg.Done(); measure("cdn.test.com", &cdnInFlight, &cdnMax) }()
+		}
+		wg.Wait()
+
+		if maxInFlight != 2 {
+			t.Errorf("%v requests in flight to www.test.com, want 2", maxInFlight)
+		}
+		if cdnMax != 4 {
+			t.Errorf("%v requests in flight to cdn.test.com, want 4", cdnMax)
+		}
+	})
+
+	t.Run("Requests are spaced out by the rate", func(t *testing.T) {
+		limiter := NewHostLimiter()
+		limiter.Default.Rate = 20
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			release, err := limiter.Acquire(context.Background(), "www.test.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			release()
+		}
+
+		// The first request uses the initial token, and each of the next four waits 50ms
+		if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+			t.Errorf("5 requests took %v, want at least 200ms", elapsed)
+		}
+
+		// Other hosts have their own bucket
+		start = time.Now()
+		release, _ := limiter.Acquire(context.Background(), "cdn.test.com")
+		release()
+		if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+			t.Errorf("first request to another host took %v", elapsed)
+		}
+	})
+
+	t.Run("Waiting stops when the context is cancelled", func(t *testing.T) {
+		limiter := NewHostLimiter()
+		limiter.Default.Rate = 0.001
+
+		release, _ := limiter.Acquire(context.Background(), "www.test.com")
+		release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		if _, err := limiter.Acquire(ctx, "www.test.com"); err == nil {
+			t.Errorf("Acquire() error = nil, want a context error")
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package anubis
+
+import (
+	"context"
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// maxRedirects is the number of redirects followed for a single URL before giving up
+const maxRedirects = 10
+
+// RedirectHop is a single step of a redirect chain
+type RedirectHop struct {
+	URL        string // URL is the URL which was requested
+	StatusCode int    // StatusCode is the redirect status, or zero if the WebDriver followed the redirect itself
+}
+
+type redirectChainKey struct{}
+
+// RedirectChain returns the redirects which were followed before the request was made, in order. It is
+// empty if the request was not the result of a redirect.
+func RedirectChain(req *http.Request) []RedirectHop {
+	hops, _ := req.Context().Value(redirectChainKey{}).([]RedirectHop)
+	return hops
+}
+
+// withRedirectChain returns a copy of the request which carries the redirect chain
+func withRedirectChain(req *http.Request, hops []RedirectHop) *http.Request {
+	if len(hops) == 0 {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), redirectChainKey{}, hops))
+}
+
+// isRedirect reports whether the status code is a redirect which can be followed
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectLocation returns the absolute URL a redirect response points to
+func redirectLocation(req *http.Request, resp *http.Response) (string, bool) {
+	if !isRedirect(resp.StatusCode) {
+		return "", false
+	}
+
+	location := resp.Header.Get("Location")
+	if location == "" {
+		return "", false
+	}
+
+	u, err := getFullURL(req.URL.String(), location)
+	if err != nil {
+		return "", false
+	}
+
+	return u, true
+}
+
+// newHTTPClient creates a client which returns redirect responses instead of following them, so that each
+// hop can be recorded. A nil transport uses http.DefaultTransport.
+func newHTTPClient(transport http.RoundTripper) http.Client {
+	return http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// get fetches a URL using the instance's Driver and headers, following any redirects. It is used for
+// requests which are not archived, such as robots.txt and sitemaps. Like the workers' requests, they wait
+// for the host's limits and Crawl-delay, and are cancelled when the instance stops.
+func (a *Anubis) get(rawURL string) (*http.Response, error) {
+	ctx := a.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	driver := a.requestDriver()
+
+	for hops := 0; ; hops++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range a.Headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := driver.DoRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		next, ok := redirectLocation(req, resp)
+		if !ok {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		if hops == maxRedirects {
+			return nil, errors.New("Too many redirects from " + rawURL)
+		}
+		rawURL = next
+	}
+}
+
+// redirectStub is a page which sends browsers to the archived copy of the redirect's target
+func redirectStub(link string, target string) []byte {
+	link = html.EscapeString(link)
+	return []byte(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url=` + link + `">
+<link rel="canonical" href="` + html.EscapeString(target) + `">
+<title>Redirecting</title>
+</head>
+<body><a href="` + link + `">Redirecting to ` + html.EscapeString(target) + `</a></body>
+</html>
+`)
+}
+
+// pendingStubs holds the redirects to targets which were not recorded yet when their stubs were written,
+// so the stubs are written again once the target's path is known
+type pendingStubs struct {
+	mu   sync.Mutex
+	hops map[string][]RedirectHop
+}
+
+// lookupTarget returns the manifest entry for a redirect's target. If the target has not been recorded,
+// the hops are kept until takePendingStubs is called for it. Both hold the same lock, so a target
+// recorded at the same time is never missed.
+func (a *Anubis) lookupTarget(hops []RedirectHop, target string) (ManifestEntry, bool) {
+	if a.pendingStubs == nil {
+		return a.Manifest.Lookup(target)
+	}
+
+	a.pendingStubs.mu.Lock()
+	defer a.pendingStubs.mu.Unlock()
+
+	entry, ok := a.Manifest.Lookup(target)
+	if !ok {
+		if a.pendingStubs.hops == nil {
+			a.pendingStubs.hops = make(map[string][]RedirectHop)
+		}
+		u := stripFragment(target)
+		a.pendingStubs.hops[u] = append(a.pendingStubs.hops[u], hops...)
+	}
+	return entry, ok
+}
+
+// takePendingStubs returns the redirects to a target whose stubs were written before it was recorded. It
+// must be called after the target is recorded.
+func (a *Anubis) takePendingStubs(target string) []RedirectHop {
+	if a.pendingStubs == nil {
+		return nil
+	}
+
+	a.pendingStubs.mu.Lock()
+	defer a.pendingStubs.mu.Unlock()
+
+	u := stripFragment(target)
+	hops := a.pendingStubs.hops[u]
+	delete(a.pendingStubs.hops, u)
+	return hops
+}
+
+// writeRedirectStubs writes a stub for each URL which redirects to the target, and records each of them
+// in the Manifest as an alias of the target, so that rewritten links point straight to the target.
+func (a *Anubis) writeRedirectStubs(hops []RedirectHop, target string) {
+	// The target may still be queued, in which case its content type is not known yet. Its path is
+	// guessed, and the stubs are written again when it is recorded.
+	entry, ok := a.lookupTarget(hops, target)
+	targetPath := entry.Path
+	if !ok {
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return
+		}
+		targetPath = a.Mapper.MapPath(targetURL, "")
+	}
+
+	for _, hop := range hops {
+		u, err := url.Parse(hop.URL)
+		if err != nil {
+			continue
+		}
+
+		// A redirect between URLs which share a file, such as from http to https, needs no stub
+		stubPath := a.Mapper.MapPath(u, "text/html")
+		if stubPath == targetPath {
+			continue
+		}
+
+		if err := a.writeOutput(stubPath, redirectStub(relativeLink(stubPath, targetPath), target)); err != nil {
+			log.Println("Could not write redirect stub for", hop.URL, err)
+			continue
+		}
+
+		a.Manifest.Record(ManifestEntry{
+			URL:         hop.URL,
+			Path:        stubPath,
+			ContentType: "text/html; charset=utf-8",
+			Status:      hop.StatusCode,
+			RedirectTo:  target,
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package anubis
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// RedirectWebDriver answers URLs listed in redirects with a 301 to the given location, and serves every
+// other request from the StaticWebDriver as HTML
+type RedirectWebDriver struct {
+	StaticWebDriver
+	redirects map[string]string
+}
+
+func (driver *RedirectWebDriver) DoRequest(req *http.Request) (*http.Response, error) {
+	location, ok := driver.redirects[req.URL.String()]
+	if !ok {
+		resp, err := driver.StaticWebDriver.DoRequest(req)
+		if err == nil {
+			resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+		}
+		return resp, err
+	}
+
+	driver.mu.Lock()
+	if driver.requests == nil {
+		driver.requests = make(map[string]int)
+	}
+	driver.requests[req.URL.String()]++
+	driver.mu.Unlock()
+
+	return &http.Response{
+		StatusCode: http.StatusMovedPermanently,
+		Header:     http.Header{"Location": []string{location}},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+// CapturingResponseHandler keeps the last request it handled
+type CapturingResponseHandler struct {
+	req *http.Request
+}
+
+func (handler *CapturingResponseHandler) Handle(req *http.Request, resp *http.Response) error {
+	handler.req = req
+	return resp.Body.Close()
+}
+
+func TestDefaultRequestProcessor_Process_redirects(t *testing.T) {
+	driver := &RedirectWebDriver{
+		StaticWebDriver: StaticWebDriver{responses: map[string]string{"https://www.test.com/new/": "new"}},
+		redirects: map[string]string{
+			"https://www.test.com/old":    "/older",
+			"https://www.test.com/older":  "https://www.test.com/new/",
+			"https://www.test.com/loop-a": "/loop-b",
+			"https://www.test.com/loop-b": "/loop-a",
+		},
+	}
+
+	tests := []struct {
+		name      string
+		url       string
+		wantChain []RedirectHop
+		wantURL   string
+		wantErr   bool
+	}{
+		{
+			name:    "Responses without a redirect have no chain",
+			url:     "https://www.test.com/new/",
+			wantURL: "https://www.test.com/new/",
+		},
+		{
+			name: "Each hop is recorded in order",
+			url:  "https://www.test.com/old",
+			wantChain: []RedirectHop{
+				{URL: "https://www.test.com/old", StatusCode: 301},
+				{URL: "https://www.test.com/older", StatusCode: 301},
+			},
+			wantURL: "https://www.test.com/new/",
+		},
+		{
+			name:    "Redirect loops are abandoned",
+			url:     "https://www.test.com/loop-a",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := &CapturingResponseHandler{}
+
+			// Without an owning instance there is no duplicate filter, so loops run until maxRedirects
+			processor := &DefaultRequestProcessor{}
+			err := processor.Process(tt.url, nil, driver, handler)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			gotReq := handler.req
+			if gotReq.URL.String() != tt.wantURL {
+				t.Errorf("handled %v, want %v", gotReq.URL, tt.wantURL)
+			}
+
+			chain := RedirectChain(gotReq)
+			if len(chain) != len(tt.wantChain) {
+				t.Fatalf("RedirectChain() = %v, want %v", chain, tt.wantChain)
+			}
+			for i := range chain {
+				if chain[i] != tt.wantChain[i] {
+					t.Errorf("RedirectChain()[%v] = %v, want %v", i, chain[i], tt.wantChain[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAnubis_redirects(t *testing.T) {
+	driver := &RedirectWebDriver{
+		StaticWebDriver: StaticWebDriver{responses: map[string]string{
+			"https://www.test.com/":     `<a href="/old">old</a><a href="/other">other</a>`,
+			"https://www.test.com/new/": "new",
+		}},
+		redirects: map[string]string{
+			"https://www.test.com/old":   "/new/",
+			"https://www.test.com/other": "/new/",
+		},
+	}
+
+	a := NewAnubis(OutputOpt(t.TempDir()), WebDriverOpt{driver}, CrawlOpt{})
+	a.AddStartURL("https://www.test.com/")
+	a.Start()
+	a.Wait()
+
+	if got := driver.requests["https://www.test.com/new/"]; got != 1 {
+		t.Errorf("fetched the redirect target %v times, want 1", got)
+	}
+
+	for _, u := range []string{"https://www.test.com/old", "https://www.test.com/other"} {
+		entry, ok := a.Manifest.Lookup(u)
+		if !ok || entry.Path != "www.test.com/new/index.html" {
+			t.Errorf("Manifest.Lookup(%v) = %v, %v, want the entry for the target", u, entry, ok)
+		}
+	}
+
+	for _, p := range []string{"www.test.com/old.html", "www.test.com/other.html"} {
+		stub, err := os.ReadFile(filepath.Join(a.Output, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(stub), `url=new/index.html"`) {
+			t.Errorf("stub %v = %s, want a refresh to new/index.html", p, stub)
+		}
+	}
+
+	if err := a.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	page, err := os.ReadFile(filepath.Join(a.Output, "www.test.com/index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<a href="new/index.html">old</a><a href="new/index.html">other</a>`; string(page) != want {
+		t.Errorf("Rewrite() page = %s, want %v", page, want)
+	}
+}
+
+func TestDefaultResponseHandler_Handle_pendingRedirectStubs(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+
+	// The redirect is handled before its target, whose path depends on its content type
+	req, resp := newTestResponse(t, "https://www.test.com/old", http.StatusMovedPermanently, "", "")
+	resp.Header.Set("Location", "/data")
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	req, resp = newTestResponse(t, "https://www.test.com/data", 200, "application/json", `{"a": 1}`)
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	stub, err := os.ReadFile(filepath.Join(a.Output, "www.test.com", "old.html"))
+	if err != nil || !strings.Contains(string(stub), `url=data.json"`) {
+		t.Errorf("stub = %s, %v, want a refresh to data.json", stub, err)
+	}
+	if entry, ok := a.Manifest.Lookup("https://www.test.com/old"); !ok || entry.Path != "www.test.com/data.json" {
+		t.Errorf("Manifest.Lookup() = %+v, %v, want the entry for the target", entry, ok)
+	}
+}
+
+func TestAnubis_redirects_notFollowed(t *testing.T) {
+	driver := &RedirectWebDriver{
+		StaticWebDriver: StaticWebDriver{responses: map[string]string{
+			"https://www.test.com/robots.txt":   "User-agent: *\nDisallow: /private/\n",
+			"https://www.test.com/":             `<a href="/offsite">offsite</a><a href="/hidden">hidden</a>`,
+			"https://other.test.com/page":       "other",
+			"https://www.test.com/private/page": "private",
+		}},
+		redirects: map[string]string{
+			"http://test.com/":             "https://www.test.com/",
+			"https://www.test.com/offsite": "https://other.test.com/page",
+			"https://www.test.com/hidden":  "/private/page",
+		},
+	}
+
+	a := NewAnubis(OutputOpt(t.TempDir()), WebDriverOpt{driver}, CrawlOpt{}, RobotsOpt(true))
+	a.AddStartURL("http://test.com/")
+	a.Start()
+	a.Wait()
+
+	// The start URL's own redirect is followed to another host, which becomes part of the crawl
+	if got := driver.requests["https://www.test.com/"]; got != 1 {
+		t.Errorf("fetched the start URL's target %v times, want 1", got)
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		target string
+	}{
+		{"Targets outside the crawl are not followed", "https://www.test.com/offsite", "https://other.test.com/page"},
+		{"Targets disallowed by robots.txt are not followed", "https://www.test.com/hidden", "https://www.test.com/private/page"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := driver.requests[tt.target]; got != 0 {
+				t.Errorf("fetched %v %v times, want 0", tt.target, got)
+			}
+
+			// Only the redirect itself is recorded
+			entry, ok := a.Manifest.Get(tt.url)
+			if !ok || entry.RedirectTo != tt.target {
+				t.Errorf("Manifest.Get(%v) = %+v, %v, want a redirect to %v", tt.url, entry, ok, tt.target)
+			}
+			if _, ok := a.Manifest.Get(tt.target); ok {
+				t.Errorf("Manifest.Get(%v) found an entry for the target", tt.target)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package anubis
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RetryPolicy decides whether and when a failed request is attempted again. Network errors are always
+// retried, while responses are only retried if their status code is listed in RetryStatus.
+type RetryPolicy struct {
+	MaxAttempts int           // MaxAttempts is the total number of attempts, including the first
+	BaseDelay   time.Duration // BaseDelay is the delay before the first retry, doubled for each retry after it
+	MaxDelay    time.Duration // MaxDelay caps the delay between attempts
+	RetryStatus []int         // RetryStatus lists the response status codes which are treated as transient
+}
+
+// DefaultRetryPolicy is used by new instances unless overridden with RetryOpt
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+	RetryStatus: []int{
+		http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+}
+
+// Retryable reports whether a response with the status code should be retried
+func (policy RetryPolicy) Retryable(status int) bool {
+	for _, s := range policy.RetryStatus {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns how long to wait before the next attempt, given the number of attempts made so far and
+// the failed response, which is nil for network errors. The second return value is false if no further
+// attempt should be made, either because MaxAttempts was reached or because the server asked for a
+// longer Retry-After than MaxDelay.
+func (policy RetryPolicy) Backoff(attempts int, resp *http.Response) (time.Duration, bool) {
+	if attempts >= policy.MaxAttempts {
+		return 0, false
+	}
+
+	if resp != nil {
+		if delay, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return delay, delay <= policy.MaxDelay
+		}
+	}
+
+	// Exponential backoff with jitter, so that workers which failed together do not retry together
+	delay := policy.BaseDelay << uint(attempts-1)
+	if delay > policy.MaxDelay || delay <= 0 {
+		delay = policy.MaxDelay
+	}
+	if half := int64(delay / 2); half > 0 {
+		delay = time.Duration(half + rand.Int63n(half+1))
+	}
+
+	return delay, true
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if delay := t.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// FetchError is returned when a URL could not be fetched after every attempt allowed by the RetryPolicy
+type FetchError struct {
+	URL        string
+	Attempts   int
+	StatusCode int   // StatusCode is the status of the last response, or zero if there was no response
+	Err        error // Err is the error from the last attempt, or nil if the server responded
+}
+
+func (err *FetchError) Error() string {
+	msg := "Could not fetch " + err.URL + " after " + strconv.Itoa(err.Attempts) + " attempts: "
+	if err.Err != nil {
+		return msg + err.Err.Error()
+	}
+	return msg + strconv.Itoa(err.StatusCode) + " " + http.StatusText(err.StatusCode)
+}
+
+func (err *FetchError) Unwrap() error { return err.Err }
+
+// Failure records a URL which could not be archived
+type Failure struct {
+	URL  string
+	Err  error
+	Time time.Time
+}
+
+// failureLog collects failures from every worker, as well as the error which stopped the run, if any. It
+// is safe for concurrent use.
+type failureLog struct {
+	mu       sync.Mutex
+	failures []Failure
+	fatal    error
+}
+
+// fail records the error which stopped the run. It returns false if the run had already failed.
+func (fl *failureLog) fail(err error) bool {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+
+	if fl.fatal != nil {
+		return false
+	}
+	fl.fatal = err
+	return true
+}
+
+func (fl *failureLog) err() error {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return fl.fatal
+}
+
+func (fl *failureLog) add(url string, err error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	fl.failures = append(fl.failures, Failure{URL: url, Err: err, Time: time.Now()})
+}
+
+func (fl *failureLog) all() []Failure {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	return append([]Failure{}, fl.failures...)
+}
@@ -0,0 +1,252 @@
+package anubis
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 4, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	withHeader := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+
+	tests := []struct {
+		name      string
+		attempts  int
+		resp      *http.Response
+		wantMin   time.Duration
+		wantMax   time.Duration
+		wantRetry bool
+	}{
+		{"First retry uses the base delay with jitter", 1, nil, 50 * time.Millisecond, 100 * time.Millisecond, true},
+		{"Delay doubles with each attempt", 3, nil, 200 * time.Millisecond, 400 * time.Millisecond, true},
+		{"No retry after MaxAttempts", 4, nil, 0, 0, false},
+		{"Retry-After in seconds is honoured", 1, withHeader("1"), time.Second, time.Second, true},
+		{"Retry-After longer than MaxDelay gives up", 1, withHeader("120"), 120 * time.Second, 120 * time.Second, false},
+		{"Invalid Retry-After falls back to backoff", 1, withHeader("soon"), 50 * time.Millisecond, 100 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, retry := policy.Backoff(tt.attempts, tt.resp)
+			if retry != tt.wantRetry {
+				t.Errorf("Backoff() retry = %v, want %v", retry, tt.wantRetry)
+			}
+			if got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("Backoff() = %v, want between %v and %v", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+
+	t.Run("Delay is capped by MaxDelay", func(t *testing.T) {
+		policy := RetryPolicy{MaxAttempts: 100, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+		if got, _ := policy.Backoff(50, nil); got > 5*time.Second {
+			t.Errorf("Backoff() = %v, want at most 5s", got)
+		}
+	})
+}
+
+func Test_retryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	if got, ok := retryAfter("Thu, 01 Oct 2026 12:00:30 GMT", now); !ok || got != 30*time.Second {
+		t.Errorf("retryAfter() = %v, %v, want 30s, true", got, ok)
+	}
+
+	if got, ok := retryAfter("Thu, 01 Oct 2026 11:00:00 GMT", now); !ok || got != 0 {
+		t.Errorf("retryAfter() = %v, %v, want 0, true", got, ok)
+	}
+}
+
+// FlakyWebDriver fails each URL a number of times, either with an error or a status code, before
+// responding normally
+type FlakyWebDriver struct {
+	failures int
+	status   int
+
+	mu       sync.Mutex
+	attempts map[string]int
+}
+
+func (driver *FlakyWebDriver) DoRequest(req *http.Request) (*http.Response, error) {
+	driver.mu.Lock()
+	if driver.attempts == nil {
+		driver.attempts = make(map[string]int)
+	}
+	driver.attempts[req.URL.String()]++
+	n := driver.attempts[req.URL.String()]
+	driver.mu.Unlock()
+
+	status := http.StatusOK
+	if n <= driver.failures {
+		if driver.status == 0 {
+			return nil, errors.New("connection reset")
+		}
+		status = driver.status
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("body")),
+	}, nil
+}
+
+type CountingResponseHandler struct {
+	mu      sync.Mutex
+	handled []int
+}
+
+func (handler *CountingResponseHandler) Handle(req *http.Request, resp *http.Response) error {
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+	handler.handled = append(handler.handled, resp.StatusCode)
+	return resp.Body.Close()
+}
+
+func TestDefaultRequestProcessor_Process(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond, RetryStatus: []int{503}}
+
+	tests := []struct {
+		name         string
+		driver       *FlakyWebDriver
+		wantAttempts int
+		wantHandled  int
+		wantErr      bool
+	}{
+		{"Network errors are retried", &FlakyWebDriver{failures: 2}, 3, 1, false},
+		{"Retryable status codes are retried", &FlakyWebDriver{failures: 1, status: 503}, 2, 1, false},
+		{"Other status codes are handled immediately", &FlakyWebDriver{failures: 1, status: 404}, 1, 1, false},
+		{"The handler is not called once attempts run out", &FlakyWebDriver{failures: 5, status: 503}, 3, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAnubis(RetryOpt(policy))
+			handler := &CountingResponseHandler{}
+
+			err := a.processor.Process("https://www.test.com/", nil, tt.driver, handler)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			var fetchErr *FetchError
+			if tt.wantErr && (!errors.As(err, &fetchErr) || fetchErr.Attempts != tt.wantAttempts) {
+				t.Errorf("Process() error = %#v, want a FetchError after %v attempts", err, tt.wantAttempts)
+			}
+
+			if got := tt.driver.attempts["https://www.test.com/"]; got != tt.wantAttempts {
+				t.Errorf("made %v attempts, want %v", got, tt.wantAttempts)
+			}
+
+			if len(handler.handled) != tt.wantHandled {
+				t.Errorf("handled %v responses, want %v", len(handler.handled), tt.wantHandled)
+			}
+		})
+	}
+}
+
+func TestDefaultRequestProcessor_Process_statusPolicy(t *testing.T) {
+	retry := RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, RetryStatus: []int{503}}
+
+	tests := []struct {
+		name      string
+		rules     string
+		wantSaved bool
+		wantFail  bool
+	}{
+		{"Retries running out on a code saved to errors", "5xx=errors", true, false},
+		{"Retries running out on a code failing the run", "5xx=fail", false, true},
+		{"Retries running out on a kept code", "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseStatusPolicy(tt.rules)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := NewAnubis(OutputOpt(t.TempDir()), RetryOpt(retry), StatusPolicyOpt(policy))
+			a.Context, a.Cancel = context.WithCancel(context.Background())
+			driver := &FlakyWebDriver{failures: 5, status: 503}
+
+			err = a.processor.Process("https://www.test.com/page", nil, driver, a.Handler)
+			if err == nil {
+				t.Errorf("Process() succeeded, want an error")
+			}
+			if got := driver.attempts["https://www.test.com/page"]; got != 2 {
+				t.Errorf("made %v attempts, want 2", got)
+			}
+
+			files, _ := os.ReadDir(a.outputPath(path.Join(ErrorsDir, "www.test.com")))
+			if saved := len(files) == 1; saved != tt.wantSaved {
+				t.Errorf("saved to errors = %v, want %v", saved, tt.wantSaved)
+			}
+			if failed := a.Err() != nil; failed != tt.wantFail {
+				t.Errorf("Err() = %v, want failed %v", a.Err(), tt.wantFail)
+			}
+		})
+	}
+}
+
+// BlockingWebDriver answers no request until the request's context is cancelled
+type BlockingWebDriver struct{}
+
+func (BlockingWebDriver) DoRequest(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestDefaultRequestProcessor_Process_cancel(t *testing.T) {
+	a := NewAnubis(RetryOpt{MaxAttempts: 3, BaseDelay: time.Hour, MaxDelay: time.Hour})
+	a.Context, a.Cancel = context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.processor.Process("https://www.test.com/", nil, BlockingWebDriver{}, &CountingResponseHandler{})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	a.Cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Process() error = %v, want the context's error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Process() did not return after the instance was cancelled")
+	}
+}
+
+func TestAnubis_Failures(t *testing.T) {
+	a := NewAnubis(
+		WebDriverOpt{&FlakyWebDriver{failures: 10, status: 429}},
+		ResponseHandlerOpt{&CountingResponseHandler{}},
+		RetryOpt{MaxAttempts: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, RetryStatus: []int{429}},
+	)
+
+	a.AddURL("https://www.test.com/a")
+	a.AddURL("https://www.test.com/b")
+	a.Start()
+	a.Wait()
+
+	failures := a.Failures()
+	if len(failures) != 2 {
+		t.Fatalf("Failures() = %v, want 2 failures", failures)
+	}
+
+	for _, failure := range failures {
+		var fetchErr *FetchError
+		if !errors.As(failure.Err, &fetchErr) || fetchErr.StatusCode != 429 {
+			t.Errorf("failure for %v = %v, want a 429 FetchError", failure.URL, failure.Err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package anubis
+
+import (
+	"bytes"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ManifestEntry describes a single file written to the output directory
+type ManifestEntry struct {
+	URL         string      `json:"url"`                   // URL is the URL the file was fetched from, without a fragment
+	Path        string      `json:"path"`                  // Path is the slash-separated location of the file, relative to the output directory
+	ContentType string      `json:"content_type"`          // ContentType is the response's type as classified by ClassifyMedia
+	Status      int         `json:"status,omitempty"`      // Status is the response's status code, or the redirect's for a stub
+	Header      http.Header `json:"header,omitempty"`      // Header holds the response headers which are restored when replaying
+	RedirectTo  string      `json:"redirect_to,omitempty"` // RedirectTo is the URL this URL redirects to, if the file is a redirect stub
+	Canonical   string      `json:"canonical,omitempty"`   // Canonical is the URL the page declares as canonical with <link rel=canonical>
+	Digest      string      `json:"sha256,omitempty"`      // Digest is the SHA-256 of the body as fetched, before Rewrite changed it
+}
+
+// Manifest keeps track of every file written during a run, so that references between archived files can
+// be rewritten once all of them are known. It is safe for concurrent use.
+type Manifest struct {
+	mu        sync.RWMutex
+	entries   map[string]ManifestEntry
+	canonical map[string]string // canonical maps canonical URLs which were not recorded themselves to a page declaring them
+}
+
+func NewManifest() *Manifest {
+	return &Manifest{entries: make(map[string]ManifestEntry), canonical: make(map[string]string)}
+}
+
+// Record stores the entry, replacing any previous entry for the same URL
+func (m *Manifest) Record(entry ManifestEntry) {
+	entry.URL = stripFragment(entry.URL)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[entry.URL] = entry
+
+	if canonical := stripFragment(entry.Canonical); canonical != "" && canonical != entry.URL {
+		if _, ok := m.canonical[canonical]; !ok {
+			m.canonical[canonical] = entry.URL
+		}
+	}
+}
+
+// Lookup returns the entry recorded for the URL. Fragments are ignored. If the URL redirects to another
+// recorded URL, the entry for the redirect's final target is returned instead of the stub. A URL which was
+// not recorded, but which a recorded page declares as its canonical URL, returns that page.
+func (m *Manifest) Lookup(u string) (ManifestEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[stripFragment(u)]
+	if alias, found := m.canonical[stripFragment(u)]; !ok && found {
+		entry, ok = m.entries[alias]
+	}
+	for hops := 0; ok && entry.RedirectTo != "" && hops < maxRedirects; hops++ {
+		target, found := m.entries[stripFragment(entry.RedirectTo)]
+		if !found {
+			break
+		}
+		entry = target
+	}
+
+	return entry, ok
+}
+
+// Remove forgets the entry recorded for the URL, along with the canonical URLs which refer to it
+func (m *Manifest) Remove(u string) {
+	u = stripFragment(u)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, u)
+	for canonical, alias := range m.canonical {
+		if alias == u {
+			delete(m.canonical, canonical)
+		}
+	}
+}
+
+// Get returns the entry recorded for the URL without following redirects
+func (m *Manifest) Get(u string) (ManifestEntry, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	entry, ok := m.entries[stripFragment(u)]
+	return entry, ok
+}
+
+// Entries returns all entries, sorted by URL
+func (m *Manifest) Entries() []ManifestEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entries := make([]ManifestEntry, 0, len(m.entries))
+	for _, entry := range m.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].URL < entries[j].URL })
+	return entries
+}
+
+func stripFragment(u string) string {
+	if i := strings.IndexByte(u, '#'); i >= 0 {
+		return u[:i]
+	}
+	return u
+}
+
+// relativeLink returns a URL which refers to the file at target when used from a file at from. Both
+// paths are slash-separated and relative to the same directory.
+func relativeLink(from string, target string) string {
+	rel, err := filepath.Rel(filepath.FromSlash(path.Dir(from)), filepath.FromSlash(target))
+	if err != nil {
+		rel = target
+	}
+
+	segments := strings.Split(filepath.ToSlash(rel), "/")
+	for i, segment := range segments {
+		if segment != ".." {
+			segments[i] = url.PathEscape(segment)
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// rewriteReferences replaces each reference in the document with the result of the replace function.
+// References must be in document order and must not overlap. If replace returns false, the reference is
+// left as it is.
+func rewriteReferences(doc []byte, refs []Reference, replace func(Reference) (string, bool)) []byte {
+	out := bytes.Buffer{}
+	last := 0
+
+	for _, ref := range refs {
+		if ref.Start < last {
+			continue
+		}
+
+		replacement, ok := replace(ref)
+		if !ok {
+			continue
+		}
+
+		out.Write(doc[last:ref.Start])
+		out.WriteString(replacement)
+		last = ref.End
+	}
+
+	out.Write(doc[last:])
+	return out.Bytes()
+}
+
+// Rewrite updates every archived HTML page, stylesheet, script and web app manifest so that references to other captured files point
+// at the local copies using relative paths. References to files which were not captured are left untouched,
+// or replaced with Placeholder if it is set.
+//
+// Rewrite should be called after all work has finished, since it relies on the instance's Manifest to know
+// which files were written. It has no effect if RewriteLinks is false.
+func (a *Anubis) Rewrite() error {
+	if !a.RewriteLinks {
+		return nil
+	}
+
+	for _, entry := range a.Manifest.Entries() {
+		// Redirect stubs already link to their target
+		if entry.RedirectTo != "" {
+			continue
+		}
+
+		var extract func(string, []byte) []Reference
+		var escape func(Reference) func(string) string
+
+		switch kind := MediaKindOf(entry.ContentType); {
+		case kind == MediaHTML:
+			extract, escape = ExtractReferences, htmlReferenceEscaper
+		case kind == MediaCSS:
+			extract, escape = ExtractCSSReferences, func(Reference) func(string) string { return cssEscape }
+		case kind == MediaJavaScript:
+			imports := a.importMap()
+			extract = func(parent string, doc []byte) []Reference { return ExtractJSReferences(parent, doc, imports) }
+			escape = jsReferenceEscaper
+		case a.isWebManifest(entry.URL, entry.ContentType):
+			extract, escape = ExtractManifestReferences, func(Reference) func(string) string { return jsonEscape }
+		default:
+			continue
+		}
+
+		p := a.outputPath(entry.Path)
+		doc, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+
+		rewritten := rewriteReferences(doc, extract(entry.URL, doc), func(ref Reference) (string, bool) {
+			return a.localReference(entry, ref, escape(ref))
+		})
+
+		if bytes.Equal(rewritten, doc) {
+			continue
+		}
+
+		if err := os.WriteFile(p, rewritten, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// localReference returns the replacement for a reference found in the file described by entry
+func (a *Anubis) localReference(entry ManifestEntry, ref Reference, escape func(string) string) (string, bool) {
+	// A <base> would change how the rewritten relative links are resolved, so it is pointed at the
+	// local document itself
+	if ref.Kind == BaseReference {
+		return escape(relativeLink(entry.Path, entry.Path)), true
+	}
+
+	// Fragment-only references already point within the local document
+	if ref.URL == "" || strings.HasPrefix(ref.Raw, "#") {
+		return "", false
+	}
+
+	// Files kept from an earlier run, such as those the status policy kept after a failed refetch, are
+	// only in the stored manifest
+	target, ok := a.lookupEntry(ref.URL)
+	if !ok {
+		// Metadata such as preconnect origins and canonical URLs is never fetched, so it is left alone
+		if a.Placeholder == "" || (ref.Kind != AssetReference && ref.Kind != PageReference) {
+			return "", false
+		}
+		return escape(a.Placeholder), true
+	}
+
+	link := relativeLink(entry.Path, target.Path)
+	if i := strings.IndexByte(ref.URL, '#'); i >= 0 {
+		link += ref.URL[i:]
+	}
+
+	return escape(link), true
+}
+
+// htmlReferenceEscaper returns how a replacement for a reference in an HTML document is escaped. The
+// contents of <style> elements are not decoded, so references in them are only escaped for CSS, while
+// those in style attributes are escaped for CSS and then for the attribute. Likewise the contents of
+// <script> elements are only escaped for JavaScript, or for JSON within import maps.
+func htmlReferenceEscaper(ref Reference) func(string) string {
+	switch {
+	case ref.Tag == "script" && ref.Attr == "importmap":
+		return func(s string) string { return jsonEscape(moduleSpecifier(s)) }
+	case ref.embedded() && ref.Tag == "script":
+		return jsReferenceEscaper(ref)
+	case ref.Attr == "style":
+		return func(s string) string { return html.EscapeString(cssEscape(s)) }
+	case ref.Tag == "style":
+		return cssEscape
+	default:
+		return html.EscapeString
+	}
+}
+
+// cssEscape escapes characters which would end a quoted or unquoted url() in a stylesheet
+func cssEscape(s string) string {
+	return strings.NewReplacer(`"`, `\"`, `'`, `\'`, `(`, `\(`, `)`, `\)`, ` `, `\ `).Replace(s)
+}
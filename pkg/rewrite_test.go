@@ -0,0 +1,199 @@
+package anubis
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_relativeLink(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   string
+		target string
+		want   string
+	}{
+		{"Same directory", "a.com/index.html", "a.com/style.css", "style.css"},
+		{"Sub directory", "a.com/index.html", "a.com/img/logo.png", "img/logo.png"},
+		{"Parent directory", "a.com/blog/post.html", "a.com/style.css", "../style.css"},
+		{"Other host", "a.com/index.html", "cdn.a.com/app.js", "../cdn.a.com/app.js"},
+		{"Unsafe characters are escaped", "a.com/index.html", "a.com/my file.png", "my%20file.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := relativeLink(tt.from, tt.target); got != tt.want {
+				t.Errorf("relativeLink() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnubis_Rewrite(t *testing.T) {
+	write := func(t *testing.T, a *Anubis, u, p, contentType, content string) {
+		full := filepath.Join(a.Output, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0774); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		a.Manifest.Record(ManifestEntry{URL: u, Path: p, ContentType: contentType})
+	}
+
+	read := func(t *testing.T, a *Anubis, p string) string {
+		b, err := os.ReadFile(filepath.Join(a.Output, filepath.FromSlash(p)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name        string
+		placeholder string
+		want        string
+		wantCSS     string
+	}{
+		{
+			name:    "Captured references are rewritten and others are left alone",
+			want:    `<link href="../css/style.css"><img src='../logo.png'><a href="https://other.com/">x</a><a href="#top"><link rel="preconnect" href="https://fonts.test.com"><link rel="canonical" href="https://www.test.com/blog">`,
+			wantCSS: `body { background: url("../img/bg.png") } .a { background: url(missing.png) }`,
+		},
+		{
+			name:        "Uncaptured references are replaced by the placeholder",
+			placeholder: "about:blank",
+			want:        `<link href="../css/style.css"><img src='../logo.png'><a href="about:blank">x</a><a href="#top"><link rel="preconnect" href="https://fonts.test.com"><link rel="canonical" href="https://www.test.com/blog">`,
+			wantCSS:     `body { background: url("../img/bg.png") } .a { background: url(about:blank) }`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAnubis(OutputOpt(t.TempDir()), PlaceholderOpt(tt.placeholder))
+
+			write(t, a, "https://www.test.com/blog/", "www.test.com/blog/index.html", "text/html; charset=utf-8",
+				`<link href="/css/style.css"><img src='/logo.png'><a href="https://other.com/">x</a><a href="#top"><link rel="preconnect" href="https://fonts.test.com"><link rel="canonical" href="https://www.test.com/blog">`)
+			write(t, a, "https://www.test.com/css/style.css", "www.test.com/css/style.css", "text/css",
+				`body { background: url("/img/bg.png") } .a { background: url(missing.png) }`)
+			write(t, a, "https://www.test.com/logo.png", "www.test.com/logo.png", "image/png", "")
+			write(t, a, "https://www.test.com/img/bg.png", "www.test.com/img/bg.png", "image/png", "")
+
+			if err := a.Rewrite(); err != nil {
+				t.Fatalf("Rewrite() error = %v", err)
+			}
+
+			if got := read(t, a, "www.test.com/blog/index.html"); got != tt.want {
+				t.Errorf("Rewrite() page = %v, want %v", got, tt.want)
+			}
+
+			if got := read(t, a, "www.test.com/css/style.css"); got != tt.wantCSS {
+				t.Errorf("Rewrite() stylesheet = %v, want %v", got, tt.wantCSS)
+			}
+		})
+	}
+}
+
+func TestAnubis_Rewrite_styles(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+	for _, entry := range []ManifestEntry{
+		{URL: "https://www.test.com/blog/", Path: "www.test.com/blog/index.html", ContentType: "text/html"},
+		{URL: "https://www.test.com/my%20bg.png", Path: "www.test.com/my bg.png", ContentType: "image/png"},
+		{URL: "https://www.test.com/a.css", Path: "www.test.com/a.css", ContentType: "text/css"},
+	} {
+		a.Manifest.Record(entry)
+	}
+
+	page := `<style>@import "/a.css"; p { background: url(/my%20bg.png) }</style>` +
+		`<div style="background: url(&quot;/my%20bg.png&quot;)"></div><p style="background: url('/my%20bg.png')">`
+	if err := a.writeOutput("www.test.com/blog/index.html", []byte(page)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.writeOutput("www.test.com/a.css", []byte("p {}")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(a.outputPath("www.test.com/blog/index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<style>@import "../a.css"; p { background: url(../my%20bg.png) }</style>` +
+		`<div style="background: url(../my%20bg.png)"></div><p style="background: url('../my%20bg.png')">`
+	if string(got) != want {
+		t.Errorf("Rewrite() = %v, want %v", string(got), want)
+	}
+}
+
+func TestAnubis_Rewrite_srcset(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+	for _, entry := range []ManifestEntry{
+		{URL: "https://www.test.com/blog/", Path: "www.test.com/blog/index.html", ContentType: "text/html"},
+		{URL: "https://www.test.com/a.png", Path: "www.test.com/a.png", ContentType: "image/png"},
+		{URL: "https://www.test.com/a-2x.png", Path: "www.test.com/a-2x.png", ContentType: "image/png"},
+	} {
+		a.Manifest.Record(entry)
+	}
+
+	page := `<img src="/a.png" srcset="/a.png 1x, /a-2x.png 2x, /a-3x.png 3x">`
+	if err := a.writeOutput("www.test.com/blog/index.html", []byte(page)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(a.outputPath("www.test.com/blog/index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Descriptors are kept, and candidates which were not archived are left alone
+	want := `<img src="../a.png" srcset="../a.png 1x, ../a-2x.png 2x, /a-3x.png 3x">`
+	if string(got) != want {
+		t.Errorf("Rewrite() = %v, want %v", string(got), want)
+	}
+}
+
+func TestAnubis_Rewrite_keptPrevious(t *testing.T) {
+	dir := t.TempDir()
+	page := `<img src="/logo.png"><img src="/missing.png">`
+
+	previous := NewAnubis(OutputOpt(dir))
+	for _, r := range []struct{ url, contentType, body string }{
+		{"https://www.test.com/", "text/html", page},
+		{"https://www.test.com/logo.png", "image/png", "\x89PNG\r\n\x1a\n"},
+	} {
+		req, resp := newTestResponse(t, r.url, 200, r.contentType, r.body)
+		if err := previous.Handler.Handle(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := previous.SaveManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The image fails to refetch, so the status policy keeps its previous copy
+	retry := RetryOpt{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, RetryStatus: []int{503}}
+	a := NewAnubis(OutputOpt(dir), PlaceholderOpt("about:blank"), retry)
+	req, resp := newTestResponse(t, "https://www.test.com/", 200, "text/html", page)
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.processor.Process("https://www.test.com/logo.png", nil, &FlakyWebDriver{failures: 1, status: 503}, a.Handler); err == nil {
+		t.Fatal("Process() succeeded, want an error")
+	}
+
+	if err := a.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `<img src="logo.png"><img src="about:blank">`
+	if got, err := os.ReadFile(a.outputPath("www.test.com/index.html")); err != nil || string(got) != want {
+		t.Errorf("Rewrite() = %s, %v, want %v", got, err, want)
+	}
+}
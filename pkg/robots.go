@@ -0,0 +1,325 @@
+package anubis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// robotsGroup is a set of rules which apply to one or more user agents
+type robotsGroup struct {
+	agents     []string
+	rules      []robotsRule
+	crawlDelay time.Duration
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// RobotsRules holds the parsed contents of a robots.txt file
+type RobotsRules struct {
+	groups   []*robotsGroup
+	Sitemaps []string // Sitemaps lists every sitemap URL declared in the file
+}
+
+// allowAll and disallowAll are used when a robots.txt file cannot be parsed. Following RFC 9309, a missing
+// file allows everything, while a file which cannot be reached because of a server error allows nothing.
+var (
+	allowAll    = &RobotsRules{}
+	disallowAll = &RobotsRules{groups: []*robotsGroup{{agents: []string{"*"}, rules: []robotsRule{{false, "/"}}}}}
+)
+
+// ParseRobots parses a robots.txt file. Unknown fields and malformed lines are ignored.
+func ParseRobots(body []byte) *RobotsRules {
+	rules := &RobotsRules{}
+
+	var group *robotsGroup
+	inAgents := false // inAgents is true while reading consecutive user-agent lines
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i < 0 {
+			continue
+		}
+
+		field := strings.ToLower(strings.TrimSpace(line[:i]))
+		value := strings.TrimSpace(line[i+1:])
+
+		switch field {
+		case "user-agent":
+			if !inAgents {
+				group = &robotsGroup{}
+				rules.groups = append(rules.groups, group)
+				inAgents = true
+			}
+			group.agents = append(group.agents, strings.ToLower(value))
+			continue
+
+		case "allow", "disallow":
+			// An empty disallow rule allows everything, which is the same as not having the rule
+			if group != nil && value != "" {
+				group.rules = append(group.rules, robotsRule{allow: field == "allow", pattern: value})
+			}
+
+		case "crawl-delay":
+			if seconds, err := strconv.ParseFloat(value, 64); group != nil && err == nil && seconds >= 0 {
+				group.crawlDelay = time.Duration(seconds * float64(time.Second))
+			}
+
+		case "sitemap":
+			// Sitemaps do not belong to any group
+			rules.Sitemaps = append(rules.Sitemaps, value)
+			continue
+		}
+
+		inAgents = false
+	}
+
+	return rules
+}
+
+// groupsFor returns the groups which apply to the user agent. The groups naming the longest matching
+// product token are used, falling back to the groups for '*'.
+func (r *RobotsRules) groupsFor(userAgent string) []*robotsGroup {
+	token := strings.ToLower(userAgent)
+	if i := strings.IndexAny(token, "/ "); i >= 0 {
+		token = token[:i]
+	}
+
+	best := []*robotsGroup{}
+	bestLen := -1
+	for _, group := range r.groups {
+		for _, agent := range group.agents {
+			matchLen := -1
+			if agent == "*" {
+				matchLen = 0
+			} else if token != "" && strings.HasPrefix(token, agent) {
+				matchLen = len(agent)
+			}
+
+			if matchLen > bestLen {
+				best, bestLen = []*robotsGroup{group}, matchLen
+			} else if matchLen == bestLen && matchLen >= 0 {
+				best = append(best, group)
+			}
+		}
+	}
+
+	return best
+}
+
+// Allowed reports whether the user agent may fetch the path, which should include the query string. The
+// longest matching rule takes precedence, and an allow rule wins over a disallow rule of the same length.
+func (r *RobotsRules) Allowed(userAgent string, path string) bool {
+	if path == "" {
+		path = "/"
+	}
+
+	// The robots.txt file itself is always allowed
+	if path == "/robots.txt" {
+		return true
+	}
+
+	allowed := true
+	longest := -1
+	for _, group := range r.groupsFor(userAgent) {
+		for _, rule := range group.rules {
+			if !robotsMatch(rule.pattern, path) {
+				continue
+			}
+
+			if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+				allowed, longest = rule.allow, len(rule.pattern)
+			}
+		}
+	}
+
+	return allowed
+}
+
+// CrawlDelay returns the delay requested between successive requests from the user agent, or zero if
+// there is none
+func (r *RobotsRules) CrawlDelay(userAgent string) time.Duration {
+	var delay time.Duration
+	for _, group := range r.groupsFor(userAgent) {
+		if group.crawlDelay > delay {
+			delay = group.crawlDelay
+		}
+	}
+	return delay
+}
+
+// robotsMatch reports whether a robots.txt path pattern matches the path. Patterns match from the start
+// of the path, '*' matches any sequence of characters and a trailing '$' anchors the end of the path.
+func robotsMatch(pattern string, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+
+	for i, part := range parts[1:] {
+		// The last part must match at the very end if the pattern is anchored
+		if anchored && i == len(parts)-2 {
+			return strings.HasSuffix(rest, part)
+		}
+
+		j := strings.Index(rest, part)
+		if j < 0 {
+			return false
+		}
+		rest = rest[j+len(part):]
+	}
+
+	return !anchored || rest == ""
+}
+
+// robotsHost caches the rules for a single host. The rules are fetched once, and concurrent callers wait
+// for the first fetch to complete.
+type robotsHost struct {
+	once  sync.Once
+	rules *RobotsRules
+
+	mu   sync.Mutex
+	next time.Time // next is the earliest time the next request to the host may start
+}
+
+// RobotsPolicy decides whether URLs may be fetched based on each host's robots.txt. Files are fetched
+// through the owning instance's Driver and cached for the lifetime of the policy.
+type RobotsPolicy struct {
+	Anubis *Anubis // The owning Anubis instance
+
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+func NewRobotsPolicy(anubis *Anubis) *RobotsPolicy {
+	return &RobotsPolicy{Anubis: anubis, hosts: make(map[string]*robotsHost)}
+}
+
+// UserAgent returns the User-Agent header sent with each request, which is matched against robots.txt
+func (policy *RobotsPolicy) UserAgent() string {
+	for k, v := range policy.Anubis.Headers {
+		if strings.EqualFold(k, "User-Agent") {
+			return v
+		}
+	}
+	return ""
+}
+
+// host returns the cache entry for the URL's scheme and host, fetching its robots.txt if needed
+func (policy *RobotsPolicy) host(u *url.URL) *robotsHost {
+	key := u.Scheme + "://" + u.Host
+
+	policy.mu.Lock()
+	h, ok := policy.hosts[key]
+	if !ok {
+		h = &robotsHost{}
+		policy.hosts[key] = h
+	}
+	policy.mu.Unlock()
+
+	h.once.Do(func() {
+		h.rules = policy.fetch(key + "/robots.txt")
+	})
+
+	return h
+}
+
+func (policy *RobotsPolicy) fetch(robotsURL string) *RobotsRules {
+	resp, err := policy.Anubis.get(robotsURL)
+	if err != nil {
+		log.Println("Could not fetch", robotsURL, err)
+		return disallowAll
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		log.Println("Could not fetch", robotsURL, resp.Status)
+		return disallowAll
+	case resp.StatusCode >= 400:
+		return allowAll
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return disallowAll
+	}
+
+	return ParseRobots(body)
+}
+
+// Rules returns the rules for the URL's host, fetching robots.txt if it has not been fetched yet
+func (policy *RobotsPolicy) Rules(u *url.URL) *RobotsRules {
+	return policy.host(u).rules
+}
+
+// Allowed reports whether the URL may be fetched
+func (policy *RobotsPolicy) Allowed(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return true
+	}
+
+	return policy.Rules(u).Allowed(policy.UserAgent(), u.RequestURI())
+}
+
+// Wait blocks until the URL's host may be requested again according to its Crawl-delay, or until the
+// context is done, in which case the context's error is returned
+func (policy *RobotsPolicy) Wait(ctx context.Context, u *url.URL) error {
+	h := policy.host(u)
+
+	delay := h.rules.CrawlDelay(policy.UserAgent())
+	if delay == 0 {
+		return ctx.Err()
+	}
+
+	h.mu.Lock()
+	now := time.Now()
+	start := h.next
+	if start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(delay)
+	h.mu.Unlock()
+
+	return sleepContext(ctx, start.Sub(now))
+}
+
+// crawlDelayDriver waits for each host's Crawl-delay before passing requests to the wrapped driver
+type crawlDelayDriver struct {
+	driver WebDriver
+	policy *RobotsPolicy
+}
+
+func (driver crawlDelayDriver) DoRequest(req *http.Request) (*http.Response, error) {
+	// robots.txt itself is fetched before its Crawl-delay is known
+	if req.URL.Path == "/robots.txt" {
+		return driver.driver.DoRequest(req)
+	}
+
+	if err := driver.policy.Wait(req.Context(), req.URL); err != nil {
+		return nil, err
+	}
+	return driver.driver.DoRequest(req)
+}
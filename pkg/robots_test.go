@@ -0,0 +1,239 @@
+package anubis
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testRobots = `
+# Comments are ignored
+User-agent: *
+Disallow: /private/
+Allow: /private/public.html
+Disallow: /*.pdf$
+Crawl-delay: 1
+
+User-agent: Anubis
+User-agent: OtherBot
+Disallow: /
+Allow: /docs/
+Crawl-delay: 0.5
+
+Sitemap: https://www.test.com/sitemap.xml
+`
+
+func TestRobotsRules_Allowed(t *testing.T) {
+	rules := ParseRobots([]byte(testRobots))
+
+	tests := []struct {
+		name      string
+		userAgent string
+		path      string
+		want      bool
+	}{
+		{"Paths without a rule are allowed", "SomeBot/1.0", "/index.html", true},
+		{"Disallowed prefix", "SomeBot/1.0", "/private/secret.html", false},
+		{"Longer allow rule takes precedence", "SomeBot/1.0", "/private/public.html", true},
+		{"Wildcard with end anchor", "SomeBot/1.0", "/files/report.pdf", false},
+		{"End anchor does not match longer paths", "SomeBot/1.0", "/files/report.pdf?download=1", true},
+		{"Specific agent group replaces the '*' group", "Anubis/0.1 (+https://anubis.test)", "/index.html", false},
+		{"Agent names are case-insensitive", "anubis", "/docs/intro.html", true},
+		{"robots.txt is always allowed", "Anubis", "/robots.txt", true},
+		{"Empty user agent uses the '*' group", "", "/private/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Allowed(tt.userAgent, tt.path); got != tt.want {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRobots(t *testing.T) {
+	rules := ParseRobots([]byte(testRobots))
+
+	if want := []string{"https://www.test.com/sitemap.xml"}; !reflect.DeepEqual(rules.Sitemaps, want) {
+		t.Errorf("Sitemaps = %v, want %v", rules.Sitemaps, want)
+	}
+
+	if got := rules.CrawlDelay("Anubis"); got != 500*time.Millisecond {
+		t.Errorf("CrawlDelay() = %v, want 500ms", got)
+	}
+
+	if got := rules.CrawlDelay("SomeBot"); got != time.Second {
+		t.Errorf("CrawlDelay() = %v, want 1s", got)
+	}
+}
+
+// StaticWebDriver serves fixed responses by URL and counts the requests made for each
+type StaticWebDriver struct {
+	responses map[string]string
+	status    int
+
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+func (driver *StaticWebDriver) DoRequest(req *http.Request) (*http.Response, error) {
+	driver.mu.Lock()
+	if driver.requests == nil {
+		driver.requests = make(map[string]int)
+	}
+	driver.requests[req.URL.String()]++
+	driver.mu.Unlock()
+
+	status := driver.status
+	body, ok := driver.responses[req.URL.String()]
+	if status == 0 {
+		status = http.StatusOK
+		if !ok {
+			status = http.StatusNotFound
+		}
+	}
+
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestRobotsPolicy_Allowed(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver *StaticWebDriver
+		urls   []string
+		want   []bool
+	}{
+		{
+			name:   "Rules are applied to each URL",
+			driver: &StaticWebDriver{responses: map[string]string{"https://www.test.com/robots.txt": testRobots}},
+			urls:   []string{"https://www.test.com/", "https://www.test.com/private/a.html", "https://www.test.com/docs/"},
+			want:   []bool{false, false, true},
+		},
+		{
+			name:   "A missing robots.txt allows everything",
+			driver: &StaticWebDriver{},
+			urls:   []string{"https://www.test.com/", "https://www.test.com/private/a.html"},
+			want:   []bool{true, true},
+		},
+		{
+			name:   "A server error disallows everything",
+			driver: &StaticWebDriver{status: http.StatusServiceUnavailable},
+			urls:   []string{"https://www.test.com/"},
+			want:   []bool{false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAnubis(WebDriverOpt{tt.driver}, RobotsOpt(true), HeaderOpt{"User-Agent", "Anubis/0.1"})
+
+			got := []bool{}
+			for _, u := range tt.urls {
+				got = append(got, a.Robots.Allowed(u))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Allowed() = %v, want %v", got, tt.want)
+			}
+
+			if n := tt.driver.requests["https://www.test.com/robots.txt"]; n != 1 {
+				t.Errorf("robots.txt was fetched %v times, want 1", n)
+			}
+		})
+	}
+
+	t.Run("AddURL skips disallowed URLs", func(t *testing.T) {
+		driver := &StaticWebDriver{responses: map[string]string{"https://www.test.com/robots.txt": testRobots}}
+		a := NewAnubis(WebDriverOpt{driver}, RobotsOpt(true))
+
+		got := []bool{a.AddURL("https://www.test.com/index.html"), a.AddURL("https://www.test.com/private/a.html")}
+		if want := []bool{true, false}; !reflect.DeepEqual(got, want) {
+			t.Errorf("AddURL() = %v, want %v", got, want)
+		}
+
+		// The disallowed URL was not remembered as seen
+		if a.Filter.TestURL("https://www.test.com/private/a.html") {
+			t.Errorf("AddURL() marked a disallowed URL as seen")
+		}
+	})
+}
+
+func TestRobotsPolicy_fetchLimited(t *testing.T) {
+	driver := &StaticWebDriver{responses: map[string]string{"https://www.test.com/robots.txt": testRobots}}
+	a := NewAnubis(WebDriverOpt{driver}, RobotsOpt(true), PerHostOpt(1))
+	a.Context, a.Cancel = context.WithCancel(context.Background())
+
+	// A request in flight holds the host's only slot, so robots.txt waits for it
+	release, err := a.Limiter.Acquire(context.Background(), "www.test.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+
+	done := make(chan bool, 1)
+	go func() { done <- a.Robots.Allowed("https://www.test.com/docs/") }()
+
+	select {
+	case <-done:
+		t.Fatal("robots.txt was fetched while the host's slot was taken")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	a.Cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("robots.txt was not abandoned after the instance was cancelled")
+	}
+	if n := driver.requests["https://www.test.com/robots.txt"]; n != 0 {
+		t.Errorf("robots.txt was fetched %v times, want 0", n)
+	}
+}
+
+func TestCrawlDelayDriver_cancel(t *testing.T) {
+	driver := &StaticWebDriver{responses: map[string]string{"https://www.test.com/robots.txt": "User-agent: *\nCrawl-delay: 3600\n"}}
+	a := NewAnubis(WebDriverOpt{driver}, RobotsOpt(true))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	request := func() error {
+		req, err := http.NewRequestWithContext(ctx, "GET", "https://www.test.com/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := a.requestDriver().DoRequest(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	if err := request(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second request waits for the Crawl-delay until it is cancelled
+	done := make(chan error, 1)
+	go func() { done <- request() }()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("DoRequest() error = %v, want the context's error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("DoRequest() did not return after the context was cancelled")
+	}
+}
@@ -0,0 +1,433 @@
+package anubis
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReplayServer serves an archived output directory over HTTP. Requests are routed to a host either by a
+// /<host>/ prefix on the path, or by the Host header when the server is reached through a name which was
+// archived. Files are served with the content type and headers recorded in the manifest, and redirect
+// stubs are answered with real redirects.
+//
+// If the output directory is a git repository, earlier snapshots are served when the path starts with a
+// timestamp or commit hash, such as /2026-10-01T00:00:00Z/www.example.com/about.html. The snapshot used is
+// the last one made at or before the timestamp, or the first snapshot for earlier timestamps. Files are
+// read straight from the repository, so the working tree is not changed.
+type ReplayServer struct {
+	Root     string    // Root is the output directory being served
+	Manifest *Manifest // Manifest describes the files currently in Root
+
+	current *replaySnapshot
+	repo    *GitRepository // repo is nil if Root is not a git repository
+
+	mu        sync.Mutex
+	head      string                     // head is the commit history was read from
+	history   []GitCommit                // history is newest first
+	snapshots map[string]*replaySnapshot // snapshots caches snapshots by commit hash
+}
+
+// replaySnapshot is the archive as it was at one point in time
+type replaySnapshot struct {
+	files    fs.FS
+	manifest *Manifest
+	byPath   map[string]ManifestEntry // byPath finds the entry for a file requested by its path
+}
+
+func newReplaySnapshot(files fs.FS) (*replaySnapshot, error) {
+	manifest := NewManifest()
+	if data, err := fs.ReadFile(files, ManifestFile); err == nil {
+		if manifest, err = ParseManifest(data); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	snapshot := &replaySnapshot{files: files, manifest: manifest, byPath: make(map[string]ManifestEntry)}
+	for _, entry := range manifest.Entries() {
+		snapshot.byPath[entry.Path] = entry
+	}
+	return snapshot, nil
+}
+
+// NewReplayServer creates a server for the output directory, reading its stored manifest
+func NewReplayServer(root string) (*ReplayServer, error) {
+	current, err := newReplaySnapshot(os.DirFS(root))
+	if err != nil {
+		return nil, err
+	}
+
+	server := &ReplayServer{
+		Root:      root,
+		Manifest:  current.manifest,
+		current:   current,
+		snapshots: make(map[string]*replaySnapshot),
+	}
+
+	if repo, err := OpenGitRepository(root); err == nil {
+		server.repo = repo
+	}
+	return server, nil
+}
+
+// Snapshots returns the commits which can be replayed, newest first
+func (server *ReplayServer) Snapshots() ([]GitCommit, error) {
+	if server.repo == nil {
+		return nil, nil
+	}
+
+	head, err := server.repo.ResolveRef("HEAD")
+	if err != nil {
+		return nil, nil
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// The history only needs to be read again after another commit
+	if head != server.head {
+		history, err := server.repo.History()
+		if err != nil {
+			return nil, err
+		}
+		server.head, server.history = head, history
+	}
+	return server.history, nil
+}
+
+// snapshotAt returns the commit to replay for a timestamp or commit hash
+func (server *ReplayServer) snapshotAt(s string) (GitCommit, bool) {
+	history, err := server.Snapshots()
+	if err != nil || len(history) == 0 {
+		return GitCommit{}, false
+	}
+
+	// Only timestamps and hashes are accepted, so that ref names cannot hide archived paths
+	if _, ok := parseSnapshotTime(s); !ok && (len(s) < 4 || len(s) > 40 || !isHexString(s)) {
+		return GitCommit{}, false
+	}
+
+	commit, err := server.repo.ResolveRevision(s)
+	return commit, err == nil
+}
+
+func (server *ReplayServer) snapshot(commit GitCommit) (*replaySnapshot, error) {
+	server.mu.Lock()
+	snapshot, ok := server.snapshots[commit.Hash]
+	server.mu.Unlock()
+	if ok {
+		return snapshot, nil
+	}
+
+	snapshot, err := newReplaySnapshot(gitTreeFS{repo: server.repo, tree: commit.Tree, modTime: commit.Time})
+	if err != nil {
+		return nil, err
+	}
+
+	server.mu.Lock()
+	server.snapshots[commit.Hash] = snapshot
+	server.mu.Unlock()
+	return snapshot, nil
+}
+
+// snapshotLayouts are the accepted timestamp formats, from RFC 3339 to the digits used by the Wayback Machine
+var snapshotLayouts = []string{time.RFC3339, "20060102150405", "200601021504", "2006010215", "20060102", "2006-01-02"}
+
+func parseSnapshotTime(s string) (time.Time, bool) {
+	for _, layout := range snapshotLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func isHexString(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", rune(s[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func (server *ReplayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	snapshot, prefix, escaped := server.current, "", r.URL.EscapedPath()
+
+	// A leading timestamp or hash selects a snapshot, unless it is the name of an archived host
+	if first, rest := splitFirstSegment(escaped); server.repo != nil && first != "" && !snapshot.isHost(first) {
+		if commit, ok := server.snapshotAt(first); ok {
+			var err error
+			if snapshot, err = server.snapshot(commit); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			prefix, escaped = "/"+url.PathEscape(first), rest
+		}
+	}
+
+	snapshot.serve(w, r, prefix, escaped, server)
+}
+
+// splitFirstSegment splits an escaped path into its unescaped first segment and the escaped remainder,
+// which starts with a slash unless it is empty
+func splitFirstSegment(escaped string) (string, string) {
+	first, rest := strings.TrimPrefix(escaped, "/"), ""
+	if i := strings.IndexByte(first, '/'); i >= 0 {
+		first, rest = first[:i], first[i:]
+	}
+
+	if name, err := url.PathUnescape(first); err == nil {
+		return name, rest
+	}
+	return first, rest
+}
+
+// serve answers a request for the escaped path within the snapshot. The prefix selected the snapshot,
+// and is kept on redirects and links.
+func (snapshot *replaySnapshot) serve(w http.ResponseWriter, r *http.Request, prefix string, escaped string, server *ReplayServer) {
+	host, escapedPath, prefixed := snapshot.route(r.Host, escaped)
+	if host == "" {
+		snapshot.serveIndex(w, prefix, server)
+		return
+	}
+
+	// A bare /<host> prefix needs a trailing slash for relative links to resolve
+	if prefixed && escapedPath == "" {
+		http.Redirect(w, r, prefix+"/"+url.PathEscape(host)+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	target := &url.URL{Scheme: "https", Host: host, RawQuery: r.URL.RawQuery}
+	if p, err := url.PathUnescape(escapedPath); err == nil {
+		target.Path, target.RawPath = p, escapedPath
+	}
+
+	for _, scheme := range []string{"https", "http"} {
+		target.Scheme = scheme
+		entry, ok := snapshot.manifest.Get(target.String())
+		if !ok {
+			continue
+		}
+
+		if entry.RedirectTo != "" {
+			status := entry.Status
+			if !isRedirect(status) {
+				status = http.StatusFound
+			}
+			http.Redirect(w, r, replayURL(entry.RedirectTo, prefix, host, prefixed), status)
+			return
+		}
+
+		snapshot.serveFile(w, r, entry.Path, entry)
+		return
+	}
+
+	// Links rewritten to local paths request files directly, while other URLs are found by mapping them
+	// as they would have been when archived
+	candidates := []string{path.Join(sanitizeComponent(strings.ToLower(host)), target.Path)}
+	if strings.HasSuffix(target.Path, "/") || target.Path == "" {
+		candidates[0] = path.Join(candidates[0], "index.html")
+	}
+	candidates = append(candidates, DefaultPathMapper{}.MapPath(target, ""))
+
+	for _, p := range candidates {
+		if info, err := fs.Stat(snapshot.files, fsPath(p)); err == nil && !info.IsDir() {
+			snapshot.serveFile(w, r, p, snapshot.byPath[p])
+			return
+		}
+	}
+
+	target.Scheme = "https"
+	serveNotArchived(w, target.String())
+}
+
+// route finds the host a request is for, and the escaped path of the archived URL. The path prefix takes
+// precedence over the Host header, so an archive can be browsed from any address.
+func (snapshot *replaySnapshot) route(requestHost string, escaped string) (host string, escapedPath string, prefixed bool) {
+	if name, rest := splitFirstSegment(escaped); name != "" && snapshot.isHost(name) {
+		return name, rest, true
+	}
+
+	// The port is ignored unless the archive has a directory for it
+	for _, name := range []string{requestHost, strings.Split(requestHost, ":")[0]} {
+		if snapshot.isHost(name) {
+			return name, escaped, false
+		}
+	}
+
+	return "", "", false
+}
+
+// Hosts returns the archived hosts, which are the directories of the output directory other than those
+// used by Anubis itself
+func (server *ReplayServer) Hosts() []string {
+	return server.current.hosts()
+}
+
+func (snapshot *replaySnapshot) hosts() []string {
+	dirs, err := fs.ReadDir(snapshot.files, ".")
+	if err != nil {
+		return nil
+	}
+
+	hosts := []string{}
+	for _, dir := range dirs {
+		if !dir.IsDir() || !isHostDir(dir.Name()) {
+			continue
+		}
+
+		// Directory names are sanitized, so characters such as the ':' before a port are encoded
+		if host, err := url.PathUnescape(dir.Name()); err == nil {
+			hosts = append(hosts, host)
+		}
+	}
+
+	sort.Strings(hosts)
+	return hosts
+}
+
+// isHost reports whether the name is an archived host. Host directories are named by the PathMapper, so
+// the name is sanitized the same way before looking for it.
+func (snapshot *replaySnapshot) isHost(name string) bool {
+	dir := sanitizeComponent(strings.ToLower(name))
+	if !isHostDir(dir) {
+		return false
+	}
+
+	info, err := fs.Stat(snapshot.files, dir)
+	return err == nil && info.IsDir()
+}
+
+// isHostDir reports whether a directory of the output could hold a host, rather than the manifest, the
+// git repository or saved errors
+func isHostDir(dir string) bool {
+	return dir != "" && !strings.HasPrefix(dir, ".") && dir != ErrorsDir
+}
+
+// replayURL returns the address of an archived URL on this server. URLs on the host being browsed by
+// its Host header stay on it, and everything else uses the path prefix.
+func replayURL(rawURL string, prefix string, host string, prefixed bool) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	if !prefixed && strings.EqualFold(u.Host, host) {
+		return prefix + u.RequestURI()
+	}
+	return prefix + "/" + url.PathEscape(u.Host) + u.RequestURI()
+}
+
+// fsPath converts a path relative to the output directory to a valid fs.FS path. Cleaning it first keeps
+// it inside the root.
+func fsPath(p string) string {
+	if p = strings.TrimPrefix(path.Clean("/"+p), "/"); p == "" {
+		return "."
+	}
+	return p
+}
+
+// serveFile writes an archived file using the content type and headers from its manifest entry
+func (snapshot *replaySnapshot) serveFile(w http.ResponseWriter, r *http.Request, p string, entry ManifestEntry) {
+	f, err := snapshot.files.Open(fsPath(p))
+	if err != nil {
+		serveNotArchived(w, entry.URL)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		data, err := ioutil.ReadAll(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+
+	for k, v := range entry.Header {
+		w.Header()[k] = v
+	}
+	if entry.ContentType != "" {
+		w.Header().Set("Content-Type", entry.ContentType)
+	}
+
+	// Archived error pages are replayed with their original status
+	if entry.Status != 0 && entry.Status != http.StatusOK {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		w.WriteHeader(entry.Status)
+		if r.Method != http.MethodHead {
+			io.Copy(w, content)
+		}
+		return
+	}
+
+	http.ServeContent(w, r, path.Base(p), info.ModTime(), content)
+}
+
+// serveIndex lists the hosts in the snapshot, and the snapshots which can be replayed
+func (snapshot *replaySnapshot) serveIndex(w http.ResponseWriter, prefix string, server *ReplayServer) {
+	b := strings.Builder{}
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>Archived hosts</title></head>\n<body>\n<h1>Archived hosts</h1>\n<ul>\n")
+	for _, host := range snapshot.hosts() {
+		b.WriteString(`<li><a href="` + html.EscapeString(prefix+"/"+url.PathEscape(host)) + `/">` + html.EscapeString(host) + "</a></li>\n")
+	}
+	b.WriteString("</ul>\n")
+
+	if history, err := server.Snapshots(); err == nil && len(history) > 0 {
+		b.WriteString("<h2>Snapshots</h2>\n<ul>\n")
+		for _, commit := range history {
+			stamp := commit.Time.UTC().Format("20060102150405")
+			b.WriteString(`<li><a href="/` + stamp + `/">` + commit.Time.UTC().Format(time.RFC3339) + "</a> " + commit.Hash[:7] + "</li>\n")
+		}
+		b.WriteString("</ul>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+func serveNotArchived(w http.ResponseWriter, rawURL string) {
+	u := html.EscapeString(rawURL)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	io.WriteString(w, `<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Not archived</title></head>
+<body>
+<h1>Not archived</h1>
+<p>`+u+` is not in this archive.</p>
+<p><a href="`+u+`">Open the live page</a></p>
+</body>
+</html>
+`)
+}
@@ -0,0 +1,203 @@
+package anubis
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestReplayServer(t *testing.T) *ReplayServer {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+
+	files := []struct {
+		entry ManifestEntry
+		body  string
+	}{
+		{ManifestEntry{URL: "https://www.test.com/", Path: "www.test.com/index.html", ContentType: "text/html; charset=utf-8", Status: 200}, "home"},
+		{ManifestEntry{URL: "https://www.test.com/data", Path: "www.test.com/data.json", ContentType: "application/json", Status: 200,
+			Header: http.Header{"Content-Language": []string{"en"}}}, "{}"},
+		{ManifestEntry{URL: "https://www.test.com/list?page=2", Path: "www.test.com/list%3Fpage=2.html", ContentType: "text/html", Status: 200}, "page 2"},
+		{ManifestEntry{URL: "https://www.test.com/old", Path: "www.test.com/old.html", ContentType: "text/html", Status: 301,
+			RedirectTo: "https://www.test.com/data"}, "stub"},
+		{ManifestEntry{URL: "https://www.test.com/gone", Path: "www.test.com/gone.html", ContentType: "text/html", Status: 410}, "gone"},
+	}
+	for _, f := range files {
+		if err := a.writeOutput(f.entry.Path, []byte(f.body)); err != nil {
+			t.Fatal(err)
+		}
+		a.Manifest.Record(f.entry)
+	}
+
+	// An unrecorded file is still served by its path
+	if err := a.writeOutput("www.test.com/css/style.css", []byte("body {}")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.SaveManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewReplayServer(a.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestReplayServer_ServeHTTP(t *testing.T) {
+	server := newTestReplayServer(t)
+
+	tests := []struct {
+		name         string
+		host         string
+		target       string
+		wantStatus   int
+		wantType     string
+		wantBody     string
+		wantLocation string
+	}{
+		{"Hosts are routed by prefix", "localhost:8080", "/www.test.com/", 200, "text/html; charset=utf-8", "home", ""},
+		{"Hosts are routed by the Host header", "www.test.com:8080", "/", 200, "text/html; charset=utf-8", "home", ""},
+		{"The stored content type is restored", "localhost", "/www.test.com/data", 200, "application/json", "{}", ""},
+		{"Query strings find their own file", "localhost", "/www.test.com/list?page=2", 200, "text/html", "page 2", ""},
+		{"Rewritten links request files directly", "localhost", "/www.test.com/list%253Fpage=2.html", 200, "text/html", "page 2", ""},
+		{"Unrecorded files are served by path", "localhost", "/www.test.com/css/style.css", 200, "text/css; charset=utf-8", "body {}", ""},
+		{"Redirects are replayed", "localhost", "/www.test.com/old", 301, "", "", "/www.test.com/data"},
+		{"Redirects stay on the Host", "www.test.com", "/old", 301, "", "", "/data"},
+		{"Archived statuses are replayed", "localhost", "/www.test.com/gone", 410, "text/html", "gone", ""},
+		{"A bare prefix gains a slash", "localhost", "/www.test.com", 301, "", "", "/www.test.com/"},
+		{"Missing resources are not archived", "localhost", "/www.test.com/missing.png", 404, "text/html; charset=utf-8", "https://www.test.com/missing.png is not in this archive", ""},
+		{"The manifest is not a host", "localhost", "/.anubis/manifest.json", 200, "text/html; charset=utf-8", "Archived hosts", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+
+			server.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if tt.wantType != "" && rec.Header().Get("Content-Type") != tt.wantType {
+				t.Errorf("Content-Type = %v, want %v", rec.Header().Get("Content-Type"), tt.wantType)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %v, want %v", rec.Body.String(), tt.wantBody)
+			}
+			if rec.Header().Get("Location") != tt.wantLocation {
+				t.Errorf("Location = %v, want %v", rec.Header().Get("Location"), tt.wantLocation)
+			}
+		})
+	}
+
+	// Stored headers are restored along with the content type
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest("GET", "/www.test.com/data", nil))
+	if got := rec.Header().Get("Content-Language"); got != "en" {
+		t.Errorf("Content-Language = %v, want en", got)
+	}
+}
+
+func TestAnubis_SaveManifest(t *testing.T) {
+	dir := t.TempDir()
+
+	// The first run archives two files, one of which is removed by the second run
+	first := NewAnubis(OutputOpt(dir))
+	for _, p := range []string{"www.test.com/a.html", "www.test.com/b.html"} {
+		if err := first.writeOutput(p, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		first.Manifest.Record(ManifestEntry{URL: "https://" + strings.TrimSuffix(p, ".html"), Path: p, ContentType: "text/html"})
+	}
+	if err := first.SaveManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	second := NewAnubis(OutputOpt(dir))
+	if err := second.writeOutput("www.test.com/c.html", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	second.Manifest.Record(ManifestEntry{URL: "https://www.test.com/c", Path: "www.test.com/c.html", ContentType: "text/html"})
+	if err := os.Remove(second.outputPath("www.test.com/b.html")); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.SaveManifest(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	for _, entry := range m.Entries() {
+		got = append(got, entry.URL)
+	}
+	if want := "https://www.test.com/a https://www.test.com/c"; strings.Join(got, " ") != want {
+		t.Errorf("LoadManifest() = %v, want %v", got, want)
+	}
+
+	data, _ := ioutil.ReadFile(second.outputPath(ManifestFile))
+	if !strings.Contains(string(data), `"content_type": "text/html"`) {
+		t.Errorf("stored manifest = %s", data)
+	}
+}
+
+func TestReplayServer_ServeHTTP_snapshots(t *testing.T) {
+	manifest := `[{"url": "https://www.test.com/", "path": "www.test.com/index.html", "content_type": "text/html", "status": 200}]`
+	dir := gitTestRepo(t, []map[string]string{
+		{"www.test.com/index.html": "september", ManifestFile: manifest},
+		{"www.test.com/index.html": "october"},
+	}, []string{"2026-09-01T00:00:00Z", "2026-10-01T00:00:00Z"})
+
+	// The working tree has moved on since the last snapshot
+	if err := os.WriteFile(filepath.Join(dir, "www.test.com", "index.html"), []byte("today"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server, err := NewReplayServer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := server.Snapshots()
+	if err != nil || len(history) != 2 {
+		t.Fatalf("Snapshots() = %v, %v, want 2 snapshots", history, err)
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"Without a timestamp the working tree is served", "/www.test.com/", 200, "today"},
+		{"The snapshot at the time is served", "/2026-10-01T00:00:00Z/www.test.com/", 200, "october"},
+		{"The last snapshot before the time is served", "/20260915/www.test.com/", 200, "september"},
+		{"Times before the first snapshot use the first", "/2020-01-01/www.test.com/", 200, "september"},
+		{"Snapshots are found by hash", "/" + history[1].Hash[:8] + "/www.test.com/", 200, "september"},
+		{"Files are read from the snapshot", "/20261001/www.test.com/index.html", 200, "october"},
+		{"Missing files are not archived", "/20261001/www.test.com/missing.html", 404, "not in this archive"},
+		{"The snapshot index keeps the timestamp", "/20261001/", 200, `href="/20261001/www.test.com/"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest("GET", tt.target, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %v, want %v", rec.Code, tt.wantStatus)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("body = %v, want %v", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package anubis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DiscoverSitemaps is the value of SitemapOpt which discovers sitemaps from each start URL's robots.txt
+const DiscoverSitemaps = "robots"
+
+// maxSitemapSize is the largest uncompressed sitemap allowed by the sitemap protocol
+const maxSitemapSize = 50 * 1024 * 1024
+
+// sitemapDocument matches both <urlset> and <sitemapindex> documents
+type sitemapDocument struct {
+	URLs     []SitemapEntry `xml:"url"`
+	Sitemaps []SitemapEntry `xml:"sitemap"`
+}
+
+// SitemapEntry is a page or nested sitemap listed in a sitemap
+type SitemapEntry struct {
+	Loc     string `xml:"loc"`     // Loc is the URL of the page or sitemap
+	LastMod string `xml:"lastmod"` // LastMod is when it was last modified, in W3C datetime format
+}
+
+// modifiedSince reports whether the entry was modified after the given time. Entries without a valid
+// lastmod are always considered modified.
+func (entry SitemapEntry) modifiedSince(since time.Time) bool {
+	if since.IsZero() || entry.LastMod == "" {
+		return true
+	}
+
+	// W3C datetime allows a date alone, or a date and time with or without seconds
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00", "2006-01-02"} {
+		if t, err := time.Parse(layout, strings.TrimSpace(entry.LastMod)); err == nil {
+			return t.After(since)
+		}
+	}
+
+	return true
+}
+
+// ParseSitemap parses a sitemap or sitemap index, which may be gzipped. It returns the page entries and
+// the nested sitemap entries separately.
+func ParseSitemap(body []byte) (pages []SitemapEntry, sitemaps []SitemapEntry, err error) {
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		defer zr.Close()
+
+		if body, err = ioutil.ReadAll(io.LimitReader(zr, maxSitemapSize)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	doc := sitemapDocument{}
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	return doc.URLs, doc.Sitemaps, nil
+}
+
+// AddSitemap fetches a sitemap, or a sitemap index and every sitemap it lists, and adds each page to the
+// queue using AddURL. Pages with a lastmod before SitemapSince are skipped. The number of pages added is
+// returned.
+func (a *Anubis) AddSitemap(sitemapURL string) (int, error) {
+	return a.addSitemap(sitemapURL, map[string]bool{})
+}
+
+func (a *Anubis) addSitemap(sitemapURL string, visited map[string]bool) (int, error) {
+	if visited[sitemapURL] {
+		return 0, nil
+	}
+	visited[sitemapURL] = true
+
+	body, err := a.fetchSitemap(sitemapURL)
+	if err != nil {
+		return 0, err
+	}
+
+	pages, sitemaps, err := ParseSitemap(body)
+	if err != nil {
+		return 0, errors.New("Could not parse sitemap " + sitemapURL + ": " + err.Error())
+	}
+
+	added := 0
+	for _, page := range pages {
+		if loc := strings.TrimSpace(page.Loc); loc != "" && page.modifiedSince(a.SitemapSince) && a.AddURL(loc) {
+			added++
+		}
+	}
+
+	// A nested sitemap which was not modified cannot contain modified pages
+	for _, sitemap := range sitemaps {
+		loc := strings.TrimSpace(sitemap.Loc)
+		if loc == "" || !sitemap.modifiedSince(a.SitemapSince) {
+			continue
+		}
+
+		n, err := a.addSitemap(loc, visited)
+		if err != nil {
+			log.Println(err)
+		}
+		added += n
+	}
+
+	return added, nil
+}
+
+func (a *Anubis) fetchSitemap(sitemapURL string) ([]byte, error) {
+	resp, err := a.get(sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.New("Could not fetch sitemap " + sitemapURL + ": " + resp.Status)
+	}
+
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxSitemapSize))
+}
+
+// sitemapURLs returns the sitemaps to read for the instance's Sitemaps setting. DiscoverSitemaps is
+// expanded to the sitemaps declared in the robots.txt of each start URL's host, or /sitemap.xml if the
+// host does not declare any.
+func (a *Anubis) sitemapURLs() []string {
+	urls := []string{}
+	seenHosts := map[string]bool{}
+
+	for _, sitemap := range a.Sitemaps {
+		if sitemap != DiscoverSitemaps {
+			urls = append(urls, sitemap)
+			continue
+		}
+
+		policy := a.Robots
+		if policy == nil {
+			policy = NewRobotsPolicy(a)
+		}
+
+		for _, scope := range a.crawl.startScopes() {
+			root := url.URL{Scheme: scope.Scheme, Host: scope.Host}
+			if seenHosts[root.String()] {
+				continue
+			}
+			seenHosts[root.String()] = true
+
+			declared := policy.Rules(&root).Sitemaps
+			if len(declared) == 0 {
+				declared = []string{root.String() + "/sitemap.xml"}
+			}
+			urls = append(urls, declared...)
+		}
+	}
+
+	return urls
+}
+
+// addSitemaps adds the pages of every configured sitemap to the queue
+func (a *Anubis) addSitemaps() {
+	for _, sitemap := range a.sitemapURLs() {
+		n, err := a.AddSitemap(sitemap)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Println("Added", n, "pages from", sitemap)
+	}
+}
@@ -0,0 +1,115 @@
+package anubis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testSitemapIndex = `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://www.test.com/pages.xml.gz</loc><lastmod>2026-10-01</lastmod></sitemap>
+  <sitemap><loc>https://www.test.com/old.xml</loc><lastmod>2020-01-01</lastmod></sitemap>
+</sitemapindex>`
+
+const testSitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> https://www.test.com/new.html </loc><lastmod>2026-10-01T12:00:00+00:00</lastmod></url>
+  <url><loc>https://www.test.com/stale.html</loc><lastmod>2021-05-04</lastmod></url>
+  <url><loc>https://www.test.com/undated.html</loc></url>
+</urlset>`
+
+const testOldSitemap = `<urlset><url><loc>https://www.test.com/archive.html</loc></url></urlset>`
+
+func gzipString(t *testing.T, s string) string {
+	buf := bytes.Buffer{}
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestAnubis_AddSitemap(t *testing.T) {
+	tests := []struct {
+		name  string
+		since time.Time
+		want  []string
+	}{
+		{
+			name: "Every page of every nested sitemap is added",
+			want: []string{
+				"https://www.test.com/archive.html",
+				"https://www.test.com/new.html",
+				"https://www.test.com/stale.html",
+				"https://www.test.com/undated.html",
+			},
+		},
+		{
+			name:  "Pages and sitemaps modified before SitemapSince are skipped",
+			since: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			want:  []string{"https://www.test.com/new.html", "https://www.test.com/undated.html"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			driver := &StaticWebDriver{responses: map[string]string{
+				"https://www.test.com/sitemap.xml":  testSitemapIndex,
+				"https://www.test.com/pages.xml.gz": gzipString(t, testSitemap),
+				"https://www.test.com/old.xml":      testOldSitemap,
+			}}
+
+			a := NewTestAnubis()
+			a.Driver = driver
+			a.SitemapSince = tt.since
+			processor := a.processor.(*StringProcessor)
+
+			n, err := a.AddSitemap("https://www.test.com/sitemap.xml")
+			if err != nil {
+				t.Fatalf("AddSitemap() error = %v", err)
+			}
+
+			if n != len(tt.want) {
+				t.Errorf("AddSitemap() = %v, want %v", n, len(tt.want))
+			}
+
+			a.Start()
+			a.Wait()
+
+			got := append([]string{}, processor.results...)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("processed %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnubis_sitemapURLs(t *testing.T) {
+	driver := &StaticWebDriver{responses: map[string]string{
+		"https://www.test.com/robots.txt": "Sitemap: https://www.test.com/a.xml\nSitemap: https://www.test.com/b.xml\n",
+	}}
+
+	a := NewAnubis(WebDriverOpt{driver}, SitemapOpt(DiscoverSitemaps), SitemapOpt("https://other.com/sitemap.xml"))
+	a.processor = &StringProcessor{mu: &sync.Mutex{}}
+	a.AddStartURL("https://www.test.com/")
+	a.AddStartURL("https://www.test.com/docs/")
+	a.AddStartURL("https://undeclared.com/")
+
+	want := []string{
+		"https://www.test.com/a.xml",
+		"https://www.test.com/b.xml",
+		"https://undeclared.com/sitemap.xml",
+		"https://other.com/sitemap.xml",
+	}
+	if got := a.sitemapURLs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("sitemapURLs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,472 @@
+package anubis
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SnapshotsDir is the directory within the output where snapshot directories and archives are kept, unless
+// another directory is configured
+const SnapshotsDir = ".snapshots"
+
+// SnapshotMessageFile is the file within each snapshot directory or archive which holds the message
+const SnapshotMessageFile = ".anubis/message.txt"
+
+// snapshotLayout names each snapshot by the UTC time it was taken, which sorts by age
+const snapshotLayout = "20060102150405"
+
+// snapshotDir returns the directory snapshots of the output directory are kept in
+func snapshotDir(dir string, configured string) string {
+	if configured == "" {
+		return filepath.Join(dir, SnapshotsDir)
+	}
+	return configured
+}
+
+// snapshotMessage prefixes the message with the author and time, since snapshots have no other metadata
+func snapshotMessage(message string, author GitIdentity, when time.Time) []byte {
+	if author.Name == "" || author.Email == "" {
+		author = DefaultGitIdentity
+	}
+	return []byte(fmt.Sprintf("Author: %v <%v>\nDate: %v\n\n%v", author.Name, author.Email, when.Format(time.RFC3339), message))
+}
+
+// outputFiles returns the slash-separated path of every regular file and symbolic link in the output
+// directory, sorted. The .git directory, the snapshot directory if it is within the output, and any
+// snapshot message are skipped.
+func outputFiles(dir string, snapshots string) ([]string, error) {
+	skip := map[string]bool{".git": true, SnapshotMessageFile: true}
+	if abs, err := filepath.Abs(dir); err == nil && snapshots != "" {
+		if absSnapshots, err := filepath.Abs(snapshots); err == nil {
+			if rel, err := filepath.Rel(abs, absSnapshots); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+				skip[filepath.ToSlash(rel)] = true
+			}
+		}
+	}
+
+	files := []string{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		switch {
+		case skip[rel] && d.IsDir():
+			return filepath.SkipDir
+		case skip[rel]:
+			return nil
+		case d.Type().IsRegular(), d.Type()&fs.ModeSymlink != 0:
+			files = append(files, rel)
+		}
+		return nil
+	})
+
+	sort.Strings(files)
+	return files, err
+}
+
+// previousSnapshot returns the name of the newest entry in the snapshot directory with the suffix, or an
+// empty string if there are none
+func previousSnapshot(snapshots string, suffix string) string {
+	entries, err := os.ReadDir(snapshots)
+	if err != nil {
+		return ""
+	}
+
+	previous := ""
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, suffix) || entry.IsDir() != (suffix == "") {
+			continue
+		}
+		if name > previous {
+			previous = name
+		}
+	}
+	return previous
+}
+
+// snapshotName returns an unused name for a snapshot taken at the time
+func snapshotName(snapshots string, when time.Time, prefix string, suffix string) string {
+	base := prefix + when.UTC().Format(snapshotLayout)
+	name := base + suffix
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(filepath.Join(snapshots, name)); os.IsNotExist(err) {
+			return name
+		}
+		name = base + "-" + strconv.Itoa(i) + suffix
+	}
+}
+
+// LinkCommitter copies the output directory into a new directory named by the time of each snapshot, in
+// the way rsync --link-dest does: files which are unchanged since the previous snapshot are hard links to
+// it, so they only take space once. Files are unchanged if their size and modification time match, or
+// failing that, their contents. The message is saved in each snapshot as
+// SnapshotMessageFile.
+type LinkCommitter struct {
+	Dir string           // Dir holds the snapshot directories. It defaults to SnapshotsDir within the output
+	Now func() time.Time // Now returns the time of each snapshot. It defaults to time.Now
+}
+
+func (committer LinkCommitter) Commit(dir string, message string, author GitIdentity) error {
+	snapshots := snapshotDir(dir, committer.Dir)
+	if err := os.MkdirAll(snapshots, 0775); err != nil {
+		return err
+	}
+
+	now := time.Now
+	if committer.Now != nil {
+		now = committer.Now
+	}
+	when := now()
+
+	files, err := outputFiles(dir, snapshots)
+	if err != nil {
+		return err
+	}
+
+	previous := ""
+	if name := previousSnapshot(snapshots, ""); name != "" {
+		previous = filepath.Join(snapshots, name)
+	}
+
+	// The snapshot is built under a hidden name, so a partial snapshot is never mistaken for a finished one
+	name := snapshotName(snapshots, when, "", "")
+	tmp := filepath.Join(snapshots, "."+name+".tmp")
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+
+	changed := previous == ""
+	for _, file := range files {
+		linked, err := linkOrCopy(filepath.Join(dir, filepath.FromSlash(file)), previous, file, filepath.Join(tmp, filepath.FromSlash(file)))
+		if err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+		changed = changed || !linked
+	}
+
+	// Files which were removed from the output also count as changes
+	if !changed {
+		previousFiles, err := outputFiles(previous, "")
+		if err != nil {
+			os.RemoveAll(tmp)
+			return err
+		}
+		changed = len(previousFiles) != len(files)
+	}
+
+	if !changed {
+		return os.RemoveAll(tmp)
+	}
+
+	p := filepath.Join(tmp, filepath.FromSlash(SnapshotMessageFile))
+	if err := os.MkdirAll(filepath.Dir(p), 0775); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p, snapshotMessage(message, author, when), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(snapshots, name))
+}
+
+// linkOrCopy hard links the file from the previous snapshot if it is unchanged, and copies it from the
+// output otherwise. Output files are overwritten in place, so they are
+// never linked themselves. It reports whether the file was linked.
+func linkOrCopy(src string, previous string, file string, dst string) (bool, error) {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0775); err != nil {
+		return false, err
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return false, err
+		}
+		old, err := os.Readlink(filepath.Join(previous, filepath.FromSlash(file)))
+		return previous != "" && err == nil && old == target, os.Symlink(target, dst)
+	}
+
+	if previous != "" {
+		old := filepath.Join(previous, filepath.FromSlash(file))
+		if prev, err := os.Lstat(old); err == nil && prev.Mode() == info.Mode() && prev.Size() == info.Size() &&
+			(prev.ModTime().Equal(info.ModTime()) || sameContents(old, src)) {
+			if err := os.Link(old, dst); err == nil {
+				return true, nil
+			}
+		}
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return false, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return false, err
+	}
+	if err := out.Close(); err != nil {
+		return false, err
+	}
+
+	// The modification time is kept, so the next snapshot can tell whether the file changed
+	return false, os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// sameContents reports whether two files have the same contents
+func sameContents(a string, b string) bool {
+	dataA, err := os.ReadFile(a)
+	if err != nil {
+		return false
+	}
+	dataB, err := os.ReadFile(b)
+	return err == nil && bytes.Equal(dataA, dataB)
+}
+
+// TarCommitter writes the output directory to a gzip-compressed tar archive named by the time of each
+// snapshot. The message is the first file in each archive, as SnapshotMessageFile. A digest of the
+// archived files is kept in the gzip header, so no archive is written if nothing changed since the
+// previous one.
+type TarCommitter struct {
+	Dir string           // Dir holds the archives. It defaults to SnapshotsDir within the output
+	Now func() time.Time // Now returns the time of each snapshot. It defaults to time.Now
+}
+
+const (
+	tarPrefix       = "anubis-"
+	tarSuffix       = ".tar.gz"
+	tarDigestPrefix = "anubis-files-sha256:"
+)
+
+func (committer TarCommitter) Commit(dir string, message string, author GitIdentity) error {
+	snapshots := snapshotDir(dir, committer.Dir)
+	if err := os.MkdirAll(snapshots, 0775); err != nil {
+		return err
+	}
+
+	now := time.Now
+	if committer.Now != nil {
+		now = committer.Now
+	}
+	when := now()
+
+	files, err := outputFiles(dir, snapshots)
+	if err != nil {
+		return err
+	}
+
+	digest, err := filesDigest(dir, files)
+	if err != nil {
+		return err
+	}
+	if name := previousSnapshot(snapshots, tarSuffix); name != "" && tarDigest(filepath.Join(snapshots, name)) == digest {
+		return nil
+	}
+
+	name := snapshotName(snapshots, when, tarPrefix, tarSuffix)
+	tmp := filepath.Join(snapshots, "."+name+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	if err := writeTar(f, dir, files, snapshotMessage(message, author, when), when, digest); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(snapshots, name))
+}
+
+// filesDigest hashes the path, mode and contents of every file
+func filesDigest(dir string, files []string) (string, error) {
+	h := sha256.New()
+	for _, file := range files {
+		p := filepath.Join(dir, filepath.FromSlash(file))
+		info, err := os.Lstat(p)
+		if err != nil {
+			return "", err
+		}
+
+		var data []byte
+		if info.Mode()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(p)
+			if err != nil {
+				return "", err
+			}
+			data = []byte(target)
+		} else if data, err = os.ReadFile(p); err != nil {
+			return "", err
+		}
+
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%v\x00%o\x00%x\n", file, info.Mode(), sum)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// tarDigest returns the digest recorded in an archive's gzip header, or an empty string if there is none
+func tarDigest(p string) string {
+	f, err := os.Open(p)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return ""
+	}
+	defer zr.Close()
+
+	if !strings.HasPrefix(zr.Comment, tarDigestPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(zr.Comment, tarDigestPrefix)
+}
+
+func writeTar(w io.Writer, dir string, files []string, message []byte, when time.Time, digest string) error {
+	zw := gzip.NewWriter(w)
+	zw.Comment = tarDigestPrefix + digest
+	zw.ModTime = when
+	tw := tar.NewWriter(zw)
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     SnapshotMessageFile,
+		Mode:     0644,
+		Size:     int64(len(message)),
+		ModTime:  when,
+	}); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, bytes.NewReader(message)); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		p := filepath.Join(dir, filepath.FromSlash(file))
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = file
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// LastCommitTime returns the time of the most recent commit in the git repository at dir, or the zero time
+// if there is no repository or it has no commits
+func LastCommitTime(dir string) time.Time {
+	repo, err := OpenGitRepository(dir)
+	if err != nil {
+		return time.Time{}
+	}
+
+	head, err := repo.ResolveRef("HEAD")
+	if err != nil {
+		return time.Time{}
+	}
+
+	commit, err := repo.ReadCommit(head)
+	if err != nil {
+		return time.Time{}
+	}
+	return commit.Time
+}
+
+// LastSnapshotTime returns the time of the newest snapshot the committer recorded for the output directory
+// dir, or the zero time if there is none. The git committers read the time of the last commit, while the
+// links and tar committers read the time in the name of the newest snapshot. Other committers are an
+// error, since their snapshots cannot be found.
+func LastSnapshotTime(committer Committer, dir string) (time.Time, error) {
+	switch c := committer.(type) {
+	case NativeCommitter, ExecCommitter:
+		return LastCommitTime(dir), nil
+	case LinkCommitter:
+		return snapshotTime(previousSnapshot(snapshotDir(dir, c.Dir), ""), "", ""), nil
+	case TarCommitter:
+		return snapshotTime(previousSnapshot(snapshotDir(dir, c.Dir), tarSuffix), tarPrefix, tarSuffix), nil
+	}
+	return time.Time{}, fmt.Errorf("The time of the last snapshot is not known for a %T", committer)
+}
+
+// snapshotTime parses the time from a snapshot's name, ignoring the counter snapshotName adds to names
+// taken in the same second. Names which do not hold a time give the zero time.
+func snapshotTime(name string, prefix string, suffix string) time.Time {
+	name = strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix)
+	if i := strings.IndexByte(name, '-'); i >= 0 {
+		name = name[:i]
+	}
+
+	t, err := time.Parse(snapshotLayout, name)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
@@ -0,0 +1,189 @@
+package anubis
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClock returns each time in turn, one per snapshot
+func testClock(times ...string) func() time.Time {
+	return func() time.Time {
+		t, _ := time.Parse(time.RFC3339, times[0])
+		times = times[1:]
+		return t
+	}
+}
+
+func readSnapshotDirs(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestLinkCommitter_Commit(t *testing.T) {
+	dir := t.TempDir()
+	committer := LinkCommitter{Now: testClock("2026-10-01T12:00:00Z", "2026-10-02T12:00:00Z", "2026-10-03T12:00:00Z", "2026-10-04T12:00:00Z")}
+	author := GitIdentity{Name: "Archiver", Email: "archiver@test.com"}
+	snapshots := filepath.Join(dir, SnapshotsDir)
+
+	writeTestFiles(t, dir, map[string]string{"www.test.com/index.html": "<p>Hello</p>", "www.test.com/a.css": "body {}"})
+	if err := committer.Commit(dir, "First\n", author); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewriting a file with the same contents does not count as a change
+	writeTestFiles(t, dir, map[string]string{"www.test.com/index.html": "<p>Hello</p>", "www.test.com/a.css": "p {}"})
+	if err := committer.Commit(dir, "Second\n", author); err != nil {
+		t.Fatal(err)
+	}
+	if err := committer.Commit(dir, "Unchanged\n", author); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readSnapshotDirs(t, snapshots), []string{"20261001120000", "20261002120000"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshots = %v, want %v", got, want)
+	}
+
+	first, second := filepath.Join(snapshots, "20261001120000"), filepath.Join(snapshots, "20261002120000")
+	same := func(file string) bool {
+		a, errA := os.Stat(filepath.Join(first, file))
+		b, errB := os.Stat(filepath.Join(second, file))
+		return errA == nil && errB == nil && os.SameFile(a, b)
+	}
+	if !same("www.test.com/index.html") {
+		t.Error("unchanged file was not linked to the previous snapshot")
+	}
+	if same("www.test.com/a.css") {
+		t.Error("changed file was linked to the previous snapshot")
+	}
+	if got, _ := os.ReadFile(filepath.Join(second, "www.test.com/a.css")); string(got) != "p {}" {
+		t.Errorf("changed file = %q, want p {}", got)
+	}
+
+	message, err := os.ReadFile(filepath.Join(second, filepath.FromSlash(SnapshotMessageFile)))
+	if want := "Author: Archiver <archiver@test.com>\nDate: 2026-10-02T12:00:00Z\n\nSecond\n"; err != nil || string(message) != want {
+		t.Errorf("message = %q, %v, want %q", message, err, want)
+	}
+
+	// Removing a file is a change
+	if err := os.Remove(filepath.Join(dir, "www.test.com/a.css")); err != nil {
+		t.Fatal(err)
+	}
+	if err := committer.Commit(dir, "Removed\n", author); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(snapshots, "20261004120000", "www.test.com/a.css")); !os.IsNotExist(err) {
+		t.Errorf("removed file is still in the snapshot: %v", err)
+	}
+}
+
+func readTestTar(t *testing.T, p string) map[string]string {
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+
+	files := make(map[string]string)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[header.Name] = string(data)
+	}
+	return files
+}
+
+func TestTarCommitter_Commit(t *testing.T) {
+	dir, snapshots := t.TempDir(), t.TempDir()
+	committer := TarCommitter{Dir: snapshots, Now: testClock("2026-10-01T12:00:00Z", "2026-10-02T12:00:00Z", "2026-10-03T12:00:00Z")}
+
+	writeTestFiles(t, dir, map[string]string{"www.test.com/index.html": "<p>Hello</p>", ".anubis/manifest.json": "[]"})
+	if err := os.MkdirAll(filepath.Join(dir, ".git"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, message := range []string{"First\n", "Unchanged\n"} {
+		if err := committer.Commit(dir, message, GitIdentity{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFiles(t, dir, map[string]string{"www.test.com/index.html": "<p>Goodbye</p>"})
+	if err := committer.Commit(dir, "Changed\n", GitIdentity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := readSnapshotDirs(t, snapshots), []string{"anubis-20261001120000.tar.gz", "anubis-20261003120000.tar.gz"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("archives = %v, want %v", got, want)
+	}
+
+	files := readTestTar(t, filepath.Join(snapshots, "anubis-20261003120000.tar.gz"))
+	if files["www.test.com/index.html"] != "<p>Goodbye</p>" || files[".anubis/manifest.json"] != "[]" {
+		t.Errorf("archive = %v", files)
+	}
+	if !strings.HasPrefix(files[SnapshotMessageFile], "Author: Anubis <anubis@localhost>\n") || !strings.HasSuffix(files[SnapshotMessageFile], "\n\nChanged\n") {
+		t.Errorf("message = %q", files[SnapshotMessageFile])
+	}
+	if len(files) != 3 {
+		t.Errorf("archive has %v files, want 3", len(files))
+	}
+}
+
+func TestLastSnapshotTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		committer Committer
+	}{
+		{"Native commits", NativeCommitter{Now: testClock("2026-10-01T12:00:00Z", "2026-10-02T12:00:00Z")}},
+		{"Linked snapshots", LinkCommitter{Now: testClock("2026-10-01T12:00:00Z", "2026-10-02T12:00:00Z")}},
+		{"Tar archives", TarCommitter{Now: testClock("2026-10-01T12:00:00Z", "2026-10-02T12:00:00Z")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if got, err := LastSnapshotTime(tt.committer, dir); err != nil || !got.IsZero() {
+				t.Errorf("LastSnapshotTime() before any snapshot = %v, %v, want the zero time", got, err)
+			}
+
+			for _, content := range []string{"<p>Hello</p>", "<p>Goodbye</p>"} {
+				writeTestFiles(t, dir, map[string]string{"www.test.com/index.html": content})
+				if err := tt.committer.Commit(dir, "Snapshot\n", GitIdentity{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want := time.Date(2026, 10, 2, 12, 0, 0, 0, time.UTC)
+			if got, err := LastSnapshotTime(tt.committer, dir); err != nil || !got.Equal(want) {
+				t.Errorf("LastSnapshotTime() = %v, %v, want %v", got, err, want)
+			}
+		})
+	}
+
+	if _, err := LastSnapshotTime(nil, t.TempDir()); err == nil {
+		t.Errorf("LastSnapshotTime() of an unknown committer succeeded")
+	}
+}
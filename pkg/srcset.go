@@ -0,0 +1,147 @@
+package anubis
+
+import (
+	"errors"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// SrcsetPolicy decides which candidates of a srcset attribute are fetched
+type SrcsetPolicy int
+
+const (
+	// SrcsetAll fetches every candidate, so the archive renders the same image as the live page on any
+	// screen
+	SrcsetAll SrcsetPolicy = iota
+	// SrcsetLargest fetches only the candidate with the largest width or pixel density of each srcset.
+	// The other candidates are left pointing at the live site when links are rewritten, unless a
+	// placeholder is set.
+	SrcsetLargest
+)
+
+// ParseSrcsetPolicy parses "all" or "largest"
+func ParseSrcsetPolicy(s string) (SrcsetPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "all":
+		return SrcsetAll, nil
+	case "largest":
+		return SrcsetLargest, nil
+	}
+	return SrcsetAll, errors.New("Unknown srcset policy " + s)
+}
+
+// srcsetReferences parses the candidates of a srcset attribute as written in the document, returning a
+// reference for the URL of each. Character references in the URLs are decoded. The references are not
+// resolved, and set identifies the attribute they came from.
+func srcsetReferences(value []byte, offset int, tag string, set int) []Reference {
+	refs := []Reference{}
+
+	isSrcsetSpace := func(c byte) bool { return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' }
+
+	for i := 0; i < len(value); {
+		// Candidates are separated by commas and whitespace
+		for i < len(value) && (isSrcsetSpace(value[i]) || value[i] == ',') {
+			i++
+		}
+		if i == len(value) {
+			break
+		}
+
+		start := i
+		for i < len(value) && !isSrcsetSpace(value[i]) {
+			i++
+		}
+		end := i
+
+		// A URL ending in a comma has no descriptors
+		descriptor := ""
+		if value[end-1] == ',' {
+			for end > start && value[end-1] == ',' {
+				end--
+			}
+		} else {
+			// Descriptors run to the next comma outside parentheses
+			descStart, depth := i, 0
+			for ; i < len(value); i++ {
+				if value[i] == '(' {
+					depth++
+				} else if value[i] == ')' && depth > 0 {
+					depth--
+				} else if value[i] == ',' && depth == 0 {
+					break
+				}
+			}
+			descriptor = strings.Join(strings.Fields(string(value[descStart:i])), " ")
+		}
+
+		if end == start {
+			continue
+		}
+
+		refs = append(refs, Reference{
+			Kind:       AssetReference,
+			Tag:        tag,
+			Attr:       "srcset",
+			Raw:        html.UnescapeString(string(value[start:end])),
+			Descriptor: descriptor,
+			Start:      offset + start,
+			End:        offset + end,
+			set:        set,
+		})
+	}
+
+	return refs
+}
+
+// srcsetSize returns the width or pixel density a descriptor gives. Widths are preferred over densities, so
+// the second return value reports whether the size is a width. A missing descriptor means a density of 1.
+func srcsetSize(descriptor string) (float64, bool) {
+	for _, d := range strings.Fields(descriptor) {
+		if len(d) < 2 {
+			continue
+		}
+		n, err := strconv.ParseFloat(d[:len(d)-1], 64)
+		if err != nil {
+			continue
+		}
+		switch d[len(d)-1] {
+		case 'w':
+			return n, true
+		case 'x':
+			return n, false
+		}
+	}
+	return 1, false
+}
+
+// largestSrcsetCandidates drops every srcset candidate except the largest of each attribute. Other
+// references are kept in order.
+func largestSrcsetCandidates(refs []Reference) []Reference {
+	largest := make(map[int]int) // largest maps each srcset to the index of its largest candidate
+	for i, ref := range refs {
+		if ref.set == 0 {
+			continue
+		}
+
+		best, ok := largest[ref.set]
+		if !ok {
+			largest[ref.set] = i
+			continue
+		}
+
+		size, isWidth := srcsetSize(ref.Descriptor)
+		bestSize, bestIsWidth := srcsetSize(refs[best].Descriptor)
+		if (isWidth && !bestIsWidth) || (isWidth == bestIsWidth && size > bestSize) {
+			largest[ref.set] = i
+		}
+	}
+
+	kept := []Reference{}
+	for i, ref := range refs {
+		if ref.set == 0 || largest[ref.set] == i {
+			kept = append(kept, ref)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,86 @@
+package anubis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_srcsetReferences(t *testing.T) {
+	tests := []struct {
+		name        string
+		srcset      string
+		want        []string
+		descriptors []string
+	}{
+		{"Widths", "a.png 480w, b.png 800w", []string{"a.png", "b.png"}, []string{"480w", "800w"}},
+		{"Densities without spaces after commas", "a.png 1x,b.png 2x", []string{"a.png", "b.png"}, []string{"1x", "2x"}},
+		{"No descriptor", "a.png", []string{"a.png"}, []string{""}},
+		{"URLs containing commas", "a.png?x=1,2 1x, b,c.png", []string{"a.png?x=1,2", "b,c.png"}, []string{"1x", ""}},
+		{"Extra whitespace", "\n  a.png   100w ,\n b.png 200w  ", []string{"a.png", "b.png"}, []string{"100w", "200w"}},
+		{"Character references are decoded", "a.png?x=1&amp;y=2 2x", []string{"a.png?x=1&y=2"}, []string{"2x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs := srcsetReferences([]byte(tt.srcset), 0, "img", 1)
+
+			got, descriptors := []string{}, []string{}
+			for _, ref := range refs {
+				got = append(got, ref.Raw)
+				descriptors = append(descriptors, ref.Descriptor)
+			}
+			if !reflect.DeepEqual(got, tt.want) || !reflect.DeepEqual(descriptors, tt.descriptors) {
+				t.Errorf("srcsetReferences() = %q %q, want %q %q", got, descriptors, tt.want, tt.descriptors)
+			}
+		})
+	}
+}
+
+func Test_largestSrcsetCandidates(t *testing.T) {
+	html := `<img src="fallback.png" srcset="small.png 480w, large.png 1200w, medium.png 800w">` +
+		`<picture><source srcset="a.webp, b.webp 2x, c.webp 1.5x"><img src="d.png"></picture>`
+
+	got := []string{}
+	for _, ref := range largestSrcsetCandidates(ExtractReferences("https://www.test.com/", []byte(html))) {
+		got = append(got, ref.Raw)
+	}
+
+	want := []string{"fallback.png", "large.png", "b.webp", "d.png"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("largestSrcsetCandidates() = %v, want %v", got, want)
+	}
+}
+
+func TestParseSrcsetPolicy(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    SrcsetPolicy
+		wantErr bool
+	}{
+		{"all", SrcsetAll, false},
+		{" Largest ", SrcsetLargest, false},
+		{"smallest", SrcsetAll, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseSrcsetPolicy(tt.s)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseSrcsetPolicy() = %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultResponseHandler_Handle_srcset(t *testing.T) {
+	page := `<img src="a.png" srcset="a.png 1x, a-2x.png 2x, a-3x.png 3x">`
+
+	for policy, want := range map[SrcsetPolicy]int{SrcsetAll: 3, SrcsetLargest: 2} {
+		a := NewAnubis(OutputOpt(t.TempDir()), SrcsetOpt(policy))
+		req, resp := newTestResponse(t, "https://www.test.com/", 200, "text/html", page)
+		if err := a.Handler.Handle(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		if got := a.Pending(); got != want {
+			t.Errorf("policy %v queued %v URLs, want %v", policy, got, want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package anubis
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is recorded in commit messages and WARC files. Release builds set it with
+// -ldflags "-X anubis/pkg.Version=<version>".
+var Version = "dev"
+
+// HostStats counts the URLs of a single host archived during a run
+type HostStats struct {
+	Fetched int   // Fetched counts the responses written to the output
+	Changed int   // Changed counts the fetched responses which differ from the previous copy
+	Failed  int   // Failed counts the URLs which could not be archived
+	Bytes   int64 // Bytes is the total size of the fetched responses
+}
+
+// RunStats summarises a run, and is used to describe it in the commit message
+type RunStats struct {
+	StartURLs []string
+	Sitemaps  []string
+	Started   time.Time
+	Finished  time.Time // Finished is zero until Wait returns
+	HostStats
+	Hosts map[string]HostStats
+}
+
+// Duration returns how long the run took, or has taken so far if it has not finished
+func (stats RunStats) Duration() time.Duration {
+	if stats.Started.IsZero() {
+		return 0
+	}
+	if stats.Finished.IsZero() {
+		return time.Since(stats.Started)
+	}
+	return stats.Finished.Sub(stats.Started)
+}
+
+// runStats collects the counts for RunStats from every worker. It is safe for concurrent use.
+type runStats struct {
+	mu        sync.Mutex
+	startURLs []string
+	started   time.Time
+	finished  time.Time
+	hosts     map[string]*HostStats
+}
+
+func newRunStats() *runStats {
+	return &runStats{hosts: make(map[string]*HostStats)}
+}
+
+func (rs *runStats) host(rawURL string) *HostStats {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+
+	stats, ok := rs.hosts[host]
+	if !ok {
+		stats = &HostStats{}
+		rs.hosts[host] = stats
+	}
+	return stats
+}
+
+func (rs *runStats) addStartURL(rawURL string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.startURLs = append(rs.startURLs, rawURL)
+}
+
+func (rs *runStats) start() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.started, rs.finished = time.Now(), time.Time{}
+}
+
+func (rs *runStats) finish() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !rs.started.IsZero() && rs.finished.IsZero() {
+		rs.finished = time.Now()
+	}
+}
+
+func (rs *runStats) fetched(rawURL string, size int, changed bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	stats := rs.host(rawURL)
+	stats.Fetched++
+	stats.Bytes += int64(size)
+	if changed {
+		stats.Changed++
+	}
+}
+
+// Stats returns the counts for the run so far. Failures are counted from the instance's failure log.
+func (a *Anubis) Stats() RunStats {
+	a.stats.mu.Lock()
+	defer a.stats.mu.Unlock()
+
+	hosts := make(map[string]*HostStats, len(a.stats.hosts))
+	for host, stats := range a.stats.hosts {
+		copied := *stats
+		hosts[host] = &copied
+	}
+
+	// The failure counts are added to a copy so that Stats can be called more than once
+	counter := runStats{hosts: hosts}
+	for _, failure := range a.failures.all() {
+		counter.host(failure.URL).Failed++
+	}
+
+	stats := RunStats{
+		StartURLs: append([]string{}, a.stats.startURLs...),
+		Sitemaps:  append([]string{}, a.Sitemaps...),
+		Started:   a.stats.started,
+		Finished:  a.stats.finished,
+		Hosts:     make(map[string]HostStats, len(hosts)),
+	}
+	for host, h := range hosts {
+		stats.Hosts[host] = *h
+		stats.Fetched += h.Fetched
+		stats.Changed += h.Changed
+		stats.Failed += h.Failed
+		stats.Bytes += h.Bytes
+	}
+	return stats
+}
+
+// outputChanged reports whether the body fetched for a URL differs from the previous copy. The previous
+// copy's digest is compared rather than its file, since Rewrite changes files after they are written.
+// Copies recorded without a digest are compared with the file itself.
+func (a *Anubis) outputChanged(u string, filename string, digest string, body []byte) bool {
+	if entry, ok := a.previousEntry(u); ok && entry.Digest != "" {
+		return entry.Digest != digest || entry.Path != filename
+	}
+
+	previous, err := os.ReadFile(a.outputPath(filename))
+	return err != nil || string(previous) != string(body)
+}
+
+// CommitMessage describes a run. The subject has the time the run started and its totals, the body lists
+// the start URLs and the counts for each host, and the message ends with git trailers so the counts can
+// be read with git interpret-trailers or git log --format=%(trailers).
+func (stats RunStats) CommitMessage() string {
+	started := stats.Started
+	if started.IsZero() {
+		started = time.Now()
+	}
+
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "Snapshot %v: %v fetched, %v changed, %v failed\n",
+		started.UTC().Format(time.RFC3339), stats.Fetched, stats.Changed, stats.Failed)
+
+	if len(stats.StartURLs) > 0 || len(stats.Sitemaps) > 0 {
+		b.WriteString("\nStart URLs:\n")
+		for _, u := range stats.StartURLs {
+			b.WriteString("  " + u + "\n")
+		}
+		for _, u := range stats.Sitemaps {
+			b.WriteString("  " + u + " (sitemap)\n")
+		}
+	}
+
+	if len(stats.Hosts) > 0 {
+		hosts := make([]string, 0, len(stats.Hosts))
+		for host := range stats.Hosts {
+			hosts = append(hosts, host)
+		}
+		sort.Strings(hosts)
+
+		b.WriteString("\nHosts:\n")
+		for _, host := range hosts {
+			h := stats.Hosts[host]
+			fmt.Fprintf(&b, "  %v: %v fetched, %v changed, %v failed, %v bytes\n", host, h.Fetched, h.Changed, h.Failed, h.Bytes)
+		}
+	}
+
+	duration := stats.Duration().Round(time.Millisecond)
+
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "Anubis-Version: %v\n", Version)
+	for _, u := range stats.StartURLs {
+		fmt.Fprintf(&b, "Anubis-Start-URL: %v\n", u)
+	}
+	for _, u := range stats.Sitemaps {
+		fmt.Fprintf(&b, "Anubis-Sitemap: %v\n", u)
+	}
+	fmt.Fprintf(&b, "Anubis-Started: %v\n", started.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Anubis-Duration: %v\n", duration)
+	fmt.Fprintf(&b, "Anubis-Fetched: %v\n", stats.Fetched)
+	fmt.Fprintf(&b, "Anubis-Changed: %v\n", stats.Changed)
+	fmt.Fprintf(&b, "Anubis-Failed: %v\n", stats.Failed)
+
+	return b.String()
+}
@@ -0,0 +1,169 @@
+package anubis
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnubis_Stats(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+	if err := a.writeOutput("www.test.com/same.css", []byte("same")); err != nil {
+		t.Fatal(err)
+	}
+
+	responses := []struct{ url, body string }{
+		{"https://www.test.com/same.css", "same"},
+		{"https://www.test.com/new.css", "new"},
+		{"https://cdn.test.com/a.css", "cdn"},
+	}
+	for _, r := range responses {
+		req, resp := newTestResponse(t, r.url, 200, "text/css", r.body)
+		if err := a.Handler.Handle(req, resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.failures.add("https://cdn.test.com/b.css", errors.New("timeout"))
+
+	want := map[string]HostStats{
+		"www.test.com": {Fetched: 2, Changed: 1, Bytes: 7},
+		"cdn.test.com": {Fetched: 1, Changed: 1, Failed: 1, Bytes: 3},
+	}
+	stats := a.Stats()
+	if !reflect.DeepEqual(stats.Hosts, want) {
+		t.Errorf("Stats().Hosts = %v, want %v", stats.Hosts, want)
+	}
+	if total := (HostStats{Fetched: 3, Changed: 2, Failed: 1, Bytes: 10}); stats.HostStats != total {
+		t.Errorf("Stats() totals = %+v, want %+v", stats.HostStats, total)
+	}
+
+	// Failures are not counted twice
+	if again := a.Stats(); again.Failed != 1 {
+		t.Errorf("second Stats().Failed = %v, want 1", again.Failed)
+	}
+}
+
+func TestAnubis_Stats_rewrittenPages(t *testing.T) {
+	dir := t.TempDir()
+
+	run := func() RunStats {
+		a := NewAnubis(OutputOpt(dir))
+		responses := []struct{ url, contentType, body string }{
+			{"https://www.test.com/", "text/html", `<link rel="stylesheet" href="/a.css"><a href="https://www.test.com/">Home</a>`},
+			{"https://www.test.com/a.css", "text/css", `body { background: url(/bg.png) }`},
+			{"https://www.test.com/bg.png", "image/png", "png"},
+		}
+		for _, r := range responses {
+			req, resp := newTestResponse(t, r.url, 200, r.contentType, r.body)
+			if err := a.Handler.Handle(req, resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := a.Rewrite(); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.SaveManifest(); err != nil {
+			t.Fatal(err)
+		}
+		return a.Stats()
+	}
+
+	if first := run(); first.Changed != 3 {
+		t.Errorf("first run changed %v files, want 3", first.Changed)
+	}
+
+	// The rewritten files differ from the responses, but the responses have not changed
+	page, err := os.ReadFile(filepath.Join(dir, "www.test.com", "index.html"))
+	if err != nil || !strings.Contains(string(page), `href="a.css"`) {
+		t.Fatalf("page was not rewritten: %s, %v", page, err)
+	}
+	if second := run(); second.Fetched != 3 || second.Changed != 0 {
+		t.Errorf("second run fetched %v and changed %v files, want 3 and 0", second.Fetched, second.Changed)
+	}
+}
+
+func TestRunStats_CommitMessage(t *testing.T) {
+	started := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	stats := RunStats{
+		StartURLs: []string{"https://www.test.com/"},
+		Sitemaps:  []string{"https://www.test.com/sitemap.xml"},
+		Started:   started,
+		Finished:  started.Add(1500 * time.Millisecond),
+		HostStats: HostStats{Fetched: 3, Changed: 2, Failed: 1, Bytes: 10},
+		Hosts: map[string]HostStats{
+			"www.test.com": {Fetched: 2, Changed: 1, Bytes: 7},
+			"cdn.test.com": {Fetched: 1, Changed: 1, Failed: 1, Bytes: 3},
+		},
+	}
+
+	want := `Snapshot 2026-10-01T12:00:00Z: 3 fetched, 2 changed, 1 failed
+
+Start URLs:
+  https://www.test.com/
+  https://www.test.com/sitemap.xml (sitemap)
+
+Hosts:
+  cdn.test.com: 1 fetched, 1 changed, 1 failed, 3 bytes
+  www.test.com: 2 fetched, 1 changed, 0 failed, 7 bytes
+
+Anubis-Version: ` + Version + `
+Anubis-Start-URL: https://www.test.com/
+Anubis-Sitemap: https://www.test.com/sitemap.xml
+Anubis-Started: 2026-10-01T12:00:00Z
+Anubis-Duration: 1.5s
+Anubis-Fetched: 3
+Anubis-Changed: 2
+Anubis-Failed: 1
+`
+	if got := stats.CommitMessage(); got != want {
+		t.Errorf("CommitMessage() = %v, want %v", got, want)
+	}
+}
+
+func TestAnubis_Commit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	// Without a global identity the configured author must be used
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+
+	dir := t.TempDir()
+	a := NewAnubis(OutputOpt(dir), AuthorOpt{Name: "Archiver", Email: "archiver@test.com"})
+	a.stats.addStartURL("https://www.test.com/")
+	req, resp := newTestResponse(t, "https://www.test.com/", 200, "text/html", "<p>Hello</p>")
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("git", "-C", dir, "log", "-1", "--format=%an <%ae>%n%(trailers:key=Anubis-Fetched,valueonly)%n%B").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitN(strings.Replace(string(out), "\n\n", "\n", 1), "\n", 3)
+	if lines[0] != "Archiver <archiver@test.com>" {
+		t.Errorf("author = %v, want Archiver <archiver@test.com>", lines[0])
+	}
+	if lines[1] != "1" {
+		t.Errorf("Anubis-Fetched trailer = %q, want 1", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "Snapshot ") || !strings.Contains(lines[2], "Anubis-Start-URL: https://www.test.com/") {
+		t.Errorf("message = %v", lines[2])
+	}
+
+	// Nothing changed, so there is nothing to commit
+	if err := a.Commit(); err != nil {
+		t.Errorf("Commit() with no changes = %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package anubis
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// StatusAction is what the DefaultResponseHandler does with a response, based on its status code
+type StatusAction int
+
+const (
+	// SaveResponse writes the response to the archive and follows its links
+	SaveResponse StatusAction = iota
+	// KeepPrevious discards the response, leaving any previously archived copy in place
+	KeepPrevious
+	// SkipResponse discards the response and removes any previously archived copy, so the archive
+	// reflects that the resource is gone
+	SkipResponse
+	// SaveToErrors writes the response under the _errors directory of the output, without following its
+	// links, so it can be inspected without replacing the archived copy
+	SaveToErrors
+	// FailRun stops the instance. The run's error is available from Err.
+	FailRun
+)
+
+// ErrorsDir is the directory within the output where responses are saved by SaveToErrors
+const ErrorsDir = "_errors"
+
+var statusActionNames = map[string]StatusAction{
+	"save":   SaveResponse,
+	"keep":   KeepPrevious,
+	"skip":   SkipResponse,
+	"errors": SaveToErrors,
+	"fail":   FailRun,
+}
+
+// StatusPolicy maps response status codes to the action taken for them. Specific codes take precedence
+// over their class, and codes without either use SaveResponse for 2xx and KeepPrevious otherwise.
+type StatusPolicy struct {
+	Classes map[int]StatusAction // Classes maps a status class, such as 4 for 4xx, to an action
+	Codes   map[int]StatusAction // Codes maps specific status codes to an action
+}
+
+// DefaultStatusPolicy saves successful responses and leaves the archive untouched for everything else.
+// A 304 Not Modified means the previous copy is still current, and any other 3xx reaching the handler is
+// a redirect which could not be followed.
+func DefaultStatusPolicy() StatusPolicy {
+	return StatusPolicy{
+		Classes: map[int]StatusAction{
+			2: SaveResponse,
+			3: KeepPrevious,
+			4: KeepPrevious,
+			5: KeepPrevious,
+		},
+		Codes: map[int]StatusAction{
+			http.StatusNotModified: KeepPrevious,
+		},
+	}
+}
+
+// Action returns the action for a status code
+func (policy StatusPolicy) Action(status int) StatusAction {
+	if action, ok := policy.Codes[status]; ok {
+		return action
+	}
+
+	if action, ok := policy.Classes[status/100]; ok {
+		return action
+	}
+
+	if status >= 200 && status < 300 {
+		return SaveResponse
+	}
+	return KeepPrevious
+}
+
+// ParseStatusPolicy parses a comma-separated list of rules such as "4xx=skip,5xx=fail,404=errors" and
+// applies them on top of the DefaultStatusPolicy. The actions are save, keep, skip, errors and fail.
+func ParseStatusPolicy(s string) (StatusPolicy, error) {
+	policy := DefaultStatusPolicy()
+
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		i := strings.IndexByte(rule, '=')
+		if i < 0 {
+			return policy, errors.New("Could not parse " + rule + " as a status rule, expected status=action")
+		}
+
+		status, name := strings.ToLower(strings.TrimSpace(rule[:i])), strings.ToLower(strings.TrimSpace(rule[i+1:]))
+
+		action, ok := statusActionNames[name]
+		if !ok {
+			return policy, errors.New("Unknown status action " + name)
+		}
+
+		if len(status) == 3 && strings.HasSuffix(status, "xx") {
+			class, err := strconv.Atoi(status[:1])
+			if err != nil {
+				return policy, errors.New("Could not parse " + status + " as a status class")
+			}
+			policy.Classes[class] = action
+			continue
+		}
+
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 100 || code > 999 {
+			return policy, errors.New("Could not parse " + status + " as a status code")
+		}
+		policy.Codes[code] = action
+	}
+
+	return policy, nil
+}
+
+// StatusError is returned by the DefaultResponseHandler when a response is not archived because of its
+// status code
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Action     StatusAction
+}
+
+func (err *StatusError) Error() string {
+	msg := "Not archiving " + err.URL + ": " + strconv.Itoa(err.StatusCode) + " " + http.StatusText(err.StatusCode)
+	if err.Action == FailRun {
+		return msg + ", stopping"
+	}
+	return msg
+}
@@ -0,0 +1,161 @@
+package anubis
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStatusPolicy_Action(t *testing.T) {
+	policy, err := ParseStatusPolicy("4xx=skip, 5xx=fail, 404=errors, 503=keep")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		status int
+		want   StatusAction
+	}{
+		{200, SaveResponse},
+		{204, SaveResponse},
+		{304, KeepPrevious},
+		{301, KeepPrevious},
+		{403, SkipResponse},
+		{404, SaveToErrors},
+		{500, FailRun},
+		{503, KeepPrevious},
+	}
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			if got := policy.Action(tt.status); got != tt.want {
+				t.Errorf("Action(%v) = %v, want %v", tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStatusPolicy(t *testing.T) {
+	for _, s := range []string{"4xx", "4xx=explode", "xxx=skip", "42=skip"} {
+		if _, err := ParseStatusPolicy(s); err == nil {
+			t.Errorf("ParseStatusPolicy(%q) error = nil, want an error", s)
+		}
+	}
+}
+
+func newTestResponse(t *testing.T, rawURL string, status int, contentType string, body string) (*http.Request, *http.Response) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &http.Request{Method: "GET", URL: u, Header: http.Header{}}
+	resp := &http.Response{
+		StatusCode: status,
+		Header:     http.Header{"Content-Type": []string{contentType}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}
+	return req, resp
+}
+
+func TestDefaultResponseHandler_Handle_status(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       string
+		status     int
+		wantPage   string // wantPage is the expected content of the archived page, or empty if it should not exist
+		wantErrors bool   // wantErrors is true if the response should be saved under ErrorsDir
+		wantFail   bool
+	}{
+		{name: "Successful responses replace the previous copy", status: 200, wantPage: "new"},
+		{name: "Errors keep the previous copy by default", status: 500, wantPage: "old"},
+		{name: "Not modified keeps the previous copy", status: 304, wantPage: "old"},
+		{name: "Skipped responses remove the previous copy", rule: "4xx=skip", status: 410},
+		{name: "Errors may be saved separately", rule: "404=errors", status: 404, wantPage: "old", wantErrors: true},
+		{name: "Errors may fail the run", rule: "5xx=fail", status: 503, wantPage: "old", wantFail: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParseStatusPolicy(tt.rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			a := NewTestAnubis()
+			a.Output = t.TempDir()
+			a.StatusPolicy = policy
+			a.Start()
+			defer a.Cancel()
+
+			// The previous run recorded its copy in the stored manifest
+			if err := a.writeOutput("www.test.com/page.html", []byte("old")); err != nil {
+				t.Fatal(err)
+			}
+			a.Manifest.Record(ManifestEntry{URL: "https://www.test.com/page.html", Path: "www.test.com/page.html", ContentType: "text/html"})
+			if err := a.SaveManifest(); err != nil {
+				t.Fatal(err)
+			}
+			a.Manifest = NewManifest()
+
+			req, resp := newTestResponse(t, "https://www.test.com/page.html", tt.status, "text/html", "new")
+			err = DefaultResponseHandler{a}.Handle(req, resp)
+
+			if wantErr := tt.status != 200 && !tt.wantErrors; (err != nil) != wantErr {
+				t.Errorf("Handle() error = %v, wantErr %v", err, wantErr)
+			}
+
+			page, err := os.ReadFile(filepath.Join(a.Output, "www.test.com", "page.html"))
+			if tt.wantPage == "" && !os.IsNotExist(err) {
+				t.Errorf("archived page was not removed")
+			} else if tt.wantPage != "" && string(page) != tt.wantPage {
+				t.Errorf("archived page = %q, want %q", page, tt.wantPage)
+			}
+
+			_, err = os.Stat(filepath.Join(a.Output, ErrorsDir, "www.test.com", "page.html"))
+			if tt.wantErrors != (err == nil) {
+				t.Errorf("error page saved = %v, want %v", err == nil, tt.wantErrors)
+			}
+
+			if (a.Err() != nil) != tt.wantFail {
+				t.Errorf("Err() = %v, wantFail %v", a.Err(), tt.wantFail)
+			}
+		})
+	}
+}
+
+func TestDefaultResponseHandler_Handle_skipRecordedPath(t *testing.T) {
+	for _, format := range []OutputFormat{TreeFormat, WARCFormat} {
+		a := NewAnubis(OutputOpt(t.TempDir()))
+		policy, err := ParseStatusPolicy("4xx=skip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.StatusPolicy = policy
+
+		// A PHP page served as HTML is stored under a name the URL alone does not give
+		req, resp := newTestResponse(t, "https://www.test.com/page.php", 200, "text/html", "<p>old")
+		if err := a.Handler.Handle(req, resp); err != nil {
+			t.Fatal(err)
+		}
+		entry, ok := a.Manifest.Get("https://www.test.com/page.php")
+		if !ok || entry.Path != "www.test.com/page.php.html" {
+			t.Fatalf("Manifest entry = %+v, %v", entry, ok)
+		}
+
+		a.Format = format
+		req, resp = newTestResponse(t, "https://www.test.com/page.php", 410, "text/html", "gone")
+		a.Handler.Handle(req, resp)
+
+		_, err = os.Stat(a.outputPath(entry.Path))
+		if removed := os.IsNotExist(err); removed != (format == TreeFormat) {
+			t.Errorf("with format %v, previous copy removed = %v", format, removed)
+		}
+		if _, ok := a.Manifest.Get("https://www.test.com/page.php"); ok {
+			t.Errorf("with format %v, the manifest entry was kept", format)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package anubis
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+type TokenType int
+
+const (
+	TextToken TokenType = iota
+	StartTagToken
+	EndTagToken
+	SelfClosingTagToken
+	CommentToken
+	DoctypeToken
+)
+
+// Attribute is a single key/value pair found inside a start tag. ValStart and ValEnd are the byte offsets
+// of the undecoded value within the source document, excluding any surrounding quotes. If the attribute
+// has no value, both offsets point just past the attribute name.
+type Attribute struct {
+	Key      string // Key is the lower-cased attribute name
+	Val      string // Val is the attribute value with character references decoded
+	ValStart int
+	ValEnd   int
+}
+
+// Token is a single lexical unit of an HTML document. Start and End are the byte offsets of the whole
+// token within the source document.
+type Token struct {
+	Type  TokenType
+	Data  string // Data is the lower-cased tag name for tags, or the raw content for text and comments
+	Attr  []Attribute
+	Start int
+	End   int
+}
+
+// AttrVal returns the value of the first attribute with the given name. The second return value reports
+// whether the attribute was present at all.
+func (tok Token) AttrVal(key string) (string, bool) {
+	for _, attr := range tok.Attr {
+		if attr.Key == key {
+			return attr.Val, true
+		}
+	}
+	return "", false
+}
+
+// rawTextElements contain text which should not be parsed as markup until the matching end tag
+var rawTextElements = map[string]bool{
+	"script":    true,
+	"style":     true,
+	"textarea":  true,
+	"title":     true,
+	"xmp":       true,
+	"noembed":   true,
+	"noframes":  true,
+	"plaintext": true,
+}
+
+// Tokenizer is a lenient, streaming HTML tokenizer. It does not build a tree or validate the document, it
+// only splits the input into tags, text and comments while keeping track of where each piece came from,
+// so callers can locate and replace attribute values in the original bytes.
+type Tokenizer struct {
+	buf []byte
+	pos int
+	raw string // raw is the name of the raw text element we are currently inside of, if any
+}
+
+// NewTokenizer creates a Tokenizer which reads from the given document
+func NewTokenizer(buf []byte) *Tokenizer {
+	return &Tokenizer{buf: buf}
+}
+
+// Next returns the next token in the document. The second return value is false once the end of the
+// document has been reached.
+func (z *Tokenizer) Next() (Token, bool) {
+	if z.pos >= len(z.buf) {
+		return Token{}, false
+	}
+
+	if z.raw != "" {
+		return z.readRawText(), true
+	}
+
+	if tok, ok := z.readMarkup(); ok {
+		return tok, true
+	}
+
+	return z.readText(), true
+}
+
+// startsMarkup reports whether the byte at i opens a tag, comment or declaration
+func (z *Tokenizer) startsMarkup(i int) bool {
+	if z.buf[i] != '<' || i+1 >= len(z.buf) {
+		return false
+	}
+
+	c := z.buf[i+1]
+	return isASCIILetter(c) || c == '/' || c == '!' || c == '?'
+}
+
+func (z *Tokenizer) readText() Token {
+	start := z.pos
+	end := start + 1
+	for end < len(z.buf) && !z.startsMarkup(end) {
+		end++
+	}
+	z.pos = end
+
+	return Token{Type: TextToken, Data: string(z.buf[start:end]), Start: start, End: end}
+}
+
+func (z *Tokenizer) readRawText() Token {
+	start := z.pos
+	end := len(z.buf)
+
+	closing := []byte("</" + z.raw)
+	for i := start; i+len(closing) <= len(z.buf); i++ {
+		if z.buf[i] != '<' || !bytes.EqualFold(z.buf[i:i+len(closing)], closing) {
+			continue
+		}
+
+		next := i + len(closing)
+		if next == len(z.buf) || isSpace(z.buf[next]) || z.buf[next] == '/' || z.buf[next] == '>' {
+			end = i
+			break
+		}
+	}
+
+	z.pos = end
+	z.raw = ""
+
+	return Token{Type: TextToken, Data: string(z.buf[start:end]), Start: start, End: end}
+}
+
+// readMarkup attempts to read a tag, comment or declaration at the current position. If the current
+// position does not open any markup, ok is false and the position is not advanced.
+func (z *Tokenizer) readMarkup() (tok Token, ok bool) {
+	if !z.startsMarkup(z.pos) {
+		return Token{}, false
+	}
+
+	start := z.pos
+	rest := z.buf[start:]
+
+	switch {
+	case bytes.HasPrefix(rest, []byte("<!--")):
+		end := bytes.Index(rest[4:], []byte("-->"))
+		if end < 0 {
+			z.pos = len(z.buf)
+			return Token{Type: CommentToken, Data: string(rest[4:]), Start: start, End: z.pos}, true
+		}
+		z.pos = start + 4 + end + 3
+		return Token{Type: CommentToken, Data: string(rest[4 : 4+end]), Start: start, End: z.pos}, true
+
+	case rest[1] == '!' || rest[1] == '?':
+		z.pos = z.indexOrEnd(start, '>')
+		typ := CommentToken
+		if len(rest) >= 9 && strings.EqualFold(string(rest[2:9]), "doctype") {
+			typ = DoctypeToken
+		}
+		return Token{Type: typ, Data: string(z.buf[start+2 : z.contentEnd(start+2)]), Start: start, End: z.pos}, true
+
+	case rest[1] == '/':
+		if len(rest) < 3 || !isASCIILetter(rest[2]) {
+			// Something like '</>' or '</ foo>' is treated as a bogus comment
+			z.pos = z.indexOrEnd(start, '>')
+			return Token{Type: CommentToken, Data: string(z.buf[start+2 : z.contentEnd(start+2)]), Start: start, End: z.pos}, true
+		}
+		z.pos = start + 2
+		name := z.readName()
+		z.pos = z.indexOrEnd(z.pos, '>')
+		return Token{Type: EndTagToken, Data: name, Start: start, End: z.pos}, true
+	}
+
+	z.pos = start + 1
+	tok = Token{Type: StartTagToken, Data: z.readName(), Start: start}
+	z.readAttributes(&tok)
+	tok.End = z.pos
+
+	if tok.Type == StartTagToken && rawTextElements[tok.Data] {
+		z.raw = tok.Data
+	}
+
+	return tok, true
+}
+
+// indexOrEnd returns the position just after the next occurrence of c at or after i, or the end of the
+// document if c does not occur
+func (z *Tokenizer) indexOrEnd(i int, c byte) int {
+	if n := bytes.IndexByte(z.buf[i:], c); n >= 0 {
+		return i + n + 1
+	}
+	return len(z.buf)
+}
+
+// contentEnd returns the end of the content of a declaration which starts at i and ends at z.pos
+func (z *Tokenizer) contentEnd(i int) int {
+	end := z.pos
+	if end > i && z.buf[end-1] == '>' {
+		end--
+	}
+	if end < i {
+		end = i
+	}
+	return end
+}
+
+// readName reads a tag name from the current position and returns it lower-cased
+func (z *Tokenizer) readName() string {
+	start := z.pos
+	for z.pos < len(z.buf) {
+		c := z.buf[z.pos]
+		if isSpace(c) || c == '/' || c == '>' {
+			break
+		}
+		z.pos++
+	}
+	return strings.ToLower(string(z.buf[start:z.pos]))
+}
+
+func (z *Tokenizer) readAttributes(tok *Token) {
+	for {
+		z.skipSpace()
+		if z.pos >= len(z.buf) {
+			return
+		}
+
+		switch c := z.buf[z.pos]; {
+		case c == '>':
+			z.pos++
+			return
+		case c == '/':
+			z.pos++
+			if z.pos < len(z.buf) && z.buf[z.pos] == '>' {
+				z.pos++
+				tok.Type = SelfClosingTagToken
+				return
+			}
+			continue
+		}
+
+		nameStart := z.pos
+		z.pos++ // The first character of a name may be '=', so it is always consumed
+		for z.pos < len(z.buf) {
+			c := z.buf[z.pos]
+			if isSpace(c) || c == '/' || c == '>' || c == '=' {
+				break
+			}
+			z.pos++
+		}
+
+		attr := Attribute{
+			Key:      strings.ToLower(string(z.buf[nameStart:z.pos])),
+			ValStart: z.pos,
+			ValEnd:   z.pos,
+		}
+
+		// Whitespace is allowed around the '=', but if no '=' follows the attribute has no value
+		afterName := z.pos
+		z.skipSpace()
+		if z.pos >= len(z.buf) || z.buf[z.pos] != '=' {
+			z.pos = afterName
+			tok.Attr = append(tok.Attr, attr)
+			continue
+		}
+		z.pos++
+		z.skipSpace()
+
+		if z.pos < len(z.buf) && (z.buf[z.pos] == '"' || z.buf[z.pos] == '\'') {
+			quote := z.buf[z.pos]
+			attr.ValStart = z.pos + 1
+			end := bytes.IndexByte(z.buf[attr.ValStart:], quote)
+			if end < 0 {
+				attr.ValEnd = len(z.buf)
+				z.pos = len(z.buf)
+			} else {
+				attr.ValEnd = attr.ValStart + end
+				z.pos = attr.ValEnd + 1
+			}
+		} else {
+			attr.ValStart = z.pos
+			for z.pos < len(z.buf) && !isSpace(z.buf[z.pos]) && z.buf[z.pos] != '>' {
+				z.pos++
+			}
+			attr.ValEnd = z.pos
+		}
+
+		attr.Val = html.UnescapeString(string(z.buf[attr.ValStart:attr.ValEnd]))
+		tok.Attr = append(tok.Attr, attr)
+	}
+}
+
+func (z *Tokenizer) skipSpace() {
+	for z.pos < len(z.buf) && isSpace(z.buf[z.pos]) {
+		z.pos++
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
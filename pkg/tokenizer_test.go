@@ -0,0 +1,116 @@
+package anubis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTokenizer_Next(t *testing.T) {
+	type tokenSummary struct {
+		Type TokenType
+		Data string
+	}
+	tests := []struct {
+		name string
+		html string
+		want []tokenSummary
+	}{
+		{
+			name: "Text and tags are split",
+			html: `<p>Hello <b>world</b></p>`,
+			want: []tokenSummary{
+				{StartTagToken, "p"}, {TextToken, "Hello "}, {StartTagToken, "b"},
+				{TextToken, "world"}, {EndTagToken, "b"}, {EndTagToken, "p"},
+			},
+		},
+		{
+			name: "Tag names are lower-cased",
+			html: `<IMG SRC="a.png">`,
+			want: []tokenSummary{{StartTagToken, "img"}},
+		},
+		{
+			name: "Self-closing tags are recognized",
+			html: `<br/><link href="a.css" />`,
+			want: []tokenSummary{{SelfClosingTagToken, "br"}, {SelfClosingTagToken, "link"}},
+		},
+		{
+			name: "Comments and doctypes are not tags",
+			html: `<!DOCTYPE html><!-- <img src="a.png"> --><a>`,
+			want: []tokenSummary{{DoctypeToken, "DOCTYPE html"}, {CommentToken, ` <img src="a.png"> `}, {StartTagToken, "a"}},
+		},
+		{
+			name: "Script contents are raw text",
+			html: `<script>if (a <b) { x = "</div>" }</script><p>`,
+			want: []tokenSummary{
+				{StartTagToken, "script"}, {TextToken, `if (a <b) { x = "</div>" }`},
+				{EndTagToken, "script"}, {StartTagToken, "p"},
+			},
+		},
+		{
+			name: "A lone angle bracket is text",
+			html: `a < b`,
+			want: []tokenSummary{{TextToken, "a < b"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []tokenSummary{}
+
+			z := NewTokenizer([]byte(tt.html))
+			for {
+				tok, ok := z.Next()
+				if !ok {
+					break
+				}
+				got = append(got, tokenSummary{tok.Type, tok.Data})
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenizer_Attributes(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want []Attribute
+	}{
+		{
+			name: "Double-quoted, single-quoted and unquoted values",
+			html: `<img a="1" b='2' c=3>`,
+			want: []Attribute{
+				{Key: "a", Val: "1", ValStart: 8, ValEnd: 9},
+				{Key: "b", Val: "2", ValStart: 14, ValEnd: 15},
+				{Key: "c", Val: "3", ValStart: 19, ValEnd: 20},
+			},
+		},
+		{
+			name: "Attributes without values",
+			html: `<input disabled type=text>`,
+			want: []Attribute{
+				{Key: "disabled", ValStart: 15, ValEnd: 15},
+				{Key: "type", Val: "text", ValStart: 21, ValEnd: 25},
+			},
+		},
+		{
+			name: "Character references are decoded but offsets cover the raw value",
+			html: `<a HREF = "/?a=1&amp;b=2">`,
+			want: []Attribute{{Key: "href", Val: "/?a=1&b=2", ValStart: 11, ValEnd: 24}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tok, ok := NewTokenizer([]byte(tt.html)).Next()
+			if !ok {
+				t.Fatalf("Next() returned no token")
+			}
+
+			if !reflect.DeepEqual(tok.Attr, tt.want) {
+				t.Errorf("Attr = %+v, want %+v", tok.Attr, tt.want)
+			}
+		})
+	}
+}
@@ -1,12 +1,13 @@
 package anubis
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path"
-	"strings"
 	"sync"
 )
 
@@ -27,78 +28,164 @@ type ResponseHandler interface {
 }
 
 // DefaultResponseHandler is assigned to Anubis if none is assigned otherwise.
-// This handler will parse an HTML page and grab all script, stylesheet, and image URLs
-// and add those to the queue for the anubis instance.
+// This handler will tokenize an HTML page and grab all script, stylesheet, and image URLs
+// and add those to the queue for the anubis instance. Stylesheets, <style> elements and style
+// attributes are scanned for url() and @import references too. If the instance is crawling, links
+// to other pages are followed as well.
 //
 // This handler requires a reference to the Anubis instance to add it to the queue, and
 // a similarly functioning crawler based on Anubis would also need to function in the same way.
-//
-// The DefaultResponseHandler also determines when the instance is finished based on default
-// behavior. If the currently-handled response is from a start URL, we will store all the found
-// links on that page to check after each successive step. Once all links are retrieved, the
-// cancel function will be called and the program will exit.
+// The instance itself keeps track of when all work is finished, so handlers only need to add
+// the URLs they find.
 type DefaultResponseHandler struct {
 	Anubis *Anubis // The owning Anubis instance
-
-	// NeededLinks stores each link which should be processed before the program can exit.
-	// Once a link is processed, it is removed from the map. Finally, when the map is empty
-	// we can call the cancel function
-	NeededLinks map[string]bool
 }
 
 func (handler DefaultResponseHandler) Handle(req *http.Request, resp *http.Response) error {
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
+	// Every response is recorded in the WARC, whatever its status, before it is handled for the tree
+	if err := handler.Anubis.recordWARC(req, resp); err != nil {
 		return err
 	}
 
-	// Check whether this is an HTML response. If it is, then we should initialize the conditions
-	// to stop the anubis instance once all files have been downloaded
-	contentType := resp.Header.Get("Content-Type")
-	if strings.Contains(contentType, "text/html") {
-		parentURL := req.URL.String()
-		bodyString := string(body)
-
-		urls := []string{}
+	// A redirect only reaches the handler if its target is archived separately, so only stubs are
+	// written for it
+	if target, ok := redirectLocation(req, resp); ok {
+		hops := append(RedirectChain(req), RedirectHop{URL: req.URL.String(), StatusCode: resp.StatusCode})
+		handler.Anubis.writeRedirectStubs(hops, target)
+		return nil
+	}
 
-		// Get all links
-		urls = append(urls, GetLinkURLs(parentURL, bodyString)...)
-		urls = append(urls, GetScriptURLs(parentURL, bodyString)...)
-		urls = append(urls, GetImageURLs(parentURL, bodyString)...)
+	switch action := handler.Anubis.StatusPolicy.Action(resp.StatusCode); action {
+	case KeepPrevious:
+		return &StatusError{URL: req.URL.String(), StatusCode: resp.StatusCode, Action: action}
 
-		for _, u := range urls {
-			if handler.Anubis.AddURL(u) {
-				handler.NeededLinks[u] = true
+	case SkipResponse:
+		// The previous copy is found through the manifest, since its path depends on the content type
+		// it was served with
+		if entry, ok := handler.Anubis.previousEntry(req.URL.String()); ok && entry.RedirectTo == "" {
+			if handler.Anubis.Format&TreeFormat != 0 {
+				if err := os.Remove(handler.Anubis.outputPath(entry.Path)); err != nil && !os.IsNotExist(err) {
+					return err
+				}
 			}
+			handler.Anubis.forgetEntry(req.URL.String())
 		}
-	}
+		return &StatusError{URL: req.URL.String(), StatusCode: resp.StatusCode, Action: action}
+
+	case FailRun:
+		err := &StatusError{URL: req.URL.String(), StatusCode: resp.StatusCode, Action: action}
+		handler.Anubis.Fail(err)
+		return err
 
-	delete(handler.NeededLinks, req.URL.String())
+	case SaveToErrors:
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		media := ClassifyMedia(resp.Header.Get("Content-Type"), req.URL, body)
+		filename := handler.Anubis.Mapper.MapPath(req.URL, media.ContentType)
+		return handler.Anubis.writeOutput(path.Join(ErrorsDir, filename), body)
+	}
 
-	// Signal to all workers to exit if all work is finished
-	if len(handler.NeededLinks) == 0 {
-		handler.Anubis.Cancel()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
 	}
 
-	filename := req.URL.Path
+	// The response is classified from its body and URL as well as its header, since servers often send
+	// pages and assets without a Content-Type or as text/plain. Pages, stylesheets and scripts are
+	// parsed, and all assets they reference are downloaded as well.
+	media := ClassifyMedia(resp.Header.Get("Content-Type"), req.URL, body)
+	contentType := media.ContentType
+	canonical := ""
+	switch media.Kind {
+	case MediaCSS:
+		for _, ref := range ExtractCSSReferences(req.URL.String(), body) {
+			if ref.URL != "" {
+				handler.Anubis.AddURL(stripFragment(ref.URL))
+			}
+		}
+	case MediaJavaScript:
+		for _, ref := range ExtractJSReferences(req.URL.String(), body, handler.Anubis.importMap()) {
+			if ref.URL != "" {
+				handler.Anubis.AddURL(stripFragment(ref.URL))
+			}
+		}
+	case MediaHTML:
+		// The page's import maps are recorded before its scripts are queued, so their imports resolve
+		refs, imports := extractReferences(req.URL.String(), body)
+		handler.Anubis.addImportMap(imports)
+		if handler.Anubis.Srcset == SrcsetLargest {
+			refs = largestSrcsetCandidates(refs)
+		}
+
+		for _, ref := range refs {
+			if ref.URL == "" {
+				continue
+			}
 
-	// If this is not a valid url, attempt to write to file system in a directory with the host name
-	if len(filename) == 0 || filename[len(filename)-1] == '/' {
-		filename = "index.html"
+			// Assets are always fetched, but other pages are only followed when crawling. Fragments
+			// are not sent to the server, so they are dropped to avoid fetching a document twice
+			u := stripFragment(ref.URL)
+
+			switch ref.Kind {
+			case AssetReference:
+				// Manifests are marked before they are queued, so they are recognised when fetched
+				if ref.Rel == RelManifest {
+					handler.Anubis.webManifests.Store(u, true)
+				}
+				handler.Anubis.AddURL(u)
+			case PageReference:
+				handler.Anubis.FollowLink(req.URL.String(), u)
+			case MetadataReference:
+				if ref.Rel == RelCanonical && canonical == "" {
+					canonical = u
+				}
+			}
+		}
 	}
 
-	p := path.Join(handler.Anubis.Output, req.URL.Hostname(), filename)
+	if handler.Anubis.isWebManifest(req.URL.String(), contentType) {
+		for _, ref := range ExtractManifestReferences(req.URL.String(), body) {
+			switch {
+			case ref.URL == "":
+			case ref.Kind == AssetReference:
+				handler.Anubis.AddURL(stripFragment(ref.URL))
+			case ref.Kind == PageReference:
+				handler.Anubis.FollowLink(req.URL.String(), stripFragment(ref.URL))
+			}
+		}
+	}
 
-	if err := os.MkdirAll(path.Dir(p), 0774); err != nil {
+	filename := handler.Anubis.Mapper.MapPath(req.URL, contentType)
+	digest := sha256Hex(body)
+	changed := handler.Anubis.outputChanged(req.URL.String(), filename, digest, body)
+	if err := handler.Anubis.writeOutput(filename, body); err != nil {
 		return err
 	}
+	handler.Anubis.stats.fetched(req.URL.String(), len(body), changed)
 
-	if err := os.WriteFile(p, body, 0644); err != nil {
-		return err
+	handler.Anubis.Manifest.Record(ManifestEntry{
+		URL:         req.URL.String(),
+		Path:        filename,
+		ContentType: contentType,
+		Status:      resp.StatusCode,
+		Header:      storedHeader(resp.Header),
+		Canonical:   canonical,
+		Digest:      digest,
+	})
+
+	// The page is the archived copy of its canonical URL, which is not fetched separately
+	if canonical != "" && canonical != req.URL.String() {
+		handler.Anubis.Filter.TestURL(canonical)
 	}
 
+	// Stubs written for redirects to this URL before it was recorded are written again with its path
+	hops := append(RedirectChain(req), handler.Anubis.takePendingStubs(req.URL.String())...)
+	handler.Anubis.writeRedirectStubs(hops, req.URL.String())
+
 	return nil
 }
 
@@ -106,28 +193,138 @@ type RequestProcessor interface {
 	Process(string, map[string]string, WebDriver, ResponseHandler) error
 }
 
-type DefaultRequestProcessor struct{}
+// DefaultRequestProcessor fetches each URL with a GET request and passes the response to the handler,
+// returning any error from the handler. Redirects are followed one hop at a time, and the handler
+// receives the request for the final URL, from which the chain is available using RedirectChain. A
+// redirect to a URL which robots.txt disallows, or which is outside the crawl, is not followed, and the
+// handler receives the redirect itself.
+// Network errors and transient status codes are retried according to the owning instance's Retry
+// policy. If every attempt fails, a *FetchError is returned and the handler is not called, so error
+// pages are never mistaken for the real document. The exception is a status code which the owning
+// instance's StatusPolicy acts on other than with KeepPrevious, such as 5xx=fail or 429=errors: the last
+// response is then passed to the handler so the policy applies.
+type DefaultRequestProcessor struct {
+	Anubis *Anubis // The owning Anubis instance
+}
 
-func (*DefaultRequestProcessor) Process(url string, headers map[string]string, webdriver WebDriver, handler ResponseHandler) error {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return err
+func (processor *DefaultRequestProcessor) Process(url string, headers map[string]string, webdriver WebDriver, handler ResponseHandler) error {
+	hops := []RedirectHop{}
+
+	for {
+		req, resp, err := processor.fetch(url, headers, webdriver)
+		if err != nil && resp != nil {
+			return processor.handleExhausted(withRedirectChain(req, hops), resp, err, handler)
+		}
+		if err != nil {
+			return err
+		}
+
+		// A driver which follows redirects itself only reveals the final URL
+		if resp.Request != nil && resp.Request.URL.String() != req.URL.String() {
+			hops = append(hops, RedirectHop{URL: req.URL.String()})
+			req = resp.Request
+		}
+
+		next, ok := redirectLocation(req, resp)
+		if !ok {
+			return handler.Handle(withRedirectChain(req, hops), resp)
+		}
+
+		if len(hops) >= maxRedirects {
+			resp.Body.Close()
+			return errors.New("Too many redirects from " + hops[0].URL)
+		}
+
+		// The target is fetched once, even when several URLs redirect to it, and only if it would be
+		// fetched when linked. If it has already been queued or is not followed, the redirect itself is
+		// passed to the handler so the chain is still recorded
+		if processor.Anubis != nil {
+			if !processor.Anubis.followRedirect(req.URL.String(), next) || processor.Anubis.Filter.TestURL(next) {
+				return handler.Handle(withRedirectChain(req, hops), resp)
+			}
+
+			// Replay tools reach the target through each hop's response record, so the hops the
+			// handler never sees are recorded here
+			if err := processor.Anubis.recordWARC(req, resp); err != nil {
+				resp.Body.Close()
+				return err
+			}
+		}
+
+		resp.Body.Close()
+		hops = append(hops, RedirectHop{URL: req.URL.String(), StatusCode: resp.StatusCode})
+		url = next
 	}
+}
 
-	for k, v := range headers {
-		req.Header.Set(k, v)
+// handleExhausted decides what happens to the last response for a status code which was retried until
+// the attempts ran out. The handler only sees it if the status policy asks for more than keeping the
+// previous copy. Unless the policy saves the response, the URL is still reported as failed.
+func (processor *DefaultRequestProcessor) handleExhausted(req *http.Request, resp *http.Response, fetchErr error, handler ResponseHandler) error {
+	action := KeepPrevious
+	if processor.Anubis != nil {
+		action = processor.Anubis.StatusPolicy.Action(resp.StatusCode)
+	}
+	if action == KeepPrevious {
+		resp.Body.Close()
+		return fetchErr
 	}
 
-	resp, err := webdriver.DoRequest(req)
-	if err != nil {
+	if err := handler.Handle(req, resp); err != nil {
 		return err
 	}
+	if action == SaveResponse {
+		return nil
+	}
+	return fetchErr
+}
 
-	if err := handler.Handle(req, resp); err != nil {
-		log.Println(err)
+// fetch requests the URL, retrying network errors and transient status codes according to the owning
+// instance's Retry policy. When the attempts run out on a status code, the last response is returned
+// unread along with the *FetchError.
+func (processor *DefaultRequestProcessor) fetch(url string, headers map[string]string, webdriver WebDriver) (*http.Request, *http.Response, error) {
+	policy, ctx := DefaultRetryPolicy, context.Background()
+	if processor.Anubis != nil {
+		policy, ctx = processor.Anubis.Retry, processor.Anubis.Context
+	}
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
-	return nil
+	for attempts := 1; ; attempts++ {
+		// The instance's context cancels requests in flight and waits for the host limiter when it stops
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+
+		resp, err := webdriver.DoRequest(req)
+		if err == nil && !policy.Retryable(resp.StatusCode) {
+			return req, resp, nil
+		}
+
+		fetchErr := &FetchError{URL: url, Attempts: attempts, Err: err}
+		if resp != nil {
+			fetchErr.StatusCode = resp.StatusCode
+		}
+
+		delay, retry := policy.Backoff(attempts, resp)
+		if !retry {
+			return req, resp, fetchErr
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		log.Println(fetchErr, "Retrying in", delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, nil, fetchErr
+		}
+	}
 }
 
 type DuplicateFilter interface {
@@ -8,8 +8,7 @@ import (
 
 func TestDefaultResponseHandler_Handle(t *testing.T) {
 	type fields struct {
-		Anubis      *Anubis
-		NeededLinks map[string]bool
+		Anubis *Anubis
 	}
 	type args struct {
 		req  *http.Request
@@ -26,8 +25,7 @@ func TestDefaultResponseHandler_Handle(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			handler := DefaultResponseHandler{
-				Anubis:      tt.fields.Anubis,
-				NeededLinks: tt.fields.NeededLinks,
+				Anubis: tt.fields.Anubis,
 			}
 			if err := handler.Handle(tt.args.req, tt.args.resp); (err != nil) != tt.wantErr {
 				t.Errorf("Handle() error = %v, wantErr %v", err, tt.wantErr)
@@ -0,0 +1,326 @@
+package anubis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OutputFormat selects what is written for each archived response. Formats may be combined.
+type OutputFormat int
+
+const (
+	// TreeFormat mirrors each response into a file below Output, named after its URL
+	TreeFormat OutputFormat = 1 << iota
+	// WARCFormat appends a record for each response to the instance's WARC writer
+	WARCFormat
+)
+
+var outputFormatNames = map[string]OutputFormat{
+	"tree": TreeFormat,
+	"warc": WARCFormat,
+}
+
+// ParseOutputFormat parses a comma-separated list of formats, such as "tree,warc"
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	var format OutputFormat
+
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		f, ok := outputFormatNames[name]
+		if !ok {
+			return 0, errors.New("Unknown output format " + name)
+		}
+		format |= f
+	}
+
+	if format == 0 {
+		return 0, errors.New("No output format given")
+	}
+	return format, nil
+}
+
+const (
+	warcVersion = "WARC/1.1"
+
+	// The revisit profiles defined by WARC/1.1
+	identicalPayloadProfile = "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest"
+	notModifiedProfile      = "http://netpreserve.org/warc/1.1/revisit/server-not-modified"
+)
+
+// warcCapture identifies the response record which first stored a payload, so later responses with the
+// same payload can be written as revisits
+type warcCapture struct {
+	URI      string
+	Date     string
+	RecordID string
+}
+
+// WARCWriter writes WARC/1.1 records, compressing each record as a separate gzip member so that records
+// can be read individually. It is safe for concurrent use.
+type WARCWriter struct {
+	mu       sync.Mutex
+	w        io.Writer
+	captures map[string]warcCapture // captures maps payload digests to the record which stored them
+}
+
+// NewWARCWriter creates a writer and writes the warcinfo record describing the file. The filename is
+// recorded in the warcinfo record.
+func NewWARCWriter(w io.Writer, filename string) (*WARCWriter, error) {
+	writer := &WARCWriter{w: w, captures: make(map[string]warcCapture)}
+
+	info := warcFields([][2]string{
+		{"software", "anubis/" + Version},
+		{"format", "WARC File Format 1.1"},
+		{"conformsTo", "http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/"},
+	})
+
+	header := [][2]string{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Date", warcDate(time.Now())},
+		{"WARC-Filename", filepath.Base(filename)},
+		{"Content-Type", "application/warc-fields"},
+	}
+
+	if err := writer.writeRecord(header, info); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// CreateWARC creates a WARC file, replacing any existing file at the path
+func CreateWARC(filename string) (*WARCWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := NewWARCWriter(f, filename)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return writer, nil
+}
+
+// Close closes the underlying writer, if it is an io.Closer
+func (writer *WARCWriter) Close() error {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	if c, ok := writer.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// WriteResponse records the request and response. The response is stored as a revisit if the server
+// answered 304 Not Modified or an identical payload was already stored, and a metadata record lists the
+// redirects which led to the request. The body is read in full and replaced with an in-memory copy, so
+// the response can still be handled afterwards.
+func (writer *WARCWriter) WriteResponse(req *http.Request, resp *http.Response) error {
+	payload, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(payload))
+
+	uri := req.URL.String()
+	date := warcDate(time.Now())
+	responseID := newRecordID()
+	digest := warcDigest(payload)
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	previous, seen := writer.captures[digest]
+
+	header := [][2]string{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", uri},
+		{"Content-Type", "application/http;msgtype=response"},
+		{"WARC-Payload-Digest", digest},
+	}
+	block := append(httpResponseHeader(resp), payload...)
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		header[0][1] = "revisit"
+		header = append(header, [2]string{"WARC-Profile", notModifiedProfile})
+		block = httpResponseHeader(resp)
+
+	case seen && len(payload) > 0:
+		// Only the headers are stored, and the payload is found through the original record
+		header[0][1] = "revisit"
+		header = append(header,
+			[2]string{"WARC-Profile", identicalPayloadProfile},
+			[2]string{"WARC-Refers-To", previous.RecordID},
+			[2]string{"WARC-Refers-To-Target-URI", previous.URI},
+			[2]string{"WARC-Refers-To-Date", previous.Date},
+		)
+		block = httpResponseHeader(resp)
+
+	default:
+		writer.captures[digest] = warcCapture{URI: uri, Date: date, RecordID: responseID}
+	}
+
+	if err := writer.writeRecord(header, block); err != nil {
+		return err
+	}
+
+	request := [][2]string{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", uri},
+		{"WARC-Concurrent-To", responseID},
+		{"Content-Type", "application/http;msgtype=request"},
+	}
+	if err := writer.writeRecord(request, httpRequestHeader(req)); err != nil {
+		return err
+	}
+
+	chain := RedirectChain(req)
+	if len(chain) == 0 {
+		return nil
+	}
+
+	fields := [][2]string{{"via", chain[len(chain)-1].URL}}
+	for _, hop := range chain {
+		fields = append(fields, [2]string{"redirect", strconv.Itoa(hop.StatusCode) + " " + hop.URL})
+	}
+
+	metadata := [][2]string{
+		{"WARC-Type", "metadata"},
+		{"WARC-Record-ID", newRecordID()},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", uri},
+		{"WARC-Concurrent-To", responseID},
+		{"Content-Type", "application/warc-fields"},
+	}
+	return writer.writeRecord(metadata, warcFields(fields))
+}
+
+// recordWARC writes the response to the instance's WARC, if the output format includes one
+func (a *Anubis) recordWARC(req *http.Request, resp *http.Response) error {
+	if a.Format&WARCFormat == 0 || a.WARC == nil {
+		return nil
+	}
+	return a.WARC.WriteResponse(req, resp)
+}
+
+// writeRecord writes a single record as its own gzip member. The caller must hold the lock, except while
+// the writer is being created.
+func (writer *WARCWriter) writeRecord(header [][2]string, block []byte) error {
+	var b bytes.Buffer
+	b.WriteString(warcVersion + "\r\n")
+	for _, field := range header {
+		b.WriteString(field[0] + ": " + field[1] + "\r\n")
+	}
+	b.WriteString("WARC-Block-Digest: " + warcDigest(block) + "\r\n")
+	b.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+	b.Write(block)
+	b.WriteString("\r\n\r\n")
+
+	zw := gzip.NewWriter(writer.w)
+	if _, err := zw.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// httpRequestHeader reconstructs the request line and headers sent for the request
+func httpRequestHeader(req *http.Request) []byte {
+	var b bytes.Buffer
+	b.WriteString(req.Method + " " + req.URL.RequestURI() + " HTTP/1.1\r\n")
+	b.WriteString("Host: " + req.URL.Host + "\r\n")
+	writeHTTPHeader(&b, req.Header)
+	return b.Bytes()
+}
+
+// httpResponseHeader reconstructs the status line and headers of the response
+func httpResponseHeader(resp *http.Response) []byte {
+	proto := resp.Proto
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+
+	status := resp.Status
+	if status == "" {
+		status = strconv.Itoa(resp.StatusCode) + " " + http.StatusText(resp.StatusCode)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(proto + " " + status + "\r\n")
+	writeHTTPHeader(&b, resp.Header)
+	return b.Bytes()
+}
+
+// writeHTTPHeader writes the header fields in a stable order, followed by the blank line ending them
+func writeHTTPHeader(b *bytes.Buffer, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		for _, v := range header[k] {
+			b.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+}
+
+func warcFields(fields [][2]string) []byte {
+	var b bytes.Buffer
+	for _, field := range fields {
+		b.WriteString(field[0] + ": " + field[1] + "\r\n")
+	}
+	return b.Bytes()
+}
+
+// warcDigest returns the SHA-1 digest of the data in the base32 form used by WARC tools
+func warcDigest(data []byte) string {
+	sum := sha1.Sum(data)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+func warcDate(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// newRecordID returns a random version 4 UUID as a URN
+func newRecordID() string {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	id[6] = id[6]&0x0f | 0x40
+	id[8] = id[8]&0x3f | 0x80
+
+	h := hex.EncodeToString(id[:])
+	return "<urn:uuid:" + h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:] + ">"
+}
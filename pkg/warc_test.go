@@ -0,0 +1,252 @@
+package anubis
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"testing"
+)
+
+type warcTestRecord struct {
+	header textproto.MIMEHeader
+	block  []byte
+}
+
+// readWARC reads every record, checking that each is a separate gzip member with a valid block digest
+func readWARC(t *testing.T, data []byte) []warcTestRecord {
+	records := []warcTestRecord{}
+
+	br := bytes.NewReader(data)
+	zr, err := gzip.NewReader(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		zr.Multistream(false)
+		member, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := textproto.NewReader(bufio.NewReader(bytes.NewReader(member)))
+		if version, err := r.ReadLine(); err != nil || version != "WARC/1.1" {
+			t.Fatalf("record starts with %q, want WARC/1.1", version)
+		}
+
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		block := make([]byte, length)
+		if _, err := io.ReadFull(r.R, block); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := warcDigest(block); got != header.Get("WARC-Block-Digest") {
+			t.Errorf("block digest = %v, want %v", header.Get("WARC-Block-Digest"), got)
+		}
+
+		records = append(records, warcTestRecord{header, block})
+
+		if err := zr.Reset(br); err == io.EOF {
+			return records
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWARCWriter_WriteResponse(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewWARCWriter(&out, "test.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewAnubis(OutputOpt(t.TempDir()), WARCOpt{writer})
+
+	responses := []struct {
+		url    string
+		status int
+		body   string
+		chain  []RedirectHop
+	}{
+		{"https://www.test.com/", 200, "<p>Hello</p>", nil},
+		{"https://www.test.com/copy", 200, "<p>Hello</p>", []RedirectHop{{URL: "https://www.test.com/old", StatusCode: 301}}},
+		{"https://www.test.com/cached", 304, "", nil},
+	}
+	for _, r := range responses {
+		req, resp := newTestResponse(t, r.url, r.status, "text/html", r.body)
+		if err := a.Handler.Handle(withRedirectChain(req, r.chain), resp); err != nil && r.status == 200 {
+			t.Fatal(err)
+		}
+	}
+
+	records := readWARC(t, out.Bytes())
+
+	want := []struct {
+		warcType string
+		uri      string
+		profile  string
+	}{
+		{"warcinfo", "", ""},
+		{"response", "https://www.test.com/", ""},
+		{"request", "https://www.test.com/", ""},
+		{"revisit", "https://www.test.com/copy", identicalPayloadProfile},
+		{"request", "https://www.test.com/copy", ""},
+		{"metadata", "https://www.test.com/copy", ""},
+		{"revisit", "https://www.test.com/cached", notModifiedProfile},
+		{"request", "https://www.test.com/cached", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("wrote %v records, want %v", len(records), len(want))
+	}
+
+	for i, w := range want {
+		h := records[i].header
+		if h.Get("WARC-Type") != w.warcType || h.Get("WARC-Target-URI") != w.uri || h.Get("WARC-Profile") != w.profile {
+			t.Errorf("record %v = %v %v %v, want %v %v %v", i,
+				h.Get("WARC-Type"), h.Get("WARC-Target-URI"), h.Get("WARC-Profile"), w.warcType, w.uri, w.profile)
+		}
+		if h.Get("WARC-Record-ID") == "" || h.Get("WARC-Date") == "" {
+			t.Errorf("record %v is missing its ID or date: %v", i, h)
+		}
+	}
+
+	response := records[1]
+	if want := warcDigest([]byte("<p>Hello</p>")); response.header.Get("WARC-Payload-Digest") != want {
+		t.Errorf("payload digest = %v, want %v", response.header.Get("WARC-Payload-Digest"), want)
+	}
+	if !bytes.HasPrefix(response.block, []byte("HTTP/1.1 200 OK\r\n")) || !bytes.HasSuffix(response.block, []byte("\r\n\r\n<p>Hello</p>")) {
+		t.Errorf("response block = %q", response.block)
+	}
+
+	if got := records[3].header.Get("WARC-Refers-To"); got != response.header.Get("WARC-Record-ID") {
+		t.Errorf("revisit refers to %v, want %v", got, response.header.Get("WARC-Record-ID"))
+	}
+	if got := records[2].header.Get("WARC-Concurrent-To"); got != response.header.Get("WARC-Record-ID") {
+		t.Errorf("request is concurrent to %v, want %v", got, response.header.Get("WARC-Record-ID"))
+	}
+	if want := "via: https://www.test.com/old\r\nredirect: 301 https://www.test.com/old\r\n"; string(records[5].block) != want {
+		t.Errorf("metadata = %q, want %q", records[5].block, want)
+	}
+}
+
+func TestParseOutputFormat(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    OutputFormat
+		wantErr bool
+	}{
+		{"tree", TreeFormat, false},
+		{"warc", WARCFormat, false},
+		{"tree, WARC", TreeFormat | WARCFormat, false},
+		{"", 0, true},
+		{"zip", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseOutputFormat(tt.s)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseOutputFormat() = %v, %v, want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+// The tree is still written when the WARC is, but not when only the WARC is selected
+func TestAnubis_writeOutput_format(t *testing.T) {
+	for _, format := range []OutputFormat{TreeFormat, WARCFormat} {
+		a := NewAnubis(OutputOpt(t.TempDir()), FormatOpt(format))
+		if err := a.writeOutput("www.test.com/index.html", []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := ioutil.ReadFile(a.outputPath("www.test.com/index.html"))
+		if exists := err == nil; exists != (format&TreeFormat != 0) {
+			t.Errorf("format %v wrote file = %v", format, exists)
+		}
+	}
+}
+
+func TestDefaultResponseHandler_Handle_warcBody(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewWARCWriter(&out, "test.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The tree copy is still complete after the WARC has read the body
+	a := NewAnubis(OutputOpt(t.TempDir()), WARCOpt{writer})
+	req, resp := newTestResponse(t, "https://www.test.com/a.css", http.StatusOK, "text/css", "body {}")
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(a.outputPath("www.test.com/a.css"))
+	if err != nil || string(got) != "body {}" {
+		t.Errorf("tree copy = %q, %v, want body {}", got, err)
+	}
+}
+
+func TestDefaultRequestProcessor_Process_warcRedirects(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewWARCWriter(&out, "test.warc.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	driver := &RedirectWebDriver{
+		StaticWebDriver: StaticWebDriver{responses: map[string]string{"https://www.test.com/new/": "<p>New</p>"}},
+		redirects: map[string]string{
+			"https://www.test.com/old":   "/older",
+			"https://www.test.com/older": "/new/",
+		},
+	}
+
+	a := NewAnubis(OutputOpt(t.TempDir()), WARCOpt{writer})
+	if err := a.processor.Process("https://www.test.com/old", nil, driver, a.Handler); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readWARC(t, out.Bytes())
+
+	// Each hop has its own response, so replay tools can follow the redirects from the original URL
+	want := []struct {
+		warcType string
+		uri      string
+		status   string
+	}{
+		{"warcinfo", "", ""},
+		{"response", "https://www.test.com/old", "HTTP/1.1 301 Moved Permanently\r\n"},
+		{"request", "https://www.test.com/old", "GET /old HTTP/1.1\r\n"},
+		{"response", "https://www.test.com/older", "HTTP/1.1 301 Moved Permanently\r\n"},
+		{"request", "https://www.test.com/older", "GET /older HTTP/1.1\r\n"},
+		{"response", "https://www.test.com/new/", "HTTP/1.1 200 OK\r\n"},
+		{"request", "https://www.test.com/new/", "GET /new/ HTTP/1.1\r\n"},
+		{"metadata", "https://www.test.com/new/", "via: https://www.test.com/older\r\n"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("wrote %v records, want %v", len(records), len(want))
+	}
+
+	for i, w := range want {
+		r := records[i]
+		if r.header.Get("WARC-Type") != w.warcType || r.header.Get("WARC-Target-URI") != w.uri || !bytes.HasPrefix(r.block, []byte(w.status)) {
+			t.Errorf("record %v = %v %v %q, want %v %v %q", i,
+				r.header.Get("WARC-Type"), r.header.Get("WARC-Target-URI"), r.block, w.warcType, w.uri, w.status)
+		}
+	}
+
+	if !bytes.Contains(records[1].block, []byte("Location: /older\r\n")) {
+		t.Errorf("redirect response = %q, want its Location header", records[1].block)
+	}
+}
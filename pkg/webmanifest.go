@@ -0,0 +1,122 @@
+package anubis
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// manifestFields lists the members of a web app manifest which hold URLs, by their path within the
+// manifest ignoring array indices, along with the kind and classification of each
+var manifestFields = map[string]struct {
+	kind ReferenceKind
+	rel  string
+}{
+	"icons.src":           {AssetReference, RelIcon},
+	"screenshots.src":     {AssetReference, RelImage},
+	"shortcuts.icons.src": {AssetReference, RelIcon},
+	"shortcuts.url":       {PageReference, ""},
+	"start_url":           {PageReference, ""},
+}
+
+// isWebManifest reports whether a response is a web app manifest, either by its content type or because a
+// page linked to it with rel=manifest. Manifests are often served as plain JSON.
+func (a *Anubis) isWebManifest(u string, contentType string) bool {
+	if MediaKindOf(contentType) == MediaWebManifest {
+		return true
+	}
+	_, linked := a.webManifests.Load(stripFragment(u))
+	return linked
+}
+
+// ExtractManifestReferences returns the icons, screenshots and pages listed in a web app manifest, resolved
+// against the manifest's URL. Start and End are the byte offsets of each URL within the manifest, excluding
+// quotes, and Raw is the decoded string. A manifest which is not valid JSON has no references, although
+// those found before the error are returned.
+func ExtractManifestReferences(parent string, manifest []byte) []Reference {
+	refs := []Reference{}
+
+	walkJSONStrings(manifest, func(keys []string, value string, start, end int) {
+		field, found := manifestFields[strings.Join(keys, ".")]
+		if !found || value == "" {
+			return
+		}
+
+		ref := Reference{Kind: field.kind, Tag: "manifest", Attr: keys[len(keys)-1], Rel: field.rel, Raw: value, Start: start, End: end}
+		if u, err := getFullURL(parent, value); err == nil {
+			ref.URL = u
+		}
+		refs = append(refs, ref)
+	})
+
+	return refs
+}
+
+// walkJSONStrings calls fn for every string value in a JSON document, in document order, with the keys of
+// the objects containing it, ignoring array indices. Start and End are the byte offsets of the string within
+// the document, excluding quotes. Walking stops at the first syntax error.
+func walkJSONStrings(data []byte, fn func(keys []string, value string, start, end int)) {
+	// frame is an object or array being read. Keys are only tracked for objects.
+	type frame struct {
+		object    bool
+		key       string
+		expectKey bool
+	}
+	stack := []*frame{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		before := int(dec.InputOffset())
+		tok, err := dec.Token()
+		if err != nil {
+			return
+		}
+		after := int(dec.InputOffset())
+
+		var top *frame
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+
+		// A string is a key if the innermost container is an object expecting one
+		if s, ok := tok.(string); ok && top != nil && top.object && top.expectKey {
+			top.key, top.expectKey = s, false
+			continue
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			stack = append(stack, &frame{object: tok == json.Delim('{'), expectKey: true})
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+		default:
+			if s, ok := tok.(string); ok {
+				keys := []string{}
+				for _, f := range stack {
+					if f.object {
+						keys = append(keys, f.key)
+					}
+				}
+
+				// The value starts at the first quote after the previous token
+				start := before + bytes.IndexByte(data[before:after], '"') + 1
+				fn(keys, s, start, after-1)
+			}
+		}
+
+		// After a value, an object expects its next key
+		if len(stack) > 0 && stack[len(stack)-1].object {
+			stack[len(stack)-1].expectKey = true
+		}
+	}
+}
+
+// jsonEscape escapes a string for use within a quoted JSON string
+func jsonEscape(s string) string {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return s
+	}
+	return string(b[1 : len(b)-1])
+}
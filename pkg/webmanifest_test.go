@@ -0,0 +1,118 @@
+package anubis
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestExtractManifestReferences(t *testing.T) {
+	manifest := `{
+  "name": "Test",
+  "start_url": "/?source=pwa",
+  "icons": [
+    {"src": "icons/192.png", "sizes": "192x192"},
+    {"sizes": "512x512", "src": "icons\/512.png"}
+  ],
+  "screenshots": [{"src": "/shot.png"}],
+  "shortcuts": [{"name": "News", "url": "/news", "icons": [{"src": "news.png"}]}],
+  "related_applications": [{"url": "https://play.example.com/app"}]
+}`
+
+	got := ExtractManifestReferences("https://www.test.com/app/manifest.json", []byte(manifest))
+
+	want := []struct {
+		kind ReferenceKind
+		rel  string
+		raw  string
+		url  string
+		span string
+	}{
+		{PageReference, "", "/?source=pwa", "https://www.test.com/?source=pwa", "/?source=pwa"},
+		{AssetReference, RelIcon, "icons/192.png", "https://www.test.com/app/icons/192.png", "icons/192.png"},
+		{AssetReference, RelIcon, "icons/512.png", "https://www.test.com/app/icons/512.png", `icons\/512.png`},
+		{AssetReference, RelImage, "/shot.png", "https://www.test.com/shot.png", "/shot.png"},
+		{PageReference, "", "/news", "https://www.test.com/news", "/news"},
+		{AssetReference, RelIcon, "news.png", "https://www.test.com/app/news.png", "news.png"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ExtractManifestReferences() = %+v, want %v references", got, len(want))
+	}
+
+	for i, w := range want {
+		ref := got[i]
+		if ref.Kind != w.kind || ref.Rel != w.rel || ref.Raw != w.raw || ref.URL != w.url {
+			t.Errorf("reference %v = %+v, want %v %q %v %v", i, ref, w.kind, w.rel, w.raw, w.url)
+		}
+		if manifest[ref.Start:ref.End] != w.span {
+			t.Errorf("offsets of %v cover %q, want %q", ref.Raw, manifest[ref.Start:ref.End], w.span)
+		}
+	}
+
+	if refs := ExtractManifestReferences("https://www.test.com/", []byte("not json")); len(refs) != 0 {
+		t.Errorf("ExtractManifestReferences() of invalid JSON = %+v", refs)
+	}
+}
+
+func TestDefaultResponseHandler_Handle_metadata(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+
+	page := `<link rel="manifest" href="/manifest.json"><link rel="canonical" href="/article">` +
+		`<meta property="og:image" content="/og.png"><link rel="preconnect" href="https://fonts.test.com">`
+	req, resp := newTestResponse(t, "https://www.test.com/article?utm_source=feed", 200, "text/html", page)
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// The manifest is recognised although it is served as plain JSON
+	req, resp = newTestResponse(t, "https://www.test.com/manifest.json", 200, "application/json", `{"icons": [{"src": "/icon.png"}]}`)
+	if err := a.Handler.Handle(req, resp); err != nil {
+		t.Fatal(err)
+	}
+
+	for u, want := range map[string]bool{
+		"https://www.test.com/manifest.json": true,
+		"https://www.test.com/og.png":        true,
+		"https://www.test.com/icon.png":      true,
+		"https://www.test.com/article":       true, // the canonical URL is marked as seen without being queued
+		"https://fonts.test.com":             false,
+	} {
+		if got := a.Filter.TestURL(u); got != want {
+			t.Errorf("%v seen = %v, want %v", u, got, want)
+		}
+	}
+	if got := a.Pending(); got != 3 {
+		t.Errorf("Handle() queued %v URLs, want 3", got)
+	}
+
+	entry, ok := a.Manifest.Lookup("https://www.test.com/article")
+	if !ok || entry.URL != "https://www.test.com/article?utm_source=feed" {
+		t.Errorf("Lookup() of the canonical URL = %+v, %v", entry, ok)
+	}
+}
+
+func Test_jsonEscape(t *testing.T) {
+	got := []string{jsonEscape(`a"b`), jsonEscape(`../icons/a b.png`)}
+	want := []string{`a\"b`, `../icons/a b.png`}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("jsonEscape() = %q, want %q", got, want)
+	}
+}
+
+func TestAnubis_Rewrite_webManifest(t *testing.T) {
+	a := NewAnubis(OutputOpt(t.TempDir()))
+	a.Manifest.Record(ManifestEntry{URL: "https://www.test.com/app/site.webmanifest", Path: "www.test.com/app/site.webmanifest", ContentType: "application/manifest+json"})
+	a.Manifest.Record(ManifestEntry{URL: "https://www.test.com/icon.png", Path: "www.test.com/icon.png", ContentType: "image/png"})
+
+	if err := a.writeOutput("www.test.com/app/site.webmanifest", []byte(`{"icons": [{"src": "/icon.png"}, {"src": "/missing.png"}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(a.outputPath("www.test.com/app/site.webmanifest"))
+	if want := `{"icons": [{"src": "../icon.png"}, {"src": "/missing.png"}]}`; err != nil || string(got) != want {
+		t.Errorf("Rewrite() = %s, %v, want %v", got, err, want)
+	}
+}
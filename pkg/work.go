@@ -0,0 +1,112 @@
+package anubis
+
+import "sync"
+
+// workTracker counts the URLs which have been accepted by AddURL but have not finished processing. A URL is
+// counted from the moment it is queued until the worker handling it returns, which includes the time spent
+// queueing any URLs found in its response, so the count only drops to zero once the crawl is complete.
+type workTracker struct {
+	mu      sync.Mutex
+	pending int
+}
+
+// add records a newly queued URL
+func (t *workTracker) add() {
+	t.mu.Lock()
+	t.pending++
+	t.mu.Unlock()
+}
+
+// done records that a URL has been processed. It returns true if this was the last pending URL.
+func (t *workTracker) done() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.pending == 0 {
+		return false
+	}
+
+	t.pending--
+	return t.pending == 0
+}
+
+// count returns the number of pending URLs
+func (t *workTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pending
+}
+
+// frontier holds the URLs which are waiting for a worker. URLs are stored in an unbounded FIFO, so pushing
+// never blocks the caller, even when it is a worker adding the links found in a large page. A dispatcher
+// goroutine hands URLs to idle workers through an unbuffered channel.
+type frontier struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	items  []string
+	ch     chan string
+	closed bool
+}
+
+func newFrontier() *frontier {
+	f := &frontier{ch: make(chan string)}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// push adds the URL to the end of the frontier. It returns false if the frontier has been closed.
+func (f *frontier) push(url string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return false
+	}
+
+	f.items = append(f.items, url)
+	f.cond.Signal()
+	return true
+}
+
+// pop removes the URL at the front of the frontier, waiting until one is available. The second return
+// value is false once the frontier is closed and empty.
+func (f *frontier) pop() (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for len(f.items) == 0 && !f.closed {
+		f.cond.Wait()
+	}
+
+	if len(f.items) == 0 {
+		return "", false
+	}
+
+	url := f.items[0]
+	f.items[0] = ""
+	f.items = f.items[1:]
+	return url, true
+}
+
+// dispatch passes URLs to the workers until the frontier is closed and drained, then closes the channel so
+// that the workers exit. It should be run in its own goroutine.
+func (f *frontier) dispatch() {
+	defer close(f.ch)
+
+	for {
+		url, ok := f.pop()
+		if !ok {
+			return
+		}
+		f.ch <- url
+	}
+}
+
+// close stops the frontier from accepting URLs. URLs which were already pushed will still be dispatched.
+func (f *frontier) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	f.cond.Broadcast()
+}
@@ -0,0 +1,104 @@
+package anubis
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_workTracker(t *testing.T) {
+	tracker := workTracker{}
+
+	if tracker.done() {
+		t.Errorf("done() = true for a tracker with no pending work")
+	}
+
+	tracker.add()
+	tracker.add()
+
+	if got := []bool{tracker.done(), tracker.done()}; !reflect.DeepEqual(got, []bool{false, true}) {
+		t.Errorf("done() = %v, want [false true]", got)
+	}
+}
+
+func Test_frontier(t *testing.T) {
+	t.Run("Push does not block without a consumer", func(t *testing.T) {
+		f := newFrontier()
+
+		done := make(chan struct{})
+		go func() {
+			for i := 0; i < 10000; i++ {
+				f.push(fmt.Sprint(i))
+			}
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("push() blocked")
+		}
+	})
+
+	t.Run("URLs are dispatched in order and drained after close", func(t *testing.T) {
+		f := newFrontier()
+		f.push("a")
+		f.push("b")
+		f.push("c")
+		f.close()
+
+		if f.push("d") {
+			t.Errorf("push() = true after close")
+		}
+
+		go f.dispatch()
+
+		got := []string{}
+		for url := range f.ch {
+			got = append(got, url)
+		}
+
+		if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("dispatched %v, want %v", got, want)
+		}
+	})
+}
+
+func TestAnubis_largePage(t *testing.T) {
+	t.Run("A worker adding more URLs than any buffer could hold does not deadlock", func(t *testing.T) {
+		children := make([]string, 5000)
+		for i := range children {
+			children[i] = fmt.Sprint("asset-", i)
+		}
+
+		a := NewTestAnubis()
+		a.Workers = 1
+		processor := &SpawningProcessor{
+			anubis:          a,
+			children:        map[string][]string{"page": children},
+			StringProcessor: StringProcessor{mu: &sync.Mutex{}},
+		}
+		a.processor = processor
+
+		a.AddURL("page")
+		a.Start()
+
+		done := make(chan struct{})
+		go func() {
+			a.Wait()
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(30 * time.Second):
+			t.Fatalf("Wait() did not return")
+		}
+
+		if len(processor.results) != len(children)+1 {
+			t.Errorf("processed %v URLs, want %v", len(processor.results), len(children)+1)
+		}
+	})
+}
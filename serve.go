@@ -0,0 +1,27 @@
+package main
+
+import (
+	"anubis/pkg"
+	"flag"
+	"log"
+	"net/http"
+)
+
+// serve runs the replay server for an output directory until it fails
+func serve(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "The address to listen on")
+	dir := flags.String("dir", ".", "The output directory to serve")
+
+	if err := flags.Parse(args); err != nil {
+		panic(err)
+	}
+
+	server, err := anubis.NewReplayServer(*dir)
+	if err != nil {
+		panic(err)
+	}
+
+	log.Println("Serving", *dir, "on http://"+*addr+"/")
+	log.Fatalln(http.ListenAndServe(*addr, server))
+}